@@ -5,104 +5,11752 @@ Generates a complete Go web service with health and echo endpoints.
 
 GO_MAIN = """package main
 
-from typing import Dict, List, Tuple, Optional, Any, Union
 import (
-
-# Aurora Performance Optimization
-from concurrent.futures import ThreadPoolExecutor
-
-# High-performance parallel processing with ThreadPoolExecutor
-# Example: with ThreadPoolExecutor(max_workers=100) as executor:
-#             results = executor.map(process_func, items)
+        "bytes"
+        "context"
         "encoding/json"
+        "encoding/xml"
+        "errors"
         "fmt"
-        "log"
+        "io"
+        "log/slog"
         "net/http"
         "os"
+        "os/signal"
+        "runtime"
+        "runtime/debug"
+        "sort"
+        "strings"
+        "syscall"
         "time"
+        "unicode/utf8"
+)
+
+// Build metadata, injected with -ldflags "-X main.version=... -X main.commit=... -X main.buildTime=..."
+var (
+        version   = "dev"
+        commit    = "unknown"
+        buildTime = "unknown"
+)
+
+// serviceName identifies this instance in responses and logs; set from SERVICE_NAME
+var serviceName = "aurora-go-service"
+
+// maxMessageLen caps Echo.Message, counted in runes
+var maxMessageLen = 4096
+
+// maxBatchSize caps the number of messages accepted by /echo/batch
+var maxBatchSize = 100
+
+// maxMetadataKeys and maxMetadataBytes cap Echo.Metadata; bytes count keys plus values
+var (
+        maxMetadataKeys  = 20
+        maxMetadataBytes = 8 << 10
 )
 
+// reservedMetadataKeys are server-owned Echo fields that clients may not shadow in metadata
+var reservedMetadataKeys = map[string]bool{
+        "message":      true,
+        "timestamp":    true,
+        "received_at":  true,
+        "processed_at": true,
+        "service":      true,
+        "request_id":   true,
+        "trace_id":     true,
+}
+
 // Echo struct for JSON echo endpoint
 type Echo struct {
-        Message   string    `json:"message"`
-        Timestamp time.Time `json:"timestamp"`
-        Service   string    `json:"service"`
+        Message   string    `json:"message" xml:"message"`
+        Metadata  Metadata  `json:"metadata,omitempty" xml:"metadata,omitempty"`
+        Timestamp time.Time `json:"timestamp" xml:"timestamp"`
+
+        // ReceivedAt is when the request reached the service and ProcessedAt when the echo
+        // was ready to send; the difference is the server-side processing time. Timestamp
+        // equals ProcessedAt and stays for older clients.
+        ReceivedAt  time.Time `json:"received_at" xml:"received_at"`
+        ProcessedAt time.Time `json:"processed_at" xml:"processed_at"`
+
+        Service   string `json:"service" xml:"service"`
+        RequestID string `json:"request_id,omitempty" xml:"request_id,omitempty"`
+        TraceID   string `json:"trace_id,omitempty" xml:"trace_id,omitempty"`
+}
+
+// Metadata is free-form client data carried through an Echo unchanged. Values are strings
+// or, with PRESERVE_NUMBERS, json.Numbers, which encode back to the literal the client sent.
+type Metadata map[string]any
+
+// UnmarshalJSON decodes the metadata object. A numeric value is read as a json.Number, never
+// through float64, so large IDs and timestamps keep every digit and are echoed back as the
+// same JSON number; without PRESERVE_NUMBERS, validateEcho then refuses it.
+func (m *Metadata) UnmarshalJSON(data []byte) error {
+        var raw map[string]json.RawMessage
+        if err := json.Unmarshal(data, &raw); err != nil {
+                return err
+        }
+        if raw == nil {
+                return nil
+        }
+        out := make(Metadata, len(raw))
+        for k, v := range raw {
+                var str string
+                if err := json.Unmarshal(v, &str); err == nil {
+                        out[k] = str
+                        continue
+                }
+                var num json.Number
+                if err := json.Unmarshal(v, &num); err != nil {
+                        return fmt.Errorf("metadata value for %q must be a string or number", k)
+                }
+                out[k] = num
+        }
+        *m = out
+        return nil
+}
+
+// numericKey returns a key whose value is a number, or "" when every value is a string
+func (m Metadata) numericKey() string {
+        for k, v := range m {
+                if _, ok := v.(json.Number); ok {
+                        return k
+                }
+        }
+        return ""
+}
+
+// text returns the value under key as the client wrote it: a string's contents or a
+// number's literal
+func (m Metadata) text(key string) string {
+        switch v := m[key].(type) {
+        case string:
+                return v
+        case json.Number:
+                return v.String()
+        default:
+                return fmt.Sprint(v)
+        }
+}
+
+// MarshalXML renders the map as <entry key="...">value</entry> elements in key order,
+// since encoding/xml cannot encode maps on its own
+func (m Metadata) MarshalXML(enc *xml.Encoder, start xml.StartElement) error {
+        if len(m) == 0 {
+                return nil
+        }
+        keys := make([]string, 0, len(m))
+        for k := range m {
+                keys = append(keys, k)
+        }
+        sort.Strings(keys)
+
+        if err := enc.EncodeToken(start); err != nil {
+                return err
+        }
+        for _, k := range keys {
+                entry := xml.StartElement{
+                        Name: xml.Name{Local: "entry"},
+                        Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: k}},
+                }
+                if err := enc.EncodeElement(m.text(k), entry); err != nil {
+                        return err
+                }
+        }
+        return enc.EncodeToken(start.End())
+}
+
+// Validate checks the client-supplied fields of an Echo
+func (e Echo) Validate() error {
+        if strings.TrimSpace(e.Message) == "" {
+                return errors.New("message is required")
+        }
+        if utf8.RuneCountInString(e.Message) > maxMessageLen {
+                return fmt.Errorf("message exceeds %d characters", maxMessageLen)
+        }
+        return e.Metadata.validate()
+}
+
+// validateEcho is Echo.Validate plus the checks that depend on s's configuration
+func (s *Server) validateEcho(e Echo) error {
+        if !s.cfg.PreserveNumbers {
+                if k := e.Metadata.numericKey(); k != "" {
+                        return fmt.Errorf("metadata value for %q must be a string; numbers need PRESERVE_NUMBERS", k)
+                }
+        }
+        return e.Validate()
+}
+
+// validate enforces the metadata key and size limits and rejects reserved keys
+func (m Metadata) validate() error {
+        if len(m) > maxMetadataKeys {
+                return fmt.Errorf("metadata has %d keys, at most %d allowed", len(m), maxMetadataKeys)
+        }
+        size := 0
+        for k := range m {
+                if reservedMetadataKeys[k] {
+                        return fmt.Errorf("metadata key %q is reserved", k)
+                }
+                size += len(k) + len(m.text(k))
+        }
+        if size > maxMetadataBytes {
+                return fmt.Errorf("metadata exceeds %d bytes", maxMetadataBytes)
+        }
+        return nil
 }
 
 // Health check response
 type Health struct {
-        OK        bool      `json:"ok"`
-        Service   string    `json:"service"`
-        Version   string    `json:"version"`
-        Timestamp time.Time `json:"timestamp"`
+        OK        bool      `json:"ok" xml:"ok"`
+        Service   string    `json:"service" xml:"service"`
+        Version   string    `json:"version" xml:"version"`
+        Timestamp time.Time `json:"timestamp" xml:"timestamp"`
 }
 
-func healthHandler(w http.ResponseWriter, r *http.Request) {
-        w.Header().Set("Content-Type", "application/json")
-        w.WriteHeader(http.StatusOK)
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet && r.Method != http.MethodHead {
+                methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+                return
+        }
+
+        contentType, ok := negotiateContentType(r)
+        if !ok {
+                notAcceptable(w, r)
+                return
+        }
+
+        // HEAD gets the same status and headers with no body
+        if r.Method == http.MethodHead {
+                w.Header().Set("Content-Type", contentType)
+                w.WriteHeader(http.StatusOK)
+                return
+        }
 
         health := Health{
                 OK:        true,
-                Service:   "aurora-go-service",
-                Version:   "1.0.0",
-                Timestamp: time.Now(),
+                Service:   serviceName,
+                Version:   version,
+                Timestamp: s.clock.Now(),
         }
 
-        json.NewEncoder(w).Encode(health)
+        writeBody(w, r, http.StatusOK, contentType, health)
 }
 
-func echoHandler(w http.ResponseWriter, r *http.Request) {
-        w.Header().Set("Content-Type", "application/json")
+// DetailedHealth extends Health with process statistics and the registered health checks
+type DetailedHealth struct {
+        Health
+        Status         string             `json:"status"`
+        UptimeSeconds  float64            `json:"uptime_seconds"`
+        Goroutines     int                `json:"goroutines"`
+        HeapAllocBytes uint64             `json:"heap_alloc_bytes"`
+        Checks         []CheckResult      `json:"checks,omitempty"`
+        Dependencies   []DependencyStatus `json:"dependencies,omitempty"`
+}
+
+// DetailedHealth.Status values
+const (
+        healthHealthy   = "healthy"
+        healthDegraded  = "degraded"
+        healthUnhealthy = "unhealthy"
+)
+
+// handleDetailedHealth reports runtime stats, runs the health checks and adds the last polled
+// dependency states. A failing required check or required dependency answers 503 "unhealthy";
+// any other failure is reported as "degraded". ReadMemStats and the checks are costly so
+// probes should use /health.
+func (s *Server) handleDetailedHealth(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+                methodNotAllowed(w, r, http.MethodGet)
+                return
+        }
+
+        var mem runtime.MemStats
+        runtime.ReadMemStats(&mem)
+
+        checks, healthy := healthChecks.run(r.Context(), healthCheckTimeout)
+        if !healthy {
+                logCheckFailures(checks)
+        }
+        overall := healthHealthy
+        for _, c := range checks {
+                if !c.OK {
+                        overall = healthDegraded
+                }
+        }
+
+        var deps []DependencyStatus
+        if dependencies != nil {
+                deps = dependencies.snapshot()
+                down, requiredDown := dependenciesDown(deps)
+                if down {
+                        overall = healthDegraded
+                }
+                healthy = healthy && !requiredDown
+        }
+
+        status := http.StatusOK
+        if !healthy {
+                status = http.StatusServiceUnavailable
+                overall = healthUnhealthy
+        }
+
+        health := DetailedHealth{
+                Health: Health{
+                        OK:        healthy,
+                        Service:   serviceName,
+                        Version:   version,
+                        Timestamp: s.clock.Now(),
+                },
+                Status:         overall,
+                UptimeSeconds:  s.uptime().Seconds(),
+                Goroutines:     runtime.NumGoroutine(),
+                HeapAllocBytes: mem.HeapAlloc,
+                Checks:         checks,
+                Dependencies:   deps,
+        }
+
+        writeJSON(w, r, status, health)
+}
+
+// handleVersion reports the build metadata baked into the binary; it is stable between
+// deploys, so pollers can revalidate with If-None-Match and get 304s
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+                methodNotAllowed(w, r, http.MethodGet)
+                return
+        }
+
+        writeCacheable(w, r, map[string]string{
+                "version":    version,
+                "commit":     commit,
+                "build_time": buildTime,
+        })
+}
+
+// Info is the body of GET /info: what this process is running and which optional features
+// its loaded configuration turns on
+type Info struct {
+        Service   string            `json:"service"`
+        Version   string            `json:"version"`
+        Commit    string            `json:"commit"`
+        BuildTime string            `json:"build_time"`
+        GoVersion string            `json:"go_version"`
+        Features  map[string]bool   `json:"features"`
+        Modules   map[string]string `json:"modules,omitempty"`
+}
+
+// handleInfo serves GET /info. Unlike /version it can change without a deploy, since
+// SIGHUP reloads some of the features it reports, so it is not cacheable.
+func (s *Server) handleInfo(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+                methodNotAllowed(w, r, http.MethodGet)
+                return
+        }
+
+        features := currentConfig().features()
+        features["tracing"] = tracingEnabled
+
+        w.Header().Set("Cache-Control", "no-store")
+        writeJSON(w, r, http.StatusOK, Info{
+                Service:   serviceName,
+                Version:   version,
+                Commit:    commit,
+                BuildTime: buildTime,
+                GoVersion: runtime.Version(),
+                Features:  features,
+                Modules:   buildModules(),
+        })
+}
+
+// buildModules lists the module dependencies compiled into the binary with their versions
+func buildModules() map[string]string {
+        info, ok := debug.ReadBuildInfo()
+        if !ok || len(info.Deps) == 0 {
+                return nil
+        }
+        modules := make(map[string]string, len(info.Deps))
+        for _, dep := range info.Deps {
+                if dep.Replace != nil {
+                        dep = dep.Replace
+                }
+                modules[dep.Path] = dep.Version
+        }
+        return modules
+}
+
+// handleReady is the readiness probe; 503 until startup completes and during shutdown
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+                methodNotAllowed(w, r, http.MethodGet)
+                return
+        }
+
+        isReady := s.isReady()
+        status := http.StatusOK
+        if !isReady {
+                status = http.StatusServiceUnavailable
+        }
+
+        writeJSON(w, r, status, map[string]bool{"ready": isReady})
+}
+
+// methodNotAllowed answers 405 with an Allow header listing the accepted methods
+func methodNotAllowed(w http.ResponseWriter, r *http.Request, allowed ...string) {
+        list := strings.Join(allowed, ", ")
+        w.Header().Set("Allow", list)
+        httpError(w, r, http.StatusMethodNotAllowed, errMethodNotAllowed, "Method not allowed. Use "+list)
+}
+
+// coreEndpoints are the public routes every configuration serves
+var coreEndpoints = []string{
+        "GET /health",
+        "GET /health/detailed",
+        "GET /ready",
+        "GET /version",
+        "GET /info",
+        "GET /whoami",
+        "POST /echo",
+        "POST /echo/batch",
+        "POST /echo/form",
+        "POST /echo/stream",
+        "GET /messages",
+        "GET /messages/{id}",
+        "GET /events",
+        "GET /openapi.json",
+}
+
+// endpointSummary renders s.endpoints with the configured base path applied
+func (s *Server) endpointSummary() string {
+        routes := make([]string, len(s.endpoints))
+        for i, e := range s.endpoints {
+                method, path, _ := strings.Cut(e, " ")
+                routes[i] = method + " " + basePath + path
+        }
+        return strings.Join(routes, ", ")
+}
+
+// notFound answers a JSON 404 for the request path
+func notFound(w http.ResponseWriter, r *http.Request) {
+        httpError(w, r, http.StatusNotFound, errNotFound, "no route for "+r.URL.Path)
+}
+
+// handleRoot serves the service banner at exactly "/" and a JSON 404 for any other unmatched path
+func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path != "/" {
+                notFound(w, r)
+                return
+        }
+
+        if r.Method != http.MethodGet && r.Method != http.MethodHead {
+                methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+                return
+        }
+
+        // With ENABLE_UI, browsers get the HTML landing page and API clients the JSON banner
+        if s.landingPage != nil && s.landingPage(w, r) {
+                return
+        }
+
+        if r.Method == http.MethodHead {
+                w.Header().Set("Content-Type", contentTypeJSON)
+                w.WriteHeader(http.StatusOK)
+                return
+        }
+
+        writeJSON(w, r, http.StatusOK, map[string]string{
+                "service":   serviceName,
+                "endpoints": s.endpointSummary(),
+        })
+}
 
+func (s *Server) handleEcho(w http.ResponseWriter, r *http.Request) {
         if r.Method != http.MethodPost {
-                w.WriteHeader(http.StatusMethodNotAllowed)
-                json.NewEncoder(w).Encode(map[string]string{
-                        "error": "Method not allowed. Use POST",
-                })
+                methodNotAllowed(w, r, http.MethodPost)
+                return
+        }
+
+        contentType, ok := negotiateContentType(r)
+        if !ok {
+                notAcceptable(w, r)
+                return
+        }
+        algorithm, ok := checksumAlgorithm(w, r)
+        if !ok {
+                return
+        }
+
+        echo, ok := s.decodeEcho(w, r)
+        if !ok || clientGone(w, r, "decoded") {
+                return
+        }
+
+        s.stampEcho(&echo, r)
+        // A checksum request is a transmission check, so the message is neither stored
+        // nor published to /events
+        if algorithm != "" {
+                if clientGone(w, r, "encoding") {
+                        return
+                }
+                writeBody(w, r, http.StatusOK, contentType, newEchoChecksum(echo, algorithm))
+                return
+        }
+        if !s.saveEcho(w, r, echo) {
+                return
+        }
+
+        if clientGone(w, r, "encoding") {
                 return
         }
+        writeBody(w, r, http.StatusOK, contentType, echo)
+}
+
+// decodeEcho reads, validates and transforms the Echo in the request body. On failure it
+// has already written the error response and returns false.
+func (s *Server) decodeEcho(w http.ResponseWriter, r *http.Request) (Echo, bool) {
+        if !requireJSONBody(w, r) {
+                return Echo{}, false
+        }
 
         var echo Echo
-        if err := json.NewDecoder(r.Body).Decode(&echo); err != nil {
-                w.WriteHeader(http.StatusBadRequest)
-                json.NewEncoder(w).Encode(map[string]string{
-                        "error": fmt.Sprintf("Invalid JSON: %v", err),
-                })
+        body, err := io.ReadAll(r.Body)
+        if err != nil {
+                var maxErr *http.MaxBytesError
+                if errors.As(err, &maxErr) {
+                        httpError(w, r, http.StatusRequestEntityTooLarge, errBodyTooLarge, fmt.Sprintf("Request body exceeds %d bytes", maxErr.Limit))
+                        return echo, false
+                }
+                httpError(w, r, http.StatusBadRequest, errInvalidJSON, fmt.Sprintf("reading request body: %v", err))
+                return echo, false
+        }
+        if len(bytes.TrimSpace(body)) == 0 {
+                httpError(w, r, http.StatusBadRequest, errEmptyBody, "request body is empty")
+                return echo, false
+        }
+
+        if rejectDuplicateKeys {
+                if key := duplicateKey(body); key != "" {
+                        httpError(w, r, http.StatusBadRequest, errDuplicateKey, fmt.Sprintf("duplicate key %q in request body", key))
+                        return echo, false
+                }
+        }
+
+        if schemaValidation {
+                violations, err := validateEchoSchema(body, s.cfg.PreserveNumbers)
+                if err != nil {
+                        httpError(w, r, http.StatusBadRequest, errInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+                        return echo, false
+                }
+                if len(violations) > 0 {
+                        httpErrorDetails(w, r, http.StatusBadRequest, errValidation, "message does not match the Echo schema", violations)
+                        return echo, false
+                }
+        }
+
+        // Reject unknown fields so client typos surface as 400s
+        dec := json.NewDecoder(bytes.NewReader(body))
+        dec.DisallowUnknownFields()
+        if err := dec.Decode(&echo); err != nil {
+                httpError(w, r, http.StatusBadRequest, errInvalidJSON, fmt.Sprintf("Invalid JSON: %v", err))
+                return echo, false
+        }
+
+        if err := s.validateEcho(echo); err != nil {
+                httpError(w, r, http.StatusBadRequest, errValidation, err.Error())
+                return echo, false
+        }
+
+        return echo, s.applyTransform(w, r, &echo)
+}
+
+// applyTransform rewrites echo.Message with the ?transform= chain, if any. An invalid
+// chain is answered with 400 and reported as false.
+func (s *Server) applyTransform(w http.ResponseWriter, r *http.Request, echo *Echo) bool {
+        spec := r.URL.Query().Get("transform")
+        transform, err := transforms.pipeline(spec)
+        if err != nil {
+                httpError(w, r, http.StatusBadRequest, errUnknownTransform, err.Error())
+                return false
+        }
+        if spec == "" {
+                return true
+        }
+        now := s.clock.Now()
+        if result, ok := s.transformResults.get(spec, echo.Message, now); ok {
+                echo.Message = result
+                return true
+        }
+        result := transform(echo.Message)
+        s.transformResults.put(spec, echo.Message, result, now)
+        echo.Message = result
+        return true
+}
+
+// stampEcho adds the server-side metadata to an accepted Echo. ReceivedAt comes from
+// receivedAtMiddleware unless the caller already set it.
+func (s *Server) stampEcho(echo *Echo, r *http.Request) {
+        echo.ProcessedAt = s.clock.Now()
+        if echo.ReceivedAt.IsZero() {
+                receivedAt, ok := receivedAtFromContext(r.Context())
+                if !ok {
+                        receivedAt = echo.ProcessedAt
+                }
+                echo.ReceivedAt = receivedAt
+        }
+        echo.Timestamp = echo.ProcessedAt
+        echo.Service = serviceName
+        echo.RequestID = requestIDFromContext(r.Context())
+        echo.TraceID = traceIDFromContext(r.Context())
+}
+
+// clientGone reports whether the client hung up before stage, abandoning the request if so.
+// Only the 499 status is written, for the access log and metrics; encoding a body nobody will
+// read is wasted work. A server-side deadline is not a hang-up: timeoutMiddleware answers it.
+func clientGone(w http.ResponseWriter, r *http.Request, stage string) bool {
+        if !errors.Is(r.Context().Err(), context.Canceled) {
+                return false
+        }
+        slog.Debug("client disconnected, abandoning request",
+                "path", r.URL.Path,
+                "stage", stage,
+                "request_id", requestIDFromContext(r.Context()))
+        w.WriteHeader(statusClientClosedRequest)
+        return true
+}
+
+// /echo/batch ?mode= values
+const (
+        batchModeAll     = "all-or-nothing"
+        batchModePartial = "partial"
+)
+
+// BatchError reports one element rejected from a ?mode=partial batch
+type BatchError struct {
+        Index   int      `json:"index"`
+        Code    string   `json:"code"`
+        Message string   `json:"message"`
+        Details []string `json:"details,omitempty"`
+}
+
+// BatchResult is the body of a ?mode=partial batch: the echoes of the valid elements, in
+// order, and why each of the others was rejected
+type BatchResult struct {
+        Echoes []Echo       `json:"echoes"`
+        Errors []BatchError `json:"errors"`
+}
+
+// handleEchoBatch echoes a JSON array of messages. By default any bad element rejects the
+// whole batch; with ?mode=partial the good ones are echoed anyway and the answer is 207
+// when some were rejected. NDJSON bodies always report bad lines individually.
+func (s *Server) handleEchoBatch(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodPost {
+                methodNotAllowed(w, r, http.MethodPost)
                 return
         }
 
-        // Add metadata
-        echo.Timestamp = time.Now()
-        echo.Service = "aurora-go-service"
+        mode := r.URL.Query().Get("mode")
+        switch mode {
+        case "":
+                mode = batchModeAll
+        case batchModeAll, batchModePartial:
+        default:
+                httpError(w, r, http.StatusBadRequest, errInvalidParameter, fmt.Sprintf("invalid mode %q: want %s or %s", mode, batchModeAll, batchModePartial))
+                return
+        }
 
-        w.WriteHeader(http.StatusOK)
-        json.NewEncoder(w).Encode(echo)
+        // NDJSON bodies are streamed line by line instead of buffered as one array
+        if isNDJSON(r) {
+                s.echoBatchNDJSON(w, r)
+                return
+        }
+        if !requireJSONBody(w, r) {
+                return
+        }
+
+        var items []json.RawMessage
+        if err := json.NewDecoder(r.Body).Decode(&items); err != nil {
+                var maxErr *http.MaxBytesError
+                if errors.As(err, &maxErr) {
+                        httpError(w, r, http.StatusRequestEntityTooLarge, errBodyTooLarge, fmt.Sprintf("Request body exceeds %d bytes", maxErr.Limit))
+                        return
+                }
+                if errors.Is(err, io.EOF) {
+                        httpError(w, r, http.StatusBadRequest, errEmptyBody, "request body is empty")
+                        return
+                }
+                httpError(w, r, http.StatusBadRequest, errInvalidJSON, fmt.Sprintf("Invalid JSON: expected an array of messages: %v", err))
+                return
+        }
+
+        if len(items) == 0 || len(items) > maxBatchSize {
+                httpError(w, r, http.StatusBadRequest, errInvalidBatch, fmt.Sprintf("batch must contain between 1 and %d messages, got %d", maxBatchSize, len(items)))
+                return
+        }
+
+        echoes := make([]Echo, 0, len(items))
+        var rejected []BatchError
+        for i, raw := range items {
+                echo, itemErr := s.decodeBatchItem(raw)
+                if itemErr != nil {
+                        if mode == batchModeAll {
+                                httpErrorDetails(w, r, http.StatusBadRequest, itemErr.code, fmt.Sprintf("element %d: %s", i, itemErr.message), itemErr.details)
+                                return
+                        }
+                        rejected = append(rejected, BatchError{Index: i, Code: itemErr.code, Message: itemErr.message, Details: itemErr.details})
+                        continue
+                }
+                s.stampEcho(&echo, r)
+                echoes = append(echoes, echo)
+        }
+        if clientGone(w, r, "decoded") {
+                return
+        }
+        for _, echo := range echoes {
+                if !s.saveEcho(w, r, echo) {
+                        return
+                }
+        }
+
+        if clientGone(w, r, "encoding") {
+                return
+        }
+        if mode == batchModePartial {
+                status := http.StatusOK
+                if len(rejected) > 0 {
+                        status = http.StatusMultiStatus
+                } else {
+                        rejected = []BatchError{}
+                }
+                writeJSON(w, r, status, BatchResult{Echoes: echoes, Errors: rejected})
+                return
+        }
+        writeJSON(w, r, http.StatusOK, echoes)
+}
+
+// batchItemError is why one /echo/batch message was rejected
+type batchItemError struct {
+        code    string
+        message string
+        details []string
+}
+
+// decodeBatchItem applies the /echo checks to one batch message
+func (s *Server) decodeBatchItem(raw []byte) (Echo, *batchItemError) {
+        var echo Echo
+        if rejectDuplicateKeys {
+                if key := duplicateKey(raw); key != "" {
+                        return echo, &batchItemError{code: errDuplicateKey, message: fmt.Sprintf("duplicate key %q", key)}
+                }
+        }
+        if schemaValidation {
+                violations, err := validateEchoSchema(raw, s.cfg.PreserveNumbers)
+                if err != nil {
+                        return echo, &batchItemError{code: errInvalidJSON, message: fmt.Sprintf("Invalid JSON: %v", err)}
+                }
+                if len(violations) > 0 {
+                        return echo, &batchItemError{code: errValidation, message: "message does not match the Echo schema", details: violations}
+                }
+        }
+
+        dec := json.NewDecoder(bytes.NewReader(raw))
+        dec.DisallowUnknownFields()
+        if err := dec.Decode(&echo); err != nil {
+                return echo, &batchItemError{code: errInvalidJSON, message: fmt.Sprintf("Invalid JSON: %v", err)}
+        }
+        if err := s.validateEcho(echo); err != nil {
+                return echo, &batchItemError{code: errValidation, message: err.Error()}
+        }
+        return echo, nil
+}
+
+// logLevel backs the default logger so the level can be adjusted at runtime
+var logLevel = new(slog.LevelVar)
+
+// setupLogger installs the default slog logger in the configured format and level
+func setupLogger(cfg Config) {
+        logLevel.Set(cfg.LogLevel)
+
+        opts := &slog.HandlerOptions{Level: logLevel}
+        var handler slog.Handler = slog.NewTextHandler(os.Stdout, opts)
+        if cfg.LogFormat == "json" {
+                handler = slog.NewJSONHandler(os.Stdout, opts)
+        }
+
+        slog.SetDefault(slog.New(handler).With("service", serviceName))
+}
+
+// fatal logs msg at error level and exits the process
+func fatal(msg string, args ...any) {
+        slog.Error(msg, args...)
+        os.Exit(1)
 }
 
 func main() {
-        mux := http.NewServeMux()
+        cfg, err := loadConfig()
+        if err != nil {
+                fatal("invalid configuration", "error", err)
+        }
+        applyConfig(cfg)
+        setupLogger(cfg)
+        slog.Info("configuration", "config", cfg)
 
-        // Register handlers
-        mux.HandleFunc("/health", healthHandler)
-        mux.HandleFunc("/echo", echoHandler)
-        mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-                w.Header().Set("Content-Type", "application/json")
-                json.NewEncoder(w).Encode(map[string]string{
-                        "service": "Aurora Go Service",
-                        "endpoints": "GET /health, POST /echo",
-                })
-        })
+        if cfg.AccessLogFile != "" {
+                accessLogs, err = openAccessLog(cfg.AccessLogFile)
+                if err != nil {
+                        fatal("opening access log failed", "path", cfg.AccessLogFile, "error", err)
+                }
+                flushDone := make(chan struct{})
+                go accessLogs.flushLoop(flushDone, accessLogFlushInterval)
+                // Runs after the servers have drained, so the last requests are written too
+                defer func() {
+                        close(flushDone)
+                        if err := accessLogs.close(); err != nil {
+                                slog.Warn("closing access log failed", "path", cfg.AccessLogFile, "error", err)
+                        }
+                }()
+                slog.Info("access log enabled", "path", cfg.AccessLogFile)
+        }
+
+        var store Store = newMessageStore(cfg.MessageStoreSize)
+        if cfg.StoreBackend == storeBackendFile {
+                fileStore, loaded, skipped, err := openFileStore(cfg.StoreFile, cfg.MessageStoreSize)
+                if err != nil {
+                        fatal("opening message store failed", "path", cfg.StoreFile, "error", err)
+                }
+                if skipped > 0 {
+                        slog.Warn("message store had unreadable lines, skipped them", "path", cfg.StoreFile, "skipped", skipped)
+                }
+                store = fileStore
+                defer func() {
+                        if err := fileStore.close(); err != nil {
+                                slog.Warn("closing message store failed", "path", cfg.StoreFile, "error", err)
+                        }
+                }()
+                slog.Info("message store loaded", "path", cfg.StoreFile, "messages", loaded)
+        }
+
+        shutdownTracing, err := setupTracing(context.Background())
+        if err != nil {
+                fatal("tracing setup failed", "error", err)
+        }
+        if tracingEnabled {
+                slog.Info("tracing enabled", "endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"))
+        }
+
+        ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+        defer stop()
+
+        if len(cfg.Dependencies) > 0 {
+                dependencies = newDependencyMonitor(cfg.Dependencies, cfg.RequiredDependencies, cfg.DependencyPollInterval, cfg.DependencyPollTimeout)
+                go dependencies.run(ctx)
+                slog.Info("polling dependencies",
+                        "dependencies", cfg.dependencyNames(),
+                        "interval", cfg.DependencyPollInterval.String())
+        }
+        registerBuiltinHealthChecks(cfg)
+
+        s := newServer(cfg, store, systemClock{})
+        s.stop = stop
+        // SIGHUP reloads log level, rate limits, body caps and response caching without dropping connections
+        s.watchReload()
+        if err := s.run(ctx); err != nil {
+                fatal("server failed", "error", err)
+        }
+
+        // Spans are flushed last so those of the final requests are exported too
+        flushCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+        defer cancel()
+        if err := shutdownTracing(flushCtx); err != nil {
+                slog.Warn("flushing traces failed", "error", err)
+        }
+}
 
-        // Start server
-        port := os.Getenv("PORT")
-        if port == "" {
-                port = "8080"
+// enterShutdown flips /ready to 503 at once, then keeps serving normally for delay so load
+// balancers can deregister the instance before it starts turning requests away. Kubernetes
+// keeps routing to a pod for a few seconds after SIGTERM; PRE_SHUTDOWN_DELAY covers that gap.
+func (s *Server) enterShutdown(delay time.Duration) {
+        s.markDraining()
+        if delay > 0 {
+                slog.Info("marked unready, still serving until the pre-shutdown delay ends", "delay", delay.String())
+                time.Sleep(delay)
         }
-        log.Printf("[ROCKET] Aurora Go Service starting on port %s", port)
-        log.Printf("[EMOJI] Endpoints: GET /health, POST /echo")
+        s.shuttingDown.Store(true)
+}
+
+// shutdownServer gracefully stops srv, forcing it closed if ctx expires first, and
+// reports whether the graceful path succeeded
+func (s *Server) shutdownServer(ctx context.Context, name string, srv *http.Server) bool {
+        if err := srv.Shutdown(ctx); err != nil {
+                slog.Warn("graceful shutdown incomplete, forcing close",
+                        "server", name,
+                        "in_flight", s.inFlight.Load(),
+                        "error", err)
+                if err := srv.Close(); err != nil {
+                        slog.Error("forced close failed", "server", name, "error", err)
+                }
+                return false
+        }
+        return true
+}
+
+// drainLogInterval is how often shutdown reports the requests it is still waiting on
+const drainLogInterval = time.Second
+
+// reportDrain logs the in-flight request count every interval until done is closed
+func (s *Server) reportDrain(done <-chan struct{}, interval time.Duration) {
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+        for {
+                select {
+                case <-done:
+                        return
+                case <-ticker.C:
+                        slog.Info("draining", "in_flight", s.inFlight.Load())
+                }
+        }
+}
+"""
+
+GO_MIDDLEWARE = r"""package main
+
+import (
+        "bytes"
+        "compress/gzip"
+        "context"
+        "crypto/rand"
+        "encoding/hex"
+        "fmt"
+        "log/slog"
+        "net/http"
+        "net/url"
+        "runtime/debug"
+        "strconv"
+        "strings"
+        "sync"
+        "sync/atomic"
+        "time"
+)
+
+// requestIDHeader carries the correlation ID in both directions
+const requestIDHeader = "X-Request-ID"
+
+type ctxKey int
+
+const (
+        requestIDKey ctxKey = iota
+        receivedAtKey
+        authUserKey
+)
+
+// corsAllowedOrigins lists origins permitted for cross-origin requests; "*" allows any
+var corsAllowedOrigins []string
+
+// gzipMinBytes is the smallest response body worth compressing
+var gzipMinBytes = 1024
+
+// noTimeoutPrefixes lists long-running routes that manage their own duration
+var noTimeoutPrefixes = []string{"/debug/pprof/", "/events", "/echo/stream", "/ws/"}
+
+// responseWriter records the status code written by a handler
+type responseWriter struct {
+        http.ResponseWriter
+        status      int
+        wroteHeader bool
+        bytes       int64
+}
+
+func newResponseWriter(w http.ResponseWriter) *responseWriter {
+        return &responseWriter{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (rw *responseWriter) WriteHeader(code int) {
+        if rw.wroteHeader {
+                return
+        }
+        rw.status = code
+        rw.wroteHeader = true
+        rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+        if !rw.wroteHeader {
+                rw.WriteHeader(http.StatusOK)
+        }
+        n, err := rw.ResponseWriter.Write(b)
+        rw.bytes += int64(n)
+        return n, err
+}
+
+// Flush lets streaming handlers push data through the wrapper
+func (rw *responseWriter) Flush() {
+        if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+                f.Flush()
+        }
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+        return rw.ResponseWriter
+}
+
+// slowRequestThreshold is SLOW_REQUEST_THRESHOLD; zero turns slow-request warnings off
+var slowRequestThreshold time.Duration
+
+// loggingMiddleware emits one log line per request and records it in the metrics and stats
+// under its route label from mux, so per-ID paths do not each become a series. bytes_out
+// counts the body as sent, after compression. Requests slower than slowRequestThreshold
+// are also logged as warnings.
+func loggingMiddleware(mux *http.ServeMux, stats *statsCollector, next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                start := time.Now()
+                rw := newResponseWriter(w)
+
+                next.ServeHTTP(rw, r)
+
+                dur := time.Since(start)
+                route := routeLabel(mux, r)
+                observeRequest(route, rw.status, dur, rw.bytes, traceIDFromContext(r.Context()))
+                stats.observe(route, rw.status, dur)
+                accessLogger().Info("request",
+                        "method", r.Method,
+                        "route", route,
+                        "path", r.URL.Path,
+                        "client_ip", clientIP(r),
+                        "status", rw.status,
+                        "duration_ms", float64(dur.Microseconds())/1000,
+                        "bytes_out", rw.bytes,
+                        "request_id", requestIDFromContext(r.Context()))
+                if slowRequestThreshold > 0 && dur >= slowRequestThreshold {
+                        slog.Warn("slow request",
+                                "method", r.Method,
+                                "route", route,
+                                "status", rw.status,
+                                "duration_ms", float64(dur.Microseconds())/1000,
+                                "threshold", slowRequestThreshold.String(),
+                                "request_id", requestIDFromContext(r.Context()))
+                }
+        })
+}
+
+// recoverMiddleware turns handler panics into a logged 500 instead of a dropped connection
+func recoverMiddleware(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                defer func() {
+                        rec := recover()
+                        if rec == nil {
+                                return
+                        }
+                        if rec == http.ErrAbortHandler {
+                                panic(rec)
+                        }
+
+                        slog.Error("panic serving request",
+                                "method", r.Method,
+                                "path", r.URL.Path,
+                                "request_id", requestIDFromContext(r.Context()),
+                                "panic", fmt.Sprint(rec),
+                                "stack", string(debug.Stack()))
+
+                        httpError(w, r, http.StatusInternalServerError, errInternal, "internal server error")
+                }()
+
+                next.ServeHTTP(w, r)
+        })
+}
+
+// timeoutWriter buffers a handler's response so a timeout can still replace it with a 503
+type timeoutWriter struct {
+        w    http.ResponseWriter
+        h    http.Header
+        buf  bytes.Buffer
+        code int
+
+        mu       sync.Mutex
+        timedOut bool
+}
+
+func (tw *timeoutWriter) Header() http.Header { return tw.h }
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+        tw.mu.Lock()
+        defer tw.mu.Unlock()
+        if tw.timedOut || tw.code != 0 {
+                return
+        }
+        tw.code = code
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+        tw.mu.Lock()
+        defer tw.mu.Unlock()
+        if tw.timedOut {
+                return 0, http.ErrHandlerTimeout
+        }
+        if tw.code == 0 {
+                tw.code = http.StatusOK
+        }
+        return tw.buf.Write(b)
+}
+
+// routeLimit overrides the global request limits for one route. A non-zero field beats
+// the global default (MAX_BODY_BYTES, REQUEST_TIMEOUT); a zero field inherits it.
+type routeLimit struct {
+        maxBodyBytes int64
+        timeout      time.Duration
+}
+
+// routeLimitsFor maps exact request paths, after the base path is stripped, to cfg's overrides
+func routeLimitsFor(cfg Config) map[string]routeLimit {
+        return map[string]routeLimit{
+                "/echo/batch": {maxBodyBytes: cfg.EchoBatchMaxBodyBytes, timeout: cfg.EchoBatchRequestTimeout},
+        }
+}
+
+// bodyLimitFor returns the request body cap for path under the live configuration
+func bodyLimitFor(path string) int64 {
+        cfg := currentConfig()
+        if l := cfg.routeLimits[path]; l.maxBodyBytes > 0 {
+                return l.maxBodyBytes
+        }
+        return cfg.MaxBodyBytes
+}
+
+// timeoutFor returns the handler deadline for path; zero or less means none
+func timeoutFor(path string) time.Duration {
+        cfg := currentConfig()
+        if l := cfg.routeLimits[path]; l.timeout > 0 {
+                return l.timeout
+        }
+        return cfg.RequestTimeout
+}
+
+// bodyLimitMiddleware caps each request body at its route's limit; reads past it fail
+// with *http.MaxBytesError, which handlers turn into 413
+func bodyLimitMiddleware(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                r.Body = http.MaxBytesReader(w, r.Body, bodyLimitFor(r.URL.Path))
+                next.ServeHTTP(w, r)
+        })
+}
+
+// timeoutMiddleware bounds each request with its route's timeout and answers 503 when it fires.
+// The deadline is also set on the request context so handlers can stop work early. Keep
+// REQUEST_TIMEOUT below WRITE_TIMEOUT: once the connection's write deadline passes the 503
+// can no longer be delivered and the client just sees the connection drop.
+func timeoutMiddleware(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                timeout := timeoutFor(r.URL.Path)
+                // NDJSON batches stream their results, which the buffering timeoutWriter would hold back
+                if timeout <= 0 || hasAnyPrefix(r.URL.Path, noTimeoutPrefixes) || isNDJSON(r) {
+                        next.ServeHTTP(w, r)
+                        return
+                }
+
+                ctx, cancel := context.WithTimeout(r.Context(), timeout)
+                defer cancel()
+
+                tw := &timeoutWriter{w: w, h: make(http.Header)}
+                done := make(chan struct{})
+                panicCh := make(chan any, 1)
+                go func() {
+                        defer func() {
+                                if p := recover(); p != nil {
+                                        panicCh <- p
+                                }
+                        }()
+                        next.ServeHTTP(tw, r.WithContext(ctx))
+                        close(done)
+                }()
+
+                select {
+                case p := <-panicCh:
+                        panic(p)
+                case <-done:
+                        tw.mu.Lock()
+                        defer tw.mu.Unlock()
+                        dst := w.Header()
+                        for k, vv := range tw.h {
+                                dst[k] = vv
+                        }
+                        if tw.code == 0 {
+                                tw.code = http.StatusOK
+                        }
+                        w.WriteHeader(tw.code)
+                        w.Write(tw.buf.Bytes())
+                case <-ctx.Done():
+                        tw.mu.Lock()
+                        defer tw.mu.Unlock()
+                        tw.timedOut = true
+                        slog.Warn("request timed out",
+                                "method", r.Method,
+                                "path", r.URL.Path,
+                                "timeout", timeout.String(),
+                                "request_id", requestIDFromContext(r.Context()))
+                        httpError(w, r, http.StatusServiceUnavailable, errTimeout, "request timeout")
+                }
+        })
+}
+
+// basePath is an optional URL prefix (e.g. /api/aurora) under which all routes are mounted
+var basePath string
+
+// normalizeBasePath returns p as "/segment[/segment...]" with no trailing slash, or "" for the root
+func normalizeBasePath(p string) string {
+        p = strings.Trim(strings.TrimSpace(p), "/")
+        if p == "" {
+                return ""
+        }
+        return "/" + p
+}
+
+// withBasePath strips basePath before any other middleware sees the request, so routes and
+// path-based rules stay prefix-agnostic. Requests outside the prefix get a JSON 404.
+func withBasePath(next http.Handler) http.Handler {
+        if basePath == "" {
+                return next
+        }
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                rest, ok := strings.CutPrefix(r.URL.Path, basePath)
+                if !ok || (rest != "" && rest[0] != '/') {
+                        notFound(w, r)
+                        return
+                }
+                if rest == "" {
+                        rest = "/"
+                }
+
+                r2 := new(http.Request)
+                *r2 = *r
+                r2.URL = new(url.URL)
+                *r2.URL = *r.URL
+                r2.URL.Path = rest
+                r2.URL.RawPath = ""
+                next.ServeHTTP(w, r2)
+        })
+}
+
+// secureHeaders is the default set added to every response; HSTS only makes sense over TLS
+func secureHeaders(tls bool) map[string]string {
+        h := map[string]string{
+                "X-Content-Type-Options": "nosniff",
+                "X-Frame-Options":        "DENY",
+                "Referrer-Policy":        "no-referrer",
+        }
+        if tls {
+                h["Strict-Transport-Security"] = "max-age=63072000; includeSubDomains"
+        }
+        return h
+}
+
+// defaultHeadersMiddleware sets headers on every response before the handler runs, so a
+// handler that sets the same header wins
+func defaultHeadersMiddleware(headers map[string]string, next http.Handler) http.Handler {
+        if len(headers) == 0 {
+                return next
+        }
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                h := w.Header()
+                for name, value := range headers {
+                        h.Set(name, value)
+                }
+                next.ServeHTTP(w, r)
+        })
+}
+
+// maxHeaderBytes caps a request's header block; set from MAX_HEADER_BYTES
+var maxHeaderBytes = 1 << 20
+
+// headerLimitSlack is how far past MAX_HEADER_BYTES the server itself reads headers.
+// net/http answers 431 on its own, unlogged, once a header block passes its limit, so its
+// limit sits above ours: headerLimitMiddleware then sees, logs and rejects most oversized
+// requests, while anything beyond the slack is still refused before it reaches memory.
+const headerLimitSlack = 64 << 10
+
+// serverHeaderLimit is the http.Server.MaxHeaderBytes matching a MAX_HEADER_BYTES of limit
+func serverHeaderLimit(limit int) int {
+        return limit + headerLimitSlack
+}
+
+// headerBytes approximates the wire size of r's request line and headers
+func headerBytes(r *http.Request) (total int, largest string) {
+        total = len(r.Method) + len(r.RequestURI) + len(r.Proto) + 4
+        total += len("Host: ") + len(r.Host) + 2
+        largestSize := 0
+        for name, values := range r.Header {
+                size := 0
+                for _, v := range values {
+                        size += len(name) + len(v) + 4
+                }
+                total += size
+                if size > largestSize {
+                        largest, largestSize = name, size
+                }
+        }
+        return total, largest
+}
+
+// headerLimitMiddleware answers 431 when the headers exceed maxHeaderBytes, logging who sent them
+func headerLimitMiddleware(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                size, largest := headerBytes(r)
+                if size <= maxHeaderBytes {
+                        next.ServeHTTP(w, r)
+                        return
+                }
+                slog.Warn("request headers too large",
+                        "client_ip", clientIP(r),
+                        "path", r.URL.Path,
+                        "header_bytes", size,
+                        "limit", maxHeaderBytes,
+                        "headers", len(r.Header),
+                        "largest_header", largest,
+                        "request_id", requestIDFromContext(r.Context()))
+                w.Header().Set("Connection", "close")
+                httpError(w, r, http.StatusRequestHeaderFieldsTooLarge, errHeadersTooLarge,
+                        fmt.Sprintf("request headers exceed %d bytes", maxHeaderBytes))
+        })
+}
+
+// maxURLLength caps the request target, path and query together; set from MAX_URL_LENGTH
+var maxURLLength = 8 << 10
+
+// loggedPathBytes is how much of an over-long path is logged
+const loggedPathBytes = 256
+
+// urlLimitMiddleware answers 414 when the request target as sent exceeds maxURLLength, so a
+// huge query such as a ?transform= chain is turned away before anything parses it
+func urlLimitMiddleware(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                if len(r.RequestURI) <= maxURLLength {
+                        next.ServeHTTP(w, r)
+                        return
+                }
+                path := r.URL.Path
+                if len(path) > loggedPathBytes {
+                        path = path[:loggedPathBytes] + "..."
+                }
+                slog.Warn("request URL too long",
+                        "client_ip", clientIP(r),
+                        "path", path,
+                        "url_bytes", len(r.RequestURI),
+                        "query_bytes", len(r.URL.RawQuery),
+                        "limit", maxURLLength,
+                        "request_id", requestIDFromContext(r.Context()))
+                httpError(w, r, http.StatusRequestURITooLong, errURITooLong,
+                        fmt.Sprintf("request URL exceeds %d bytes", maxURLLength))
+        })
+}
+
+// shutdownRetryAfter is the Retry-After hint, in seconds, sent while shutting down
+const shutdownRetryAfter = "5"
+
+// shutdownExemptPrefixes keep answering during shutdown so probes see the real status
+var shutdownExemptPrefixes = []string{"/health", "/ready"}
+
+// shutdownMiddleware answers requests that arrive after shutdown began with 503,
+// Retry-After and Connection: close, so clients on kept-alive connections reconnect
+// and land on another instance. Requests already past this point run to completion.
+func shutdownMiddleware(shuttingDown *atomic.Bool, next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                if !shuttingDown.Load() || hasAnyPrefix(r.URL.Path, shutdownExemptPrefixes) {
+                        next.ServeHTTP(w, r)
+                        return
+                }
+                w.Header().Set("Connection", "close")
+                w.Header().Set("Retry-After", shutdownRetryAfter)
+                httpError(w, r, http.StatusServiceUnavailable, errShuttingDown, "server is shutting down")
+        })
+}
+
+// hasAnyPrefix reports whether path starts with any of prefixes
+func hasAnyPrefix(path string, prefixes []string) bool {
+        for _, p := range prefixes {
+                if strings.HasPrefix(path, p) {
+                        return true
+                }
+        }
+        return false
+}
+
+// requestIDMiddleware propagates or generates an X-Request-ID for every request
+func requestIDMiddleware(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                id := r.Header.Get(requestIDHeader)
+                if !validRequestID(id) {
+                        id = newRequestID()
+                }
+
+                w.Header().Set(requestIDHeader, id)
+                ctx := context.WithValue(r.Context(), requestIDKey, id)
+                next.ServeHTTP(w, r.WithContext(ctx))
+        })
+}
+
+// receivedAtMiddleware notes when each request arrived by clock, before any other middleware runs
+func receivedAtMiddleware(clock Clock, next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                ctx := context.WithValue(r.Context(), receivedAtKey, clock.Now())
+                next.ServeHTTP(w, r.WithContext(ctx))
+        })
+}
+
+// receivedAtFromContext returns the arrival time stored by receivedAtMiddleware, or false
+// for a request that did not pass through it
+func receivedAtFromContext(ctx context.Context) (time.Time, bool) {
+        t, ok := ctx.Value(receivedAtKey).(time.Time)
+        return t, ok
+}
+
+// requestIDFromContext returns the request ID stored by requestIDMiddleware, or ""
+func requestIDFromContext(ctx context.Context) string {
+        id, _ := ctx.Value(requestIDKey).(string)
+        return id
+}
+
+// newRequestID returns a random 128-bit hex string
+func newRequestID() string {
+        b := make([]byte, 16)
+        if _, err := rand.Read(b); err != nil {
+                return "unknown"
+        }
+        return hex.EncodeToString(b)
+}
+
+// validRequestID accepts short, printable client IDs so they are safe to log and echo
+func validRequestID(id string) bool {
+        if id == "" || len(id) > 128 {
+                return false
+        }
+        for i := 0; i < len(id); i++ {
+                if id[i] < 0x21 || id[i] > 0x7e {
+                        return false
+                }
+        }
+        return true
+}
+
+// gzipResponseWriter buffers output until gzipMinBytes is reached, then switches to gzip
+type gzipResponseWriter struct {
+        http.ResponseWriter
+        gz          *gzip.Writer
+        buf         []byte
+        status      int
+        passthrough bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(code int) {
+        if g.status == 0 {
+                g.status = code
+        }
+}
+
+func (g *gzipResponseWriter) Write(b []byte) (int, error) {
+        if g.status == 0 {
+                g.status = http.StatusOK
+        }
+        if g.gz != nil {
+                return g.gz.Write(b)
+        }
+        if g.passthrough {
+                return g.ResponseWriter.Write(b)
+        }
+
+        g.buf = append(g.buf, b...)
+        if len(g.buf) >= gzipMinBytes && g.Header().Get("Content-Encoding") == "" {
+                if err := g.startGzip(); err != nil {
+                        return 0, err
+                }
+        }
+        return len(b), nil
+}
+
+// startGzip commits compressed headers and flushes the buffered prefix through gzip
+func (g *gzipResponseWriter) startGzip() error {
+        h := g.Header()
+        h.Del("Content-Length")
+        h.Set("Content-Encoding", "gzip")
+        h.Add("Vary", "Accept-Encoding")
+        g.ResponseWriter.WriteHeader(g.status)
+
+        g.gz = gzip.NewWriter(g.ResponseWriter)
+        _, err := g.gz.Write(g.buf)
+        g.buf = nil
+        return err
+}
+
+// commitPlain writes the buffered body uncompressed and passes further writes through
+func (g *gzipResponseWriter) commitPlain() error {
+        g.passthrough = true
+        if g.status == 0 {
+                g.status = http.StatusOK
+        }
+        g.ResponseWriter.WriteHeader(g.status)
+        if len(g.buf) == 0 {
+                return nil
+        }
+        _, err := g.ResponseWriter.Write(g.buf)
+        g.buf = nil
+        return err
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+        return g.ResponseWriter
+}
+
+// Flush sends buffered data immediately; streaming responses stay uncompressed
+func (g *gzipResponseWriter) Flush() {
+        if g.gz != nil {
+                g.gz.Flush()
+        } else if !g.passthrough {
+                g.commitPlain()
+        }
+        if f, ok := g.ResponseWriter.(http.Flusher); ok {
+                f.Flush()
+        }
+}
+
+// finish completes the response once the handler returns
+func (g *gzipResponseWriter) finish() {
+        if g.gz != nil {
+                if err := g.gz.Close(); err != nil {
+                        slog.Error("gzip close failed", "error", err)
+                }
+                return
+        }
+        if !g.passthrough {
+                g.commitPlain()
+        }
+}
+
+// gzipMiddleware compresses responses for clients that send Accept-Encoding: gzip
+func gzipMiddleware(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                // WebSocket handshakes hijack the connection, so there is no body to compress
+                if !acceptsGzip(r.Header.Get("Accept-Encoding")) || r.Header.Get("Upgrade") != "" {
+                        next.ServeHTTP(w, r)
+                        return
+                }
+
+                gw := &gzipResponseWriter{ResponseWriter: w}
+                defer gw.finish()
+                next.ServeHTTP(gw, r)
+        })
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip
+func acceptsGzip(header string) bool {
+        for _, part := range strings.Split(header, ",") {
+                coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+                if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+                        continue
+                }
+                if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+                        v, err := strconv.ParseFloat(q, 64)
+                        return err == nil && v > 0
+                }
+                return true
+        }
+        return false
+}
+
+// corsMiddleware sets CORS headers for allowed origins and answers preflight requests
+func corsMiddleware(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                origin := r.Header.Get("Origin")
+                allowOrigin := matchOrigin(origin)
+                if allowOrigin == "" {
+                        next.ServeHTTP(w, r)
+                        return
+                }
+
+                h := w.Header()
+                h.Add("Vary", "Origin")
+                h.Set("Access-Control-Allow-Origin", allowOrigin)
+
+                if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+                        allowHeaders := r.Header.Get("Access-Control-Request-Headers")
+                        if allowHeaders == "" {
+                                allowHeaders = "Content-Type"
+                        }
+                        h.Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+                        h.Set("Access-Control-Allow-Headers", allowHeaders)
+                        h.Set("Access-Control-Max-Age", "600")
+                        w.WriteHeader(http.StatusNoContent)
+                        return
+                }
+
+                next.ServeHTTP(w, r)
+        })
+}
+
+// matchOrigin returns the Access-Control-Allow-Origin value for origin, or "" if denied
+func matchOrigin(origin string) string {
+        if origin == "" {
+                return ""
+        }
+        for _, allowed := range corsAllowedOrigins {
+                if allowed == "*" {
+                        return "*"
+                }
+                if strings.EqualFold(allowed, origin) {
+                        return origin
+                }
+        }
+        return ""
+}
+"""
+
+GO_METRICS = """package main
+
+import (
+        "net/http"
+        "strconv"
+        "time"
+
+        "github.com/prometheus/client_golang/prometheus"
+        "github.com/prometheus/client_golang/prometheus/collectors"
+        "github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+        metricsRegistry = prometheus.NewRegistry()
+
+        httpRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+                Name: "http_requests_total",
+                Help: "Total HTTP requests by path and status code.",
+        }, []string{"path", "status"})
+
+        httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+                Name:    "http_request_duration_seconds",
+                Help:    "HTTP request latency by path.",
+                Buckets: prometheus.DefBuckets,
+        }, []string{"path"})
+
+        httpResponseSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+                Name:    "http_response_size_bytes",
+                Help:    "HTTP response body size by path, as sent after compression.",
+                Buckets: prometheus.ExponentialBuckets(64, 4, 8),
+        }, []string{"path"})
+
+        httpRequestsShed = prometheus.NewCounter(prometheus.CounterOpts{
+                Name: "http_requests_shed_total",
+                Help: "Requests rejected with 503 because MAX_CONCURRENT_REQUESTS was reached.",
+        })
+)
+
+func init() {
+        metricsRegistry.MustRegister(
+                collectors.NewGoCollector(),
+                collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+                httpRequestsTotal,
+                httpRequestDuration,
+                httpResponseSize,
+                httpRequestsShed,
+        )
+}
+
+// observeRequest records a completed request in the Prometheus metrics. With
+// tracing on, a traced request's latency carries its trace ID as an exemplar so a spike
+// on a dashboard links straight to a trace.
+func observeRequest(route string, status int, dur time.Duration, bytesOut int64, traceID string) {
+        httpRequestsTotal.WithLabelValues(route, strconv.Itoa(status)).Inc()
+        latency := httpRequestDuration.WithLabelValues(route)
+        if eo, ok := latency.(prometheus.ExemplarObserver); ok && tracingEnabled && traceID != "" {
+                eo.ObserveWithExemplar(dur.Seconds(), prometheus.Labels{"trace_id": traceID})
+        } else {
+                latency.Observe(dur.Seconds())
+        }
+        httpResponseSize.WithLabelValues(route).Observe(float64(bytesOut))
+}
+
+// unmatchedRoute labels requests no registered route serves, i.e. the 404s
+const unmatchedRoute = "unmatched"
+
+// routeTemplates renames subtree patterns whose remainder is a parameter
+var routeTemplates = map[string]string{
+        "/messages/":    "/messages/:id",
+        "/debug/pprof/": "/debug/pprof/:profile",
+}
+
+// routeLabel names the route mux dispatches r to: the registered pattern, or its template
+// when the pattern captures an ID, so metric labels stay bounded however many IDs are seen
+func routeLabel(mux *http.ServeMux, r *http.Request) string {
+        _, pattern := mux.Handler(r)
+        // "/" is the root banner and also the catch-all that answers unknown paths
+        if pattern == "" || (pattern == "/" && r.URL.Path != "/") {
+                return unmatchedRoute
+        }
+        if tmpl, ok := routeTemplates[pattern]; ok {
+                return tmpl
+        }
+        return pattern
+}
+
+// observeShed counts a request turned away by the concurrency limiter
+func observeShed() {
+        httpRequestsShed.Inc()
+}
+
+// metricsHandler serves reg in the Prometheus exposition format, or in OpenMetrics for
+// scrapers that ask for it; only OpenMetrics carries the latency exemplars
+func metricsHandler(reg *prometheus.Registry) http.Handler {
+        return promhttp.HandlerFor(reg, promhttp.HandlerOpts{EnableOpenMetrics: true})
+}
+"""
+
+GO_RATELIMIT = r"""package main
+
+import (
+        "math"
+        "net"
+        "net/http"
+        "net/netip"
+        "strconv"
+        "strings"
+        "sync"
+        "time"
+
+        "golang.org/x/time/rate"
+)
+
+// limiterTTL is how long an idle client's limiter is kept before eviction
+const limiterTTL = 3 * time.Minute
+
+type clientLimiter struct {
+        limiter  *rate.Limiter
+        lastSeen time.Time
+}
+
+// ipRateLimiter hands out a token bucket per client IP. Its rate follows RATE_LIMIT_RPS and
+// RATE_LIMIT_BURST in the live configuration; a non-positive RPS lets every request through.
+type ipRateLimiter struct {
+        mu      sync.Mutex
+        clients map[string]*clientLimiter
+        rps     rate.Limit
+        burst   int
+
+        done     chan struct{}
+        stopOnce sync.Once
+}
+
+// newIPRateLimiter starts the limiter's eviction loop, which runs until stop is called
+func newIPRateLimiter(rps float64, burst int) *ipRateLimiter {
+        l := &ipRateLimiter{
+                clients: make(map[string]*clientLimiter),
+                rps:     rate.Limit(rps),
+                burst:   burst,
+                done:    make(chan struct{}),
+        }
+        go l.evictLoop(time.Minute)
+        return l
+}
+
+// stop ends the eviction loop; the limiter keeps limiting but no longer forgets idle clients
+func (l *ipRateLimiter) stop() {
+        l.stopOnce.Do(func() { close(l.done) })
+}
+
+// allow reports whether ip may make another request now at rps and burst
+func (l *ipRateLimiter) allow(ip string, rps float64, burst int) bool {
+        l.mu.Lock()
+        defer l.mu.Unlock()
+
+        // A reload changed the limits: retune the existing buckets rather than resetting them
+        if rate.Limit(rps) != l.rps || burst != l.burst {
+                l.rps, l.burst = rate.Limit(rps), burst
+                for _, c := range l.clients {
+                        c.limiter.SetLimit(l.rps)
+                        c.limiter.SetBurst(l.burst)
+                }
+        }
+
+        c, ok := l.clients[ip]
+        if !ok {
+                c = &clientLimiter{limiter: rate.NewLimiter(l.rps, l.burst)}
+                l.clients[ip] = c
+        }
+        c.lastSeen = time.Now()
+        return c.limiter.Allow()
+}
+
+// evictLoop drops limiters for clients idle longer than limiterTTL to bound memory
+func (l *ipRateLimiter) evictLoop(interval time.Duration) {
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+
+        for {
+                select {
+                case <-l.done:
+                        return
+                case <-ticker.C:
+                }
+
+                cutoff := time.Now().Add(-limiterTTL)
+                l.mu.Lock()
+                for ip, c := range l.clients {
+                        if c.lastSeen.Before(cutoff) {
+                                delete(l.clients, ip)
+                        }
+                }
+                l.mu.Unlock()
+        }
+}
+
+// middleware rejects clients over their limit with 429 and a Retry-After hint
+func (l *ipRateLimiter) middleware(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                cfg := currentConfig()
+                if cfg.RateLimitRPS <= 0 {
+                        next.ServeHTTP(w, r)
+                        return
+                }
+                if !l.allow(clientIP(r), cfg.RateLimitRPS, cfg.RateLimitBurst) {
+                        retryAfter := strconv.Itoa(int(math.Max(1, math.Ceil(1/cfg.RateLimitRPS))))
+                        w.Header().Set("Retry-After", retryAfter)
+                        httpError(w, r, http.StatusTooManyRequests, errRateLimited, "rate limit exceeded")
+                        return
+                }
+                next.ServeHTTP(w, r)
+        })
+}
+
+// rateLimited wraps h with the per-IP limiter
+func rateLimited(l *ipRateLimiter, h http.Handler) http.Handler {
+        if l == nil {
+                return h
+        }
+        return l.middleware(h)
+}
+
+// trustedProxies are the TRUSTED_PROXIES ranges whose forwarding headers clientIP believes
+var trustedProxies []netip.Prefix
+
+// clientIP returns the originating client address. X-Forwarded-For and X-Real-IP are only
+// honored when the peer is a trusted proxy, since anyone else can forge them. The
+// X-Forwarded-For chain is walked right to left past trusted hops, so the first untrusted
+// address is the client; entries to its left were supplied by the client and are ignored.
+func clientIP(r *http.Request) string {
+        peer := remoteHost(r)
+        if !isTrustedProxy(peer) {
+                return peer
+        }
+
+        if hops := forwardedHops(r); len(hops) > 0 {
+                client := peer
+                for i := len(hops) - 1; i >= 0; i-- {
+                        addr, err := netip.ParseAddr(hops[i])
+                        if err != nil {
+                                // A garbled hop ends the trustworthy part of the chain
+                                break
+                        }
+                        client = addr.Unmap().String()
+                        if !isTrustedProxy(client) {
+                                break
+                        }
+                }
+                return client
+        }
+
+        if real := strings.TrimSpace(r.Header.Get("X-Real-IP")); real != "" {
+                if addr, err := netip.ParseAddr(real); err == nil {
+                        return addr.Unmap().String()
+                }
+        }
+        return peer
+}
+
+// remoteHost is the address of the immediate peer, without its port
+func remoteHost(r *http.Request) string {
+        host, _, err := net.SplitHostPort(r.RemoteAddr)
+        if err != nil {
+                return r.RemoteAddr
+        }
+        return host
+}
+
+// forwardedHops flattens every X-Forwarded-For header into one ordered list of hops
+func forwardedHops(r *http.Request) []string {
+        var hops []string
+        for _, value := range r.Header.Values("X-Forwarded-For") {
+                for _, hop := range strings.Split(value, ",") {
+                        if hop = strings.TrimSpace(hop); hop != "" {
+                                hops = append(hops, hop)
+                        }
+                }
+        }
+        return hops
+}
+
+// isTrustedProxy reports whether ip falls inside one of trustedProxies
+func isTrustedProxy(ip string) bool {
+        if len(trustedProxies) == 0 {
+                return false
+        }
+        addr, err := netip.ParseAddr(ip)
+        if err != nil {
+                return false
+        }
+        addr = addr.Unmap()
+        for _, p := range trustedProxies {
+                if p.Contains(addr) {
+                        return true
+                }
+        }
+        return false
+}
+"""
+
+GO_STORE = r"""package main
+
+import (
+        "context"
+        "fmt"
+        "net/http"
+        "sort"
+        "strconv"
+        "strings"
+        "sync"
+)
+
+// defaultMessageStoreSize is how many echoed messages are retained; 0 disables storage
+const defaultMessageStoreSize = 1000
+
+// STORE_BACKEND values
+const (
+        storeBackendMemory = "memory"
+        storeBackendFile   = "file"
+)
+
+// Store keeps echoed messages for GET /messages. Handlers only see this interface;
+// STORE_BACKEND picks the implementation at startup.
+type Store interface {
+        // Save assigns e the next ID and keeps it
+        Save(ctx context.Context, e Echo) (StoredMessage, error)
+        // Get returns the message with the given ID, or false if it is not retained
+        Get(ctx context.Context, id int64) (StoredMessage, bool, error)
+        // List returns up to limit messages, newest first
+        List(ctx context.Context, limit int) ([]StoredMessage, error)
+}
+
+// StoredMessage is an echoed message with its store-assigned ID
+type StoredMessage struct {
+        ID int64 `json:"id"`
+        Echo
+}
+
+// storeEcho saves e and announces it to /events subscribers
+func (s *Server) storeEcho(ctx context.Context, e Echo) error {
+        msg, err := s.store.Save(ctx, e)
+        if err != nil {
+                s.logger.Error("storing message failed", "request_id", requestIDFromContext(ctx), "error", err)
+                return err
+        }
+        s.events.publish(msg)
+        return nil
+}
+
+// saveEcho is storeEcho for handlers that have not written yet: on failure it answers 500
+// and returns false
+func (s *Server) saveEcho(w http.ResponseWriter, r *http.Request, e Echo) bool {
+        if err := s.storeEcho(r.Context(), e); err != nil {
+                httpError(w, r, http.StatusInternalServerError, errInternal, "storing message failed")
+                return false
+        }
+        return true
+}
+
+// messageStore is the memory backend: a bounded, concurrency-safe log of echoed messages
+// whose oldest entries are evicted first. Everything is lost on restart.
+type messageStore struct {
+        mu       sync.RWMutex
+        items    []StoredMessage
+        nextID   int64
+        capacity int
+}
+
+func newMessageStore(capacity int) *messageStore {
+        if capacity < 0 {
+                capacity = 0
+        }
+        return &messageStore{capacity: capacity, nextID: 1}
+}
+
+// Save stores e under the next ID, evicting the oldest entry when full
+func (s *messageStore) Save(_ context.Context, e Echo) (StoredMessage, error) {
+        s.mu.Lock()
+        defer s.mu.Unlock()
+
+        msg := StoredMessage{ID: s.nextID, Echo: e}
+        s.keep(msg)
+        return msg, nil
+}
+
+// keep retains msg, which must have a higher ID than anything already held, and moves
+// nextID past it. The caller holds mu.
+func (s *messageStore) keep(msg StoredMessage) {
+        s.nextID = msg.ID + 1
+        if s.capacity == 0 {
+                return
+        }
+
+        if len(s.items) >= s.capacity {
+                n := copy(s.items, s.items[len(s.items)-s.capacity+1:])
+                s.items = s.items[:n]
+        }
+        s.items = append(s.items, msg)
+}
+
+// Get returns the message with the given ID if it is still retained
+func (s *messageStore) Get(_ context.Context, id int64) (StoredMessage, bool, error) {
+        s.mu.RLock()
+        defer s.mu.RUnlock()
+
+        // IDs are assigned in increasing order, so items is sorted by ID
+        i := sort.Search(len(s.items), func(i int) bool { return s.items[i].ID >= id })
+        if i < len(s.items) && s.items[i].ID == id {
+                return s.items[i], true, nil
+        }
+        return StoredMessage{}, false, nil
+}
+
+// List returns up to limit messages, newest first
+func (s *messageStore) List(_ context.Context, limit int) ([]StoredMessage, error) {
+        s.mu.RLock()
+        defer s.mu.RUnlock()
+
+        if limit <= 0 || limit > len(s.items) {
+                limit = len(s.items)
+        }
+        out := make([]StoredMessage, 0, limit)
+        for i := len(s.items) - 1; i >= 0 && len(out) < limit; i-- {
+                out = append(out, s.items[i])
+        }
+        return out, nil
+}
+
+// handleMessages serves GET /messages?limit=N and GET /messages/{id}
+func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+                methodNotAllowed(w, r, http.MethodGet)
+                return
+        }
+
+        idStr := strings.Trim(strings.TrimPrefix(r.URL.Path, "/messages"), "/")
+        if idStr == "" {
+                limit := 50
+                if v := r.URL.Query().Get("limit"); v != "" {
+                        n, err := strconv.Atoi(v)
+                        if err != nil || n < 1 {
+                                httpError(w, r, http.StatusBadRequest, errInvalidParameter, fmt.Sprintf("invalid limit %q: must be a positive integer", v))
+                                return
+                        }
+                        limit = n
+                }
+
+                list, err := s.store.List(r.Context(), limit)
+                if err != nil {
+                        s.logger.Error("listing messages failed", "request_id", requestIDFromContext(r.Context()), "error", err)
+                        httpError(w, r, http.StatusInternalServerError, errInternal, "listing messages failed")
+                        return
+                }
+                writeJSON(w, r, http.StatusOK, list)
+                return
+        }
+
+        id, err := strconv.ParseInt(idStr, 10, 64)
+        if err != nil {
+                httpError(w, r, http.StatusBadRequest, errInvalidParameter, fmt.Sprintf("invalid message id %q", idStr))
+                return
+        }
+
+        msg, ok, err := s.store.Get(r.Context(), id)
+        if err != nil {
+                s.logger.Error("reading message failed", "id", id, "request_id", requestIDFromContext(r.Context()), "error", err)
+                httpError(w, r, http.StatusInternalServerError, errInternal, "reading message failed")
+                return
+        }
+        if !ok {
+                httpError(w, r, http.StatusNotFound, errNotFound, fmt.Sprintf("message %d not found", id))
+                return
+        }
+
+        writeJSON(w, r, http.StatusOK, msg)
+}
+"""
+
+GO_EVENTS = r"""package main
+
+import (
+        "encoding/json"
+        "fmt"
+        "log/slog"
+        "net/http"
+        "sync"
+        "time"
+)
+
+// sseKeepAlive is how often an idle /events stream sends a comment to keep proxies from timing out
+const sseKeepAlive = 15 * time.Second
+
+// eventHub fans newly echoed messages out to connected /events clients through a
+// registry of subscriber channels
+type eventHub struct {
+        mu     sync.Mutex
+        subs   map[chan StoredMessage]struct{}
+        done   chan struct{}
+        closed bool
+}
+
+func newEventHub() *eventHub {
+        return &eventHub{
+                subs: make(map[chan StoredMessage]struct{}),
+                done: make(chan struct{}),
+        }
+}
+
+func (h *eventHub) subscribe() chan StoredMessage {
+        ch := make(chan StoredMessage, 16)
+        h.mu.Lock()
+        h.subs[ch] = struct{}{}
+        h.mu.Unlock()
+        return ch
+}
+
+func (h *eventHub) unsubscribe(ch chan StoredMessage) {
+        h.mu.Lock()
+        delete(h.subs, ch)
+        h.mu.Unlock()
+}
+
+// publish delivers msg to every subscriber; slow subscribers miss events rather than block echo
+func (h *eventHub) publish(msg StoredMessage) {
+        h.mu.Lock()
+        defer h.mu.Unlock()
+
+        for ch := range h.subs {
+                select {
+                case ch <- msg:
+                default:
+                        slog.Warn("dropping event for slow subscriber", "id", msg.ID)
+                }
+        }
+}
+
+// close ends all open streams; used on server shutdown
+func (h *eventHub) close() {
+        h.mu.Lock()
+        defer h.mu.Unlock()
+        if !h.closed {
+                h.closed = true
+                close(h.done)
+        }
+}
+
+// handleEvents streams each newly echoed message as a Server-Sent Event
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+                methodNotAllowed(w, r, http.MethodGet)
+                return
+        }
+
+        flusher, ok := w.(http.Flusher)
+        if !ok {
+                httpError(w, r, http.StatusInternalServerError, errInternal, "streaming unsupported")
+                return
+        }
+
+        // The stream outlives the server's WriteTimeout, so lift the deadline for this connection
+        if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+                slog.Debug("could not clear write deadline for SSE stream", "error", err)
+        }
+
+        ch := s.events.subscribe()
+        defer s.events.unsubscribe(ch)
+
+        h := w.Header()
+        h.Set("Content-Type", "text/event-stream")
+        h.Set("Cache-Control", "no-cache")
+        h.Set("Connection", "keep-alive")
+        w.WriteHeader(http.StatusOK)
+        fmt.Fprint(w, ": connected\n\n")
+        flusher.Flush()
+
+        keepAlive := time.NewTicker(sseKeepAlive)
+        defer keepAlive.Stop()
+
+        for {
+                select {
+                case <-r.Context().Done():
+                        return
+                case <-s.events.done:
+                        return
+                case <-keepAlive.C:
+                        fmt.Fprint(w, ": keep-alive\n\n")
+                        flusher.Flush()
+                case msg := <-ch:
+                        data, err := json.Marshal(msg)
+                        if err != nil {
+                                slog.Error("encoding event failed", "id", msg.ID, "error", err)
+                                continue
+                        }
+                        fmt.Fprintf(w, "id: %d\nevent: message\ndata: %s\n\n", msg.ID, data)
+                        flusher.Flush()
+                }
+        }
+}
+"""
+
+GO_NEGOTIATE = r"""package main
+
+import (
+        "bytes"
+        "encoding/json"
+        "encoding/xml"
+        "log/slog"
+        "mime"
+        "net/http"
+        "strconv"
+        "strings"
+        "sync"
+        "sync/atomic"
+)
+
+const (
+        contentTypeJSON = "application/json"
+        contentTypeXML  = "application/xml"
+)
+
+// negotiateContentType picks JSON or XML from the Accept header, defaulting to JSON.
+// ok is false when the client accepts neither.
+func negotiateContentType(r *http.Request) (contentType string, ok bool) {
+        accept := strings.TrimSpace(r.Header.Get("Accept"))
+        if accept == "" {
+                return contentTypeJSON, true
+        }
+
+        bestQ, bestExact := 0.0, false
+        for _, part := range strings.Split(accept, ",") {
+                mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+                if err != nil {
+                        continue
+                }
+                q := 1.0
+                if v, found := params["q"]; found {
+                        if f, err := strconv.ParseFloat(v, 64); err == nil {
+                                q = f
+                        }
+                }
+
+                var candidate string
+                exact := true
+                switch mediaType {
+                case "application/json":
+                        candidate = contentTypeJSON
+                case "application/xml", "text/xml":
+                        candidate = contentTypeXML
+                case "application/*", "*/*":
+                        candidate, exact = contentTypeJSON, false
+                }
+                if candidate == "" || q <= 0 {
+                        continue
+                }
+
+                // Higher q wins; on a tie an explicit type beats a wildcard
+                if q > bestQ || (q == bestQ && exact && !bestExact) {
+                        contentType, bestQ, bestExact = candidate, q, exact
+                }
+        }
+        return contentType, contentType != ""
+}
+
+// bodyBuffer is a reusable response buffer with a JSON encoder already writing into it
+type bodyBuffer struct {
+        bytes.Buffer
+        json *json.Encoder
+}
+
+// maxPooledBodyBytes keeps the odd huge response from pinning its buffer in the pool
+const maxPooledBodyBytes = 64 << 10
+
+// bodyBuffers saves writeBody a buffer and encoder allocation per response
+var bodyBuffers = sync.Pool{New: func() any {
+        b := new(bodyBuffer)
+        b.json = json.NewEncoder(&b.Buffer)
+        return b
+}}
+
+// bodyBuffersOut counts buffers taken from the pool and not yet handed back, so tests can
+// check that every path, failures included, returns its buffer
+var bodyBuffersOut atomic.Int64
+
+func getBodyBuffer() *bodyBuffer {
+        bodyBuffersOut.Add(1)
+        return bodyBuffers.Get().(*bodyBuffer)
+}
+
+// putBodyBuffer returns b to the pool; b must not be used afterwards
+func putBodyBuffer(b *bodyBuffer) {
+        bodyBuffersOut.Add(-1)
+        if b.Cap() > maxPooledBodyBytes {
+                return
+        }
+        b.Reset()
+        bodyBuffers.Put(b)
+}
+
+// encode appends v to b in the negotiated format, newline-terminated like an Encoder would.
+// pretty indents the output for humans; the default stays compact.
+func (b *bodyBuffer) encode(contentType string, v any, pretty bool) error {
+        if contentType == contentTypeXML {
+                b.WriteString(xml.Header)
+                enc := xml.NewEncoder(&b.Buffer)
+                if pretty {
+                        enc.Indent("", "  ")
+                }
+                if err := enc.Encode(v); err != nil {
+                        return err
+                }
+                b.WriteByte('\n')
+                return nil
+        }
+        // The pooled encoder keeps its indent setting, so it is set every time
+        if pretty {
+                b.json.SetIndent("", "  ")
+        } else {
+                b.json.SetIndent("", "")
+        }
+        return b.json.Encode(v)
+}
+
+// marshalBody is encode for callers that keep the body, such as the ETag and idempotency
+// paths, so it returns a copy the pool will not reuse
+func marshalBody(contentType string, v any, pretty bool) ([]byte, error) {
+        b := getBodyBuffer()
+        defer putBodyBuffer(b)
+        if err := b.encode(contentType, v, pretty); err != nil {
+                return nil, err
+        }
+        return bytes.Clone(b.Bytes()), nil
+}
+
+// wantsPretty reports whether the client asked for indented output via ?pretty=true or X-Pretty: true
+func wantsPretty(r *http.Request) bool {
+        for _, v := range []string{r.URL.Query().Get("pretty"), r.Header.Get("X-Pretty")} {
+                if b, err := strconv.ParseBool(v); err == nil && b {
+                        return true
+                }
+        }
+        return false
+}
+
+// writeBody sends v with the given status in the negotiated format. The body is encoded
+// into a pooled buffer before anything is written, so an encoding failure becomes a logged
+// 500 rather than a truncated response behind a success status, and the length is known.
+func writeBody(w http.ResponseWriter, r *http.Request, status int, contentType string, v any) {
+        body := getBodyBuffer()
+        defer putBodyBuffer(body)
+        if err := body.encode(contentType, v, wantsPretty(r)); err != nil {
+                encodingFailed(w, r, status, err)
+                return
+        }
+        writeBytes(w, r, status, contentType, body.Bytes())
+}
+
+// writeBytes sends an already encoded body with its Content-Type and Content-Length. Every
+// complete response goes out through here, fresh or replayed; HEAD gets the headers only.
+func writeBytes(w http.ResponseWriter, r *http.Request, status int, contentType string, body []byte) {
+        w.Header().Set("Content-Type", contentType)
+        w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+        w.WriteHeader(status)
+        if r.Method == http.MethodHead {
+                return
+        }
+        if _, err := w.Write(body); err != nil {
+                slog.Debug("writing response failed",
+                        "path", r.URL.Path,
+                        "request_id", requestIDFromContext(r.Context()),
+                        "error", err)
+        }
+}
+
+// encodingFailed logs a response that could not be encoded and answers 500 in its place
+func encodingFailed(w http.ResponseWriter, r *http.Request, status int, err error) {
+        slog.Error("encoding response failed",
+                "method", r.Method,
+                "path", r.URL.Path,
+                "status", status,
+                "request_id", requestIDFromContext(r.Context()),
+                "error", err)
+        writeBytes(w, r, http.StatusInternalServerError, contentTypeJSON, internalErrorBody(r))
+}
+
+// writeJSON sends v as JSON with the given status
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v any) {
+        writeBody(w, r, status, contentTypeJSON, v)
+}
+
+// notAcceptable answers 406 for clients that accept none of the supported formats
+func notAcceptable(w http.ResponseWriter, r *http.Request) {
+        httpError(w, r, http.StatusNotAcceptable, errNotAcceptable, "Not acceptable. Supported types: application/json, application/xml")
+}
+
+// strictContentType makes JSON endpoints reject bodies not declared as application/json
+var strictContentType bool
+
+// requireJSONBody answers 415 unless the request declares a JSON body, optionally with
+// charset=utf-8. It always passes when STRICT_CONTENT_TYPE is off.
+func requireJSONBody(w http.ResponseWriter, r *http.Request) bool {
+        if !strictContentType {
+                return true
+        }
+        mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+        charset, hasCharset := params["charset"]
+        if err == nil && mediaType == "application/json" && (!hasCharset || strings.EqualFold(charset, "utf-8")) {
+                return true
+        }
+        httpError(w, r, http.StatusUnsupportedMediaType, errUnsupportedMediaType, "Unsupported media type. Send Content-Type: application/json")
+        return false
+}
+"""
+
+GO_OPENAPI = r"""package main
+
+import (
+        _ "embed"
+        "net/http"
+)
+
+// openAPISpec is the hand-authored OpenAPI 3.0 document, compiled into the binary
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// handleOpenAPI serves the embedded OpenAPI document
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet && r.Method != http.MethodHead {
+                methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+                return
+        }
+
+        serveWithETag(w, r, contentTypeJSON, openAPISpec)
+}
+"""
+
+OPENAPI_JSON = r"""{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "Aurora Go Service",
+    "version": "1.0.0",
+    "description": "Health and echo endpoints for the Aurora Go microservice."
+  },
+  "paths": {
+    "/": {
+      "get": {
+        "summary": "Service banner",
+        "responses": {
+          "200": {
+            "description": "Service name and advertised endpoints",
+            "content": {
+              "application/json": {
+                "schema": { "$ref": "#/components/schemas/Banner" }
+              }
+            }
+          },
+          "404": {
+            "description": "Unknown route",
+            "content": {
+              "application/json": {
+                "schema": { "$ref": "#/components/schemas/Error" }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/health": {
+      "get": {
+        "summary": "Liveness probe",
+        "responses": {
+          "200": {
+            "description": "The process is alive",
+            "content": {
+              "application/json": {
+                "schema": { "$ref": "#/components/schemas/Health" }
+              },
+              "application/xml": {
+                "schema": { "$ref": "#/components/schemas/Health" }
+              }
+            }
+          },
+          "406": {
+            "description": "Accept header names no supported type",
+            "content": {
+              "application/json": {
+                "schema": { "$ref": "#/components/schemas/Error" }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/info": {
+      "get": {
+        "summary": "Build, runtime and enabled optional features",
+        "responses": {
+          "200": {
+            "description": "What this instance is running and has turned on",
+            "content": {
+              "application/json": {
+                "schema": { "$ref": "#/components/schemas/Info" }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/whoami": {
+      "get": {
+        "summary": "Describe the request as the service received it",
+        "description": "Client IP, scheme, protocol, matched route and request headers, with WHOAMI_REDACT_HEADERS values replaced by ***.",
+        "responses": {
+          "200": {
+            "description": "What the service observed",
+            "content": {
+              "application/json": {
+                "schema": { "$ref": "#/components/schemas/WhoAmI" }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/echo": {
+      "post": {
+        "summary": "Echo a message back with server metadata",
+        "parameters": [
+          {
+            "name": "transform",
+            "in": "query",
+            "required": false,
+            "description": "Comma-separated rewrites applied left to right before echoing, from upper, lower and reverse; at most 16 steps",
+            "schema": { "type": "string", "example": "upper,reverse" }
+          },
+          {
+            "name": "checksum",
+            "in": "query",
+            "required": false,
+            "description": "Answer with the digest and length of the message instead of the message itself; such messages are not stored. The digest is of the message as echoed: after any ?transform= chain, or exactly as sent without one",
+            "schema": { "type": "string", "enum": ["crc32", "sha256", "sha512"] }
+          },
+          {
+            "name": "Idempotency-Key",
+            "in": "header",
+            "required": false,
+            "description": "Retries from the same client to the same path with the same key within IDEMPOTENCY_TTL replay the first successful response instead of storing the message again; reusing a key with a different body, Content-Type, Accept or query is refused with 422",
+            "schema": { "type": "string", "maxLength": 255 }
+          },
+          {
+            "name": "X-Signature-Key-Id",
+            "in": "header",
+            "required": false,
+            "description": "When response signing is enabled, selects the signing key; defaults to SIGNING_KEY_ID",
+            "schema": { "type": "string" }
+          }
+        ],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": { "$ref": "#/components/schemas/EchoRequest" }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "The echoed message, or its checksum with ?checksum=",
+            "headers": {
+              "X-Signature": {
+                "description": "sha256=<hex HMAC-SHA256 of the uncompressed body>, present when response signing is enabled",
+                "schema": { "type": "string" }
+              },
+              "X-Signature-Key-Id": {
+                "description": "Id of the key that produced X-Signature",
+                "schema": { "type": "string" }
+              }
+            },
+            "content": {
+              "application/json": {
+                "schema": { "oneOf": [{ "$ref": "#/components/schemas/Echo" }, { "$ref": "#/components/schemas/EchoChecksum" }] }
+              },
+              "application/xml": {
+                "schema": { "oneOf": [{ "$ref": "#/components/schemas/Echo" }, { "$ref": "#/components/schemas/EchoChecksum" }] }
+              }
+            }
+          },
+          "400": {
+            "description": "Empty, malformed or invalid payload",
+            "content": {
+              "application/json": {
+                "schema": { "$ref": "#/components/schemas/Error" }
+              }
+            }
+          },
+          "406": {
+            "description": "Accept header names no supported type",
+            "content": {
+              "application/json": {
+                "schema": { "$ref": "#/components/schemas/Error" }
+              }
+            }
+          },
+          "413": {
+            "description": "Request body exceeds MAX_BODY_BYTES",
+            "content": {
+              "application/json": {
+                "schema": { "$ref": "#/components/schemas/Error" }
+              }
+            }
+          },
+          "415": {
+            "description": "Content-Type is not application/json; only with STRICT_CONTENT_TYPE",
+            "content": {
+              "application/json": {
+                "schema": { "$ref": "#/components/schemas/Error" }
+              }
+            }
+          },
+          "422": {
+            "description": "Idempotency-Key was already used for a different request",
+            "content": {
+              "application/json": {
+                "schema": { "$ref": "#/components/schemas/Error" }
+              }
+            }
+          },
+          "429": {
+            "description": "Client exceeded its rate limit",
+            "content": {
+              "application/json": {
+                "schema": { "$ref": "#/components/schemas/Error" }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/echo/form": {
+      "post": {
+        "summary": "Echo a message posted as a form",
+        "parameters": [
+          {
+            "name": "transform",
+            "in": "query",
+            "required": false,
+            "description": "Comma-separated rewrites applied left to right before echoing, from upper, lower and reverse; at most 16 steps",
+            "schema": { "type": "string", "example": "upper,reverse" }
+          }
+        ],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/x-www-form-urlencoded": {
+              "schema": { "$ref": "#/components/schemas/EchoForm" }
+            },
+            "multipart/form-data": {
+              "schema": { "$ref": "#/components/schemas/EchoForm" }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "The echoed message",
+            "content": {
+              "application/json": {
+                "schema": { "$ref": "#/components/schemas/Echo" }
+              },
+              "application/xml": {
+                "schema": { "$ref": "#/components/schemas/Echo" }
+              }
+            }
+          },
+          "400": {
+            "description": "Malformed form, or message missing or invalid",
+            "content": {
+              "application/json": {
+                "schema": { "$ref": "#/components/schemas/Error" }
+              }
+            }
+          },
+          "413": {
+            "description": "Request body exceeds MAX_BODY_BYTES",
+            "content": {
+              "application/json": {
+                "schema": { "$ref": "#/components/schemas/Error" }
+              }
+            }
+          },
+          "415": {
+            "description": "Content-Type is not a form encoding",
+            "content": {
+              "application/json": {
+                "schema": { "$ref": "#/components/schemas/Error" }
+              }
+            }
+          }
+        }
+      }
+    }
+  },
+  "components": {
+    "schemas": {
+      "EchoRequest": {
+        "type": "object",
+        "required": ["message"],
+        "additionalProperties": false,
+        "properties": {
+          "message": { "type": "string", "minLength": 1, "maxLength": 4096 },
+          "metadata": { "$ref": "#/components/schemas/Metadata" }
+        }
+      },
+      "EchoForm": {
+        "type": "object",
+        "required": ["message"],
+        "properties": {
+          "message": { "type": "string", "minLength": 1, "maxLength": 4096 }
+        }
+      },
+      "Metadata": {
+        "type": "object",
+        "description": "Client data returned unchanged. At most 20 keys and 8KB by default; message, timestamp, received_at, processed_at, service, request_id and trace_id are reserved. With PRESERVE_NUMBERS, values may also be numbers, returned as the same number with its exact digits.",
+        "maxProperties": 20,
+        "additionalProperties": { "oneOf": [{ "type": "string" }, { "type": "number" }] }
+      },
+      "Echo": {
+        "type": "object",
+        "required": ["message", "timestamp", "received_at", "processed_at", "service"],
+        "properties": {
+          "message": { "type": "string" },
+          "metadata": { "$ref": "#/components/schemas/Metadata" },
+          "timestamp": { "type": "string", "format": "date-time", "description": "Same as processed_at" },
+          "received_at": { "type": "string", "format": "date-time", "description": "When the request reached the service" },
+          "processed_at": { "type": "string", "format": "date-time", "description": "When the echo was ready to send" },
+          "service": { "type": "string" },
+          "request_id": { "type": "string" },
+          "trace_id": { "type": "string" }
+        }
+      },
+      "EchoChecksum": {
+        "type": "object",
+        "required": ["algorithm", "checksum", "length", "timestamp", "received_at", "processed_at", "service"],
+        "properties": {
+          "algorithm": { "type": "string", "enum": ["crc32", "sha256", "sha512"] },
+          "checksum": { "type": "string", "description": "Lowercase hex digest of the message in UTF-8, after any ?transform= chain" },
+          "length": { "type": "integer", "description": "Length in bytes of the digested message" },
+          "metadata": { "$ref": "#/components/schemas/Metadata" },
+          "timestamp": { "type": "string", "format": "date-time", "description": "Same as processed_at" },
+          "received_at": { "type": "string", "format": "date-time", "description": "When the request reached the service" },
+          "processed_at": { "type": "string", "format": "date-time", "description": "When the checksum was ready to send" },
+          "service": { "type": "string" },
+          "request_id": { "type": "string" },
+          "trace_id": { "type": "string" }
+        }
+      },
+      "Health": {
+        "type": "object",
+        "required": ["ok", "service", "version", "timestamp"],
+        "properties": {
+          "ok": { "type": "boolean" },
+          "service": { "type": "string" },
+          "version": { "type": "string" },
+          "timestamp": { "type": "string", "format": "date-time" }
+        }
+      },
+      "Info": {
+        "type": "object",
+        "required": ["service", "version", "commit", "build_time", "go_version", "features"],
+        "properties": {
+          "service": { "type": "string" },
+          "version": { "type": "string" },
+          "commit": { "type": "string" },
+          "build_time": { "type": "string" },
+          "go_version": { "type": "string", "example": "go1.21.13" },
+          "features": {
+            "type": "object",
+            "description": "Optional features by name, such as tls, pprof, websocket, ui and rate_limit",
+            "additionalProperties": { "type": "boolean" }
+          },
+          "modules": {
+            "type": "object",
+            "description": "Module dependencies compiled into the binary, by path",
+            "additionalProperties": { "type": "string" }
+          }
+        }
+      },
+      "WhoAmI": {
+        "type": "object",
+        "required": ["client_ip", "remote_addr", "scheme", "protocol", "method", "host", "uri", "route", "headers"],
+        "properties": {
+          "client_ip": { "type": "string" },
+          "remote_addr": { "type": "string" },
+          "scheme": { "type": "string", "enum": ["http", "https", "h2c"] },
+          "protocol": { "type": "string", "example": "HTTP/1.1" },
+          "method": { "type": "string" },
+          "host": { "type": "string" },
+          "uri": { "type": "string" },
+          "user_agent": { "type": "string" },
+          "route": { "type": "string" },
+          "headers": {
+            "type": "object",
+            "additionalProperties": { "type": "array", "items": { "type": "string" } }
+          },
+          "request_id": { "type": "string" }
+        }
+      },
+      "Banner": {
+        "type": "object",
+        "properties": {
+          "service": { "type": "string" },
+          "endpoints": { "type": "string" }
+        }
+      },
+      "Error": {
+        "type": "object",
+        "required": ["code", "message"],
+        "properties": {
+          "code": {
+            "type": "string",
+            "description": "Stable machine-readable code such as invalid_json, method_not_allowed or body_too_large"
+          },
+          "message": { "type": "string" },
+          "details": {
+            "type": "array",
+            "items": { "type": "string" },
+            "description": "Individual problems, e.g. one entry per JSON Schema violation"
+          },
+          "request_id": { "type": "string" }
+        }
+      }
+    }
+  }
+}
+"""
+
+GO_CLIENT = r"""// Package client is a Go client for the Aurora Go Service HTTP API.
+package client
+
+import (
+        "bytes"
+        "context"
+        "crypto/rand"
+        "encoding/hex"
+        "encoding/json"
+        "fmt"
+        "io"
+        "net/http"
+        "strconv"
+        "strings"
+        "time"
+)
+
+// DefaultTimeout bounds each request made with the default HTTP client
+const DefaultTimeout = 10 * time.Second
+
+// DefaultRetryBackoff is the first retry delay when WithRetries is given none
+const DefaultRetryBackoff = 100 * time.Millisecond
+
+// Health mirrors the service's /health response
+type Health struct {
+        OK        bool      `json:"ok"`
+        Service   string    `json:"service"`
+        Version   string    `json:"version"`
+        Timestamp time.Time `json:"timestamp"`
+}
+
+// Echo mirrors the service's /echo response
+type Echo struct {
+        Message     string            `json:"message"`
+        Metadata    map[string]string `json:"metadata,omitempty"`
+        Timestamp   time.Time         `json:"timestamp"`
+        ReceivedAt  time.Time         `json:"received_at"`
+        ProcessedAt time.Time         `json:"processed_at"`
+        Service     string            `json:"service"`
+        RequestID   string            `json:"request_id,omitempty"`
+        TraceID     string            `json:"trace_id,omitempty"`
+}
+
+// APIError is returned for non-2xx responses
+type APIError struct {
+        StatusCode int
+        Code       string // stable machine-readable code, e.g. "invalid_json"
+        Message    string
+        RequestID  string
+}
+
+func (e *APIError) Error() string {
+        if e.Message == "" {
+                return fmt.Sprintf("aurora service: HTTP %d", e.StatusCode)
+        }
+        if e.Code != "" {
+                return fmt.Sprintf("aurora service: HTTP %d %s: %s", e.StatusCode, e.Code, e.Message)
+        }
+        return fmt.Sprintf("aurora service: HTTP %d: %s", e.StatusCode, e.Message)
+}
+
+// Client calls a single Aurora Go Service instance
+type Client struct {
+        baseURL    string
+        httpClient *http.Client
+        timeout    time.Duration
+        retries    int
+        backoff    time.Duration
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient replaces the default *http.Client; its own timeout settings apply
+func WithHTTPClient(hc *http.Client) Option {
+        return func(c *Client) { c.httpClient = hc }
+}
+
+// WithTimeout sets the per-request timeout of the default HTTP client
+func WithTimeout(d time.Duration) Option {
+        return func(c *Client) { c.timeout = d }
+}
+
+// WithRetries retries a call up to n more times after a transport error or a 429, 502,
+// 503 or 504. The delay starts at backoff and doubles each attempt; a Retry-After from the
+// service takes its place. Echo calls then carry an Idempotency-Key, so a retried message
+// is stored once.
+func WithRetries(n int, backoff time.Duration) Option {
+        return func(c *Client) {
+                c.retries = n
+                c.backoff = backoff
+                if c.backoff <= 0 {
+                        c.backoff = DefaultRetryBackoff
+                }
+        }
+}
+
+// New returns a Client for the service at baseURL, e.g. "http://127.0.0.1:8080"
+func New(baseURL string, opts ...Option) *Client {
+        c := &Client{
+                baseURL: strings.TrimRight(baseURL, "/"),
+                timeout: DefaultTimeout,
+        }
+        for _, opt := range opts {
+                opt(c)
+        }
+        if c.httpClient == nil {
+                c.httpClient = &http.Client{Timeout: c.timeout}
+        }
+        return c
+}
+
+// Health calls GET /health
+func (c *Client) Health(ctx context.Context) (Health, error) {
+        var h Health
+        err := c.do(ctx, http.MethodGet, "/health", nil, &h)
+        return h, err
+}
+
+// Echo calls POST /echo with message
+func (c *Client) Echo(ctx context.Context, message string) (Echo, error) {
+        var e Echo
+        err := c.do(ctx, http.MethodPost, "/echo", map[string]string{"message": message}, &e)
+        return e, err
+}
+
+// do sends a JSON request, retrying as WithRetries allows, and decodes a JSON response into out
+func (c *Client) do(ctx context.Context, method, path string, in, out any) error {
+        var payload []byte
+        if in != nil {
+                b, err := json.Marshal(in)
+                if err != nil {
+                        return fmt.Errorf("encode request: %w", err)
+                }
+                payload = b
+        }
+        // Every attempt of a retried POST carries the same key, so the service applies it once
+        var idempotencyKey string
+        if c.retries > 0 && method == http.MethodPost {
+                idempotencyKey = newIdempotencyKey()
+        }
+
+        for attempt := 0; ; attempt++ {
+                resp, err := c.send(ctx, method, path, payload, idempotencyKey)
+                retry := attempt < c.retries && ctx.Err() == nil && (err != nil || retryableStatus(resp.StatusCode))
+                if !retry {
+                        if err != nil {
+                                return err
+                        }
+                        return decodeResponse(resp, out)
+                }
+
+                delay := c.backoff << attempt
+                if resp != nil {
+                        if d, ok := retryAfter(resp); ok {
+                                delay = d
+                        }
+                        io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+                        resp.Body.Close()
+                }
+                timer := time.NewTimer(delay)
+                select {
+                case <-ctx.Done():
+                        timer.Stop()
+                        return ctx.Err()
+                case <-timer.C:
+                }
+        }
+}
+
+// send makes one attempt at a request
+func (c *Client) send(ctx context.Context, method, path string, payload []byte, idempotencyKey string) (*http.Response, error) {
+        var body io.Reader
+        if payload != nil {
+                body = bytes.NewReader(payload)
+        }
+        req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+        if err != nil {
+                return nil, err
+        }
+        req.Header.Set("Accept", "application/json")
+        if payload != nil {
+                req.Header.Set("Content-Type", "application/json")
+        }
+        if idempotencyKey != "" {
+                req.Header.Set("Idempotency-Key", idempotencyKey)
+        }
+        return c.httpClient.Do(req)
+}
+
+// retryableStatus reports whether status means the service may answer differently soon
+func retryableStatus(status int) bool {
+        switch status {
+        case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+                return true
+        }
+        return false
+}
+
+// retryAfter reads a Retry-After given in seconds, the form the service sends
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+        secs, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+        if err != nil || secs < 0 {
+                return 0, false
+        }
+        return time.Duration(secs) * time.Second, true
+}
+
+// newIdempotencyKey returns a random key for one logical call
+func newIdempotencyKey() string {
+        b := make([]byte, 16)
+        rand.Read(b)
+        return hex.EncodeToString(b)
+}
+
+// decodeResponse decodes a 2xx JSON body into out, or returns the error envelope as an *APIError
+func decodeResponse(resp *http.Response, out any) error {
+        defer resp.Body.Close()
+
+        if resp.StatusCode < 200 || resp.StatusCode > 299 {
+                apiErr := &APIError{StatusCode: resp.StatusCode}
+                var payload struct {
+                        Code      string `json:"code"`
+                        Message   string `json:"message"`
+                        RequestID string `json:"request_id"`
+                }
+                if json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&payload) == nil {
+                        apiErr.Code = payload.Code
+                        apiErr.Message = payload.Message
+                        apiErr.RequestID = payload.RequestID
+                }
+                return apiErr
+        }
+
+        if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+                return fmt.Errorf("decode response: %w", err)
+        }
+        return nil
+}
+"""
+
+GO_TRACING = r"""package main
+
+import (
+        "context"
+        "net/http"
+        "os"
+
+        "go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+        "go.opentelemetry.io/otel"
+        "go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+        "go.opentelemetry.io/otel/propagation"
+        "go.opentelemetry.io/otel/sdk/resource"
+        sdktrace "go.opentelemetry.io/otel/sdk/trace"
+        semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+        "go.opentelemetry.io/otel/trace"
+)
+
+// tracingEnabled is set once an OTLP exporter has been configured
+var tracingEnabled bool
+
+// setupTracing installs an OTLP/HTTP tracer provider when OTEL_EXPORTER_OTLP_ENDPOINT is set.
+// The exporter reads the standard OTEL_EXPORTER_OTLP_* variables itself. The returned
+// function flushes pending spans and is safe to call when tracing is disabled.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+        if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" {
+                return func(context.Context) error { return nil }, nil
+        }
+
+        exporter, err := otlptracehttp.New(ctx)
+        if err != nil {
+                return nil, err
+        }
+
+        res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+                semconv.SchemaURL,
+                semconv.ServiceName(serviceName),
+                semconv.ServiceVersion(version),
+        ))
+        if err != nil {
+                return nil, err
+        }
+
+        provider := sdktrace.NewTracerProvider(
+                sdktrace.WithBatcher(exporter),
+                sdktrace.WithResource(res),
+        )
+        otel.SetTracerProvider(provider)
+        otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+                propagation.TraceContext{},
+                propagation.Baggage{},
+        ))
+        tracingEnabled = true
+        return provider.Shutdown, nil
+}
+
+// tracingMiddleware starts a server span per request, continuing any incoming W3C traceparent.
+// Spans are named after the mux pattern that matched so IDs in paths don't fragment them.
+// It is a pass-through when tracing is disabled.
+func tracingMiddleware(mux *http.ServeMux, next http.Handler) http.Handler {
+        if !tracingEnabled {
+                return next
+        }
+        return otelhttp.NewHandler(next, "http.server",
+                otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+                        return r.Method + " " + routeLabel(mux, r)
+                }),
+        )
+}
+
+// traceIDFromContext returns the active trace ID, or "" when the request is not traced
+func traceIDFromContext(ctx context.Context) string {
+        sc := trace.SpanContextFromContext(ctx)
+        if !sc.HasTraceID() {
+                return ""
+        }
+        return sc.TraceID().String()
+}
+"""
+
+GO_ADMIN = r"""package main
+
+import (
+        "crypto/subtle"
+        "log/slog"
+        "net/http"
+        "strings"
+        "time"
+)
+
+// registerAdminRoutes mounts the operator-facing routes: metrics, stats and, when ADMIN_TOKEN
+// is set, /admin/shutdown and /config. /metrics serves s.metrics.
+func (s *Server) registerAdminRoutes(mux *http.ServeMux) {
+        mux.Handle("/metrics", metricsHandler(s.metrics))
+        mux.HandleFunc("/stats", s.handleStats)
+
+        // The admin endpoints do not exist at all without a token, so they 404 like any unknown path
+        if token := s.cfg.AdminToken; token != "" {
+                mux.Handle("/admin/shutdown", s.adminShutdownHandler(token))
+                mux.Handle("/config", adminConfigHandler(token))
+                slog.Info("admin endpoints enabled", "routes", "POST /admin/shutdown, GET /config")
+        }
+}
+
+// shutdownFlushDelay gives the 202 time to reach the client before the server stops accepting work
+const shutdownFlushDelay = 500 * time.Millisecond
+
+// adminShutdownHandler serves POST /admin/shutdown. A request bearing token marks the
+// service unready, answers 202 and calls s.stop shortly afterwards to begin the
+// normal graceful shutdown. It is only registered when ADMIN_TOKEN is set.
+func (s *Server) adminShutdownHandler(token string) http.HandlerFunc {
+        return func(w http.ResponseWriter, r *http.Request) {
+                if r.Method != http.MethodPost {
+                        methodNotAllowed(w, r, http.MethodPost)
+                        return
+                }
+
+                if !validBearer(r, token) {
+                        w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+                        httpError(w, r, http.StatusUnauthorized, errUnauthorized, "unauthorized")
+                        return
+                }
+
+                s.markDraining()
+                slog.Warn("shutdown requested via admin endpoint",
+                        "remote_addr", r.RemoteAddr,
+                        "request_id", requestIDFromContext(r.Context()))
+
+                writeJSON(w, r, http.StatusAccepted, map[string]string{
+                        "status": "shutting down",
+                })
+                time.AfterFunc(shutdownFlushDelay, s.stop)
+        }
+}
+
+// adminConfigHandler serves GET /config: the live configuration, reloads included, with
+// secret fields redacted. Like /admin/shutdown it requires the admin bearer token.
+func adminConfigHandler(token string) http.HandlerFunc {
+        return func(w http.ResponseWriter, r *http.Request) {
+                if r.Method != http.MethodGet {
+                        methodNotAllowed(w, r, http.MethodGet)
+                        return
+                }
+
+                if !validBearer(r, token) {
+                        w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+                        httpError(w, r, http.StatusUnauthorized, errUnauthorized, "unauthorized")
+                        return
+                }
+
+                w.Header().Set("Cache-Control", "no-store")
+                writeJSON(w, r, http.StatusOK, currentConfig().redacted())
+        }
+}
+
+// validBearer reports whether r carries "Authorization: Bearer <token>", compared in constant time
+func validBearer(r *http.Request, token string) bool {
+        scheme, got, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+        if !ok || !strings.EqualFold(scheme, "Bearer") {
+                return false
+        }
+        return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(got)), []byte(token)) == 1
+}
+"""
+
+GO_IDEMPOTENCY = r"""package main
+
+import (
+        "bytes"
+        "crypto/sha256"
+        "io"
+        "net/http"
+        "strings"
+        "sync"
+        "time"
+)
+
+// idempotencyKeyHeader lets clients retry a POST without it being processed twice
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLen bounds the keys held in memory
+const maxIdempotencyKeyLen = 255
+
+// cachedResponse is a completed response kept for replay
+type cachedResponse struct {
+        done        chan struct{} // closed once the first request finishes, successfully or not
+        fingerprint [sha256.Size]byte
+        ok          bool
+        status      int
+        contentType string
+        body        []byte
+        expires     time.Time
+}
+
+// idempotencyCache maps Idempotency-Key values, scoped by scopedKey, to the response first
+// produced for them, kept for ttl after completion
+type idempotencyCache struct {
+        mu      sync.Mutex
+        entries map[string]*cachedResponse
+        ttl     time.Duration
+
+        done     chan struct{}
+        stopOnce sync.Once
+}
+
+// newIdempotencyCache starts the cache's eviction loop, which runs until stop is called
+func newIdempotencyCache(ttl time.Duration) *idempotencyCache {
+        c := &idempotencyCache{
+                entries: make(map[string]*cachedResponse),
+                ttl:     ttl,
+                done:    make(chan struct{}),
+        }
+        go c.evictLoop(time.Minute)
+        return c
+}
+
+// stop ends the eviction loop. A nil cache, as with IDEMPOTENCY_TTL=0, has none to stop.
+func (c *idempotencyCache) stop() {
+        if c == nil {
+                return
+        }
+        c.stopOnce.Do(func() { close(c.done) })
+}
+
+// begin returns the entry for key. owner is true when the caller must produce the
+// response and then call finish; otherwise the entry belongs to an earlier request, whose
+// fingerprint may differ from this one's.
+func (c *idempotencyCache) begin(key string, fingerprint [sha256.Size]byte) (entry *cachedResponse, owner bool) {
+        c.mu.Lock()
+        defer c.mu.Unlock()
+
+        if e, ok := c.entries[key]; ok && (e.expires.IsZero() || time.Now().Before(e.expires)) {
+                return e, false
+        }
+        e := &cachedResponse{done: make(chan struct{}), fingerprint: fingerprint}
+        c.entries[key] = e
+        return e, true
+}
+
+// finish records the owner's response. Only 2xx responses are kept; anything else
+// releases the key so a retry is processed afresh.
+func (c *idempotencyCache) finish(key string, e *cachedResponse, rec *recordingWriter) {
+        c.mu.Lock()
+        defer c.mu.Unlock()
+
+        if rec.status >= 200 && rec.status < 300 {
+                e.ok = true
+                e.status = rec.status
+                e.contentType = rec.Header().Get("Content-Type")
+                e.body = rec.body.Bytes()
+                e.expires = time.Now().Add(c.ttl)
+        } else if c.entries[key] == e {
+                delete(c.entries, key)
+        }
+        close(e.done)
+}
+
+// evictLoop drops expired responses to bound memory
+func (c *idempotencyCache) evictLoop(interval time.Duration) {
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+
+        for {
+                select {
+                case <-c.done:
+                        return
+                case <-ticker.C:
+                }
+
+                now := time.Now()
+                c.mu.Lock()
+                for key, e := range c.entries {
+                        if !e.expires.IsZero() && now.After(e.expires) {
+                                delete(c.entries, key)
+                        }
+                }
+                c.mu.Unlock()
+        }
+}
+
+// middleware replays the stored response for a repeated Idempotency-Key. A retry that
+// arrives while the first request is still running waits for it rather than racing it.
+// A key reused for a request with another fingerprint is refused with 422.
+func (c *idempotencyCache) middleware(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                key := r.Header.Get(idempotencyKeyHeader)
+                if key == "" || r.Method != http.MethodPost {
+                        next.ServeHTTP(w, r)
+                        return
+                }
+                if len(key) > maxIdempotencyKeyLen {
+                        httpError(w, r, http.StatusBadRequest, errInvalidHeader, "Idempotency-Key is too long")
+                        return
+                }
+
+                body, err := io.ReadAll(r.Body)
+                if err != nil {
+                        // Too large or cut short: the handler reports it as it would without a key
+                        r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), errorReader{err}))
+                        next.ServeHTTP(w, r)
+                        return
+                }
+                r.Body = io.NopCloser(bytes.NewReader(body))
+                key = scopedKey(r, key)
+                fingerprint := requestFingerprint(r, body)
+
+                for {
+                        e, owner := c.begin(key, fingerprint)
+                        if e.fingerprint != fingerprint {
+                                httpError(w, r, http.StatusUnprocessableEntity, errIdempotencyKeyReused,
+                                        "Idempotency-Key was already used for a different request")
+                                return
+                        }
+                        if owner {
+                                rec := &recordingWriter{ResponseWriter: w}
+                                defer c.finish(key, e, rec)
+                                next.ServeHTTP(rec, r)
+                                return
+                        }
+
+                        select {
+                        case <-e.done:
+                        case <-r.Context().Done():
+                                return
+                        }
+                        if !e.ok {
+                                // The first attempt failed and released the key; try to claim it
+                                continue
+                        }
+
+                        // Only the representation is replayed; per-request headers such as X-Request-ID stay fresh
+                        w.Header().Set("Idempotent-Replayed", "true")
+                        writeBytes(w, r, e.status, e.contentType, e.body)
+                        return
+                }
+        })
+}
+
+// scopedKey confines an Idempotency-Key to the client that sent it, by authenticated user
+// or else by address, and to the method and path it was sent to, so a key chosen by one
+// client can never replay another client's response
+func scopedKey(r *http.Request, key string) string {
+        client := "ip:" + clientIP(r)
+        if user := authUserFromContext(r.Context()); user != "" {
+                client = "user:" + user
+        }
+        return strings.Join([]string{client, r.Method, r.URL.Path, key}, "\x00")
+}
+
+// requestFingerprint digests what distinguishes one POST from another sent with the same
+// key: the query, which selects transforms and checksums, the Content-Type, the Accept
+// header that picked the stored representation, and the body
+func requestFingerprint(r *http.Request, body []byte) [sha256.Size]byte {
+        h := sha256.New()
+        for _, part := range []string{r.URL.RawQuery, r.Header.Get("Content-Type"), r.Header.Get("Accept")} {
+                h.Write([]byte(part))
+                h.Write([]byte{0})
+        }
+        h.Write(body)
+        var sum [sha256.Size]byte
+        h.Sum(sum[:0])
+        return sum
+}
+
+// errorReader fails every read with err
+type errorReader struct{ err error }
+
+func (r errorReader) Read([]byte) (int, error) { return 0, r.err }
+
+// idempotent wraps h with the Idempotency-Key cache when it is enabled
+func idempotent(c *idempotencyCache, h http.Handler) http.Handler {
+        if c == nil {
+                return h
+        }
+        return c.middleware(h)
+}
+
+// recordingWriter passes a response through while keeping a copy of it
+type recordingWriter struct {
+        http.ResponseWriter
+        status int
+        body   bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(code int) {
+        if rw.status == 0 {
+                rw.status = code
+        }
+        rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+        if rw.status == 0 {
+                rw.status = http.StatusOK
+        }
+        rw.body.Write(b)
+        return rw.ResponseWriter.Write(b)
+}
+
+func (rw *recordingWriter) Unwrap() http.ResponseWriter {
+        return rw.ResponseWriter
+}
+"""
+
+GO_CONCURRENCY = r"""package main
+
+import (
+        "log/slog"
+        "net/http"
+        "time"
+)
+
+// Concurrency shedding modes: reject answers 503 at once, queue waits up to concurrencyQueueTimeout for a slot
+const (
+        concurrencyModeReject = "reject"
+        concurrencyModeQueue  = "queue"
+)
+
+// shedRetryAfter is the Retry-After hint, in seconds, sent with a shed request
+const shedRetryAfter = "1"
+
+// noConcurrencyLimitPrefixes bypass the limiter: probes must answer under load and SSE and WebSocket streams would pin slots
+var noConcurrencyLimitPrefixes = []string{"/health", "/ready", "/events", "/ws/", "/debug/pprof/"}
+
+// concurrencyLimiter is a counting semaphore over in-progress requests
+type concurrencyLimiter struct {
+        slots        chan struct{}
+        mode         string
+        queueTimeout time.Duration
+}
+
+func newConcurrencyLimiter(max int, mode string, queueTimeout time.Duration) *concurrencyLimiter {
+        return &concurrencyLimiter{
+                slots:        make(chan struct{}, max),
+                mode:         mode,
+                queueTimeout: queueTimeout,
+        }
+}
+
+// acquire takes a slot, waiting in queue mode until the queue timeout or the request ends
+func (l *concurrencyLimiter) acquire(r *http.Request) bool {
+        select {
+        case l.slots <- struct{}{}:
+                return true
+        default:
+        }
+        if l.mode != concurrencyModeQueue {
+                return false
+        }
+
+        timer := time.NewTimer(l.queueTimeout)
+        defer timer.Stop()
+        select {
+        case l.slots <- struct{}{}:
+                return true
+        case <-timer.C:
+                return false
+        case <-r.Context().Done():
+                return false
+        }
+}
+
+func (l *concurrencyLimiter) release() {
+        <-l.slots
+}
+
+// middleware sheds load with 503 and Retry-After once every slot is busy
+func (l *concurrencyLimiter) middleware(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                if hasAnyPrefix(r.URL.Path, noConcurrencyLimitPrefixes) {
+                        next.ServeHTTP(w, r)
+                        return
+                }
+                if !l.acquire(r) {
+                        observeShed()
+                        slog.Debug("shedding request",
+                                "path", r.URL.Path,
+                                "limit", cap(l.slots),
+                                "mode", l.mode,
+                                "request_id", requestIDFromContext(r.Context()))
+                        w.Header().Set("Retry-After", shedRetryAfter)
+                        httpError(w, r, http.StatusServiceUnavailable, errServerBusy, "server busy")
+                        return
+                }
+                defer l.release()
+                next.ServeHTTP(w, r)
+        })
+}
+
+// concurrencyLimited wraps h with the limiter when MAX_CONCURRENT_REQUESTS is set
+func concurrencyLimited(l *concurrencyLimiter, h http.Handler) http.Handler {
+        if l == nil {
+                return h
+        }
+        return l.middleware(h)
+}
+"""
+
+GO_DIAGNOSTICS = r"""package main
+
+import (
+        "context"
+        "errors"
+        "fmt"
+        "net/http"
+        "strconv"
+        "time"
+)
+
+// statusClientClosedRequest is nginx's non-standard code for a client that hung up mid-request
+const statusClientClosedRequest = 499
+
+// maxSlowDelay bounds /slow so a typo cannot pin a goroutine for hours
+const maxSlowDelay = 60 * time.Second
+
+// registerTestRoutes mounts diagnostic endpoints; only called when ENABLE_TEST_ENDPOINTS is true
+func registerTestRoutes(mux *http.ServeMux) {
+        mux.HandleFunc("/slow", slowHandler)
+}
+
+// slowHandler serves GET /slow?ms=N, sleeping N milliseconds before answering so timeout
+// and retry behaviour can be exercised. It stops early if the request context ends:
+// 499 when the client went away, 503 when a server-side deadline fired.
+func slowHandler(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+                methodNotAllowed(w, r, http.MethodGet)
+                return
+        }
+
+        ms, err := strconv.Atoi(r.URL.Query().Get("ms"))
+        if err != nil || ms < 0 || time.Duration(ms)*time.Millisecond > maxSlowDelay {
+                httpError(w, r, http.StatusBadRequest, errInvalidParameter, fmt.Sprintf("ms must be an integer between 0 and %d", maxSlowDelay.Milliseconds()))
+                return
+        }
+        delay := time.Duration(ms) * time.Millisecond
+
+        start := time.Now()
+        timer := time.NewTimer(delay)
+        defer timer.Stop()
+
+        select {
+        case <-timer.C:
+                writeJSON(w, r, http.StatusOK, map[string]any{
+                        "slept_ms": time.Since(start).Milliseconds(),
+                })
+        case <-r.Context().Done():
+                status, code := statusClientClosedRequest, errRequestCanceled
+                if errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+                        status, code = http.StatusServiceUnavailable, errTimeout
+                }
+                httpError(w, r, status, code, fmt.Sprintf("%v after %dms", r.Context().Err(), time.Since(start).Milliseconds()))
+        }
+}
+"""
+
+GO_ETAG = r"""package main
+
+import (
+        "crypto/sha256"
+        "encoding/hex"
+        "net/http"
+        "strings"
+)
+
+// writeCacheable is writeJSON for responses that only change between deploys, such as
+// /version: it adds a content-hash ETag and answers a matching If-None-Match with 304
+func writeCacheable(w http.ResponseWriter, r *http.Request, v any) {
+        body, err := marshalBody(contentTypeJSON, v, wantsPretty(r))
+        if err != nil {
+                encodingFailed(w, r, http.StatusOK, err)
+                return
+        }
+        serveWithETag(w, r, contentTypeJSON, body)
+}
+
+// serveWithETag sends body with a weak ETag, or 304 with no body when the client already has it.
+// The tag is weak because gzipMiddleware may re-encode the bytes on the way out.
+func serveWithETag(w http.ResponseWriter, r *http.Request, contentType string, body []byte) {
+        etag := bodyETag(body)
+        w.Header().Set("ETag", etag)
+        if etagMatches(r.Header.Get("If-None-Match"), etag) {
+                w.WriteHeader(http.StatusNotModified)
+                return
+        }
+        writeBytes(w, r, http.StatusOK, contentType, body)
+}
+
+// bodyETag derives a weak entity tag from the SHA-256 of body
+func bodyETag(body []byte) string {
+        sum := sha256.Sum256(body)
+        return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches applies the weak comparison If-None-Match calls for: any listed tag equal
+// to etag, ignoring W/ prefixes, or "*"
+func etagMatches(header, etag string) bool {
+        if header == "" {
+                return false
+        }
+        want := strings.TrimPrefix(etag, "W/")
+        for _, tag := range strings.Split(header, ",") {
+                tag = strings.TrimSpace(tag)
+                if tag == "*" || strings.TrimPrefix(tag, "W/") == want {
+                        return true
+                }
+        }
+        return false
+}
+"""
+
+GO_STREAM = r"""package main
+
+import (
+        "log/slog"
+        "net/http"
+        "strconv"
+        "unicode/utf8"
+)
+
+// defaultStreamChunkBytes is the chunk size for /echo/stream when ?chunk_size is not given
+const defaultStreamChunkBytes = 256
+
+// handleEchoStream serves POST /echo/stream. The request is the same JSON as /echo, but
+// the message comes back as text/plain in chunks of ?chunk_size bytes (default 256),
+// flushed one at a time with chunked transfer encoding. Chunks never split a UTF-8
+// sequence. The stream stops early if the client goes away.
+func (s *Server) handleEchoStream(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodPost {
+                methodNotAllowed(w, r, http.MethodPost)
+                return
+        }
+
+        chunkSize := defaultStreamChunkBytes
+        if v := r.URL.Query().Get("chunk_size"); v != "" {
+                n, err := strconv.Atoi(v)
+                if err != nil || n < utf8.UTFMax {
+                        httpError(w, r, http.StatusBadRequest, errInvalidParameter, "chunk_size must be an integer of at least 4")
+                        return
+                }
+                chunkSize = n
+        }
+
+        flusher, ok := w.(http.Flusher)
+        if !ok {
+                httpError(w, r, http.StatusInternalServerError, errInternal, "streaming unsupported")
+                return
+        }
+
+        echo, ok := s.decodeEcho(w, r)
+        if !ok || clientGone(w, r, "decoded") {
+                return
+        }
+
+        // No Content-Length, so net/http falls back to chunked encoding
+        w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+        w.Header().Set("X-Content-Type-Options", "nosniff")
+        w.WriteHeader(http.StatusOK)
+
+        ctx := r.Context()
+        msg := echo.Message
+        for len(msg) > 0 {
+                if ctx.Err() != nil {
+                        slog.Debug("echo stream canceled",
+                                "request_id", requestIDFromContext(ctx),
+                                "remaining_bytes", len(msg),
+                                "error", ctx.Err())
+                        return
+                }
+
+                n := min(chunkSize, len(msg))
+                for n < len(msg) && !utf8.RuneStart(msg[n]) {
+                        n--
+                }
+                if _, err := w.Write([]byte(msg[:n])); err != nil {
+                        return
+                }
+                flusher.Flush()
+                msg = msg[n:]
+        }
+}
+"""
+
+GO_ERRORS = r"""package main
+
+import (
+        "encoding/json"
+        "net/http"
+)
+
+// Stable, machine-readable error codes. Clients branch on these, so never rename one;
+// add a new code instead.
+const (
+        errBadGateway           = "bad_gateway"
+        errBodyTooLarge         = "body_too_large"
+        errChaosInjected        = "chaos_injected"
+        errCircuitOpen          = "circuit_open"
+        errDuplicateKey         = "duplicate_key"
+        errEmptyBody            = "empty_body"
+        errHeadersTooLarge      = "headers_too_large"
+        errIdempotencyKeyReused = "idempotency_key_reused"
+        errInternal             = "internal_error"
+        errInvalidBatch         = "invalid_batch"
+        errInvalidForm          = "invalid_form"
+        errInvalidHeader        = "invalid_header"
+        errInvalidJSON          = "invalid_json"
+        errInvalidParameter     = "invalid_parameter"
+        errMethodNotAllowed     = "method_not_allowed"
+        errNotAcceptable        = "not_acceptable"
+        errNotFound             = "not_found"
+        errRateLimited          = "rate_limited"
+        errRequestCanceled      = "request_canceled"
+        errServerBusy           = "server_busy"
+        errShuttingDown         = "shutting_down"
+        errTimeout              = "timeout"
+        errUnauthorized         = "unauthorized"
+        errUnknownTransform     = "unknown_transform"
+        errUnsupportedMediaType = "unsupported_media_type"
+        errURITooLong           = "uri_too_long"
+        errValidation           = "validation_failed"
+)
+
+// ErrorResponse is the body of every error the service returns
+type ErrorResponse struct {
+        Code      string   `json:"code"`
+        Message   string   `json:"message"`
+        Details   []string `json:"details,omitempty"`
+        RequestID string   `json:"request_id,omitempty"`
+}
+
+// httpError answers with status and an ErrorResponse carrying code, message and the request ID
+func httpError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+        writeJSON(w, r, status, ErrorResponse{
+                Code:      code,
+                Message:   message,
+                RequestID: requestIDFromContext(r.Context()),
+        })
+}
+
+// httpErrorDetails is httpError with a list of individual problems, such as schema violations
+func httpErrorDetails(w http.ResponseWriter, r *http.Request, status int, code, message string, details []string) {
+        writeJSON(w, r, status, ErrorResponse{
+                Code:      code,
+                Message:   message,
+                Details:   details,
+                RequestID: requestIDFromContext(r.Context()),
+        })
+}
+
+// internalErrorBody is the fallback 500 body for when encoding the real response failed;
+// an ErrorResponse holds only strings, so marshaling it cannot fail in turn
+func internalErrorBody(r *http.Request) []byte {
+        body, _ := json.Marshal(ErrorResponse{
+                Code:      errInternal,
+                Message:   "internal server error",
+                RequestID: requestIDFromContext(r.Context()),
+        })
+        return append(body, '\n')
+}
+"""
+
+GO_STARTUP = r"""package main
+
+import (
+        "context"
+        "errors"
+        "fmt"
+        "log/slog"
+        "net/http"
+        "time"
+)
+
+// Readiness states. A service starts out unready, becomes ready once its startup checks
+// pass and, once draining, never goes back.
+const (
+        readinessStarting int32 = iota
+        readinessReady
+        readinessDraining
+)
+
+// markReady moves a starting service to ready. It reports false, and changes nothing, when
+// a shutdown has already begun, so a late startup check can never undo it.
+func (s *Server) markReady() bool {
+        return s.readiness.CompareAndSwap(readinessStarting, readinessReady)
+}
+
+// markDraining makes /ready report 503 for good
+func (s *Server) markDraining() {
+        s.readiness.Store(readinessDraining)
+}
+
+// isReady reports whether the service should receive traffic
+func (s *Server) isReady() bool {
+        return s.readiness.Load() == readinessReady
+}
+
+// startupCheck is one self-check that must pass before the service reports ready
+type startupCheck struct {
+        name string
+        run  func(ctx context.Context) error
+}
+
+// startupChecks builds the checks enabled by cfg. Configuration itself has already been
+// validated by loadConfig, so only external dependencies remain.
+func startupChecks(cfg Config) []startupCheck {
+        var checks []startupCheck
+        if cfg.DependencyCheckURL != "" {
+                checks = append(checks, startupCheck{
+                        name: "dependency",
+                        run: func(ctx context.Context) error {
+                                return pingURL(ctx, cfg.DependencyCheckURL)
+                        },
+                })
+        }
+        return checks
+}
+
+// runStartupChecks runs every check, each bounded by timeout, and joins the failures
+func runStartupChecks(ctx context.Context, checks []startupCheck, timeout time.Duration) error {
+        var errs []error
+        for _, c := range checks {
+                checkCtx, cancel := context.WithTimeout(ctx, timeout)
+                err := c.run(checkCtx)
+                cancel()
+                if err != nil {
+                        errs = append(errs, fmt.Errorf("%s: %w", c.name, err))
+                        continue
+                }
+                slog.Debug("startup check passed", "check", c.name)
+        }
+        return errors.Join(errs...)
+}
+
+// retryStartupChecks reruns the checks every interval until they pass, then marks the
+// service ready. It gives up when ctx ends, and markReady refuses once a shutdown has begun.
+func (s *Server) retryStartupChecks(ctx context.Context, checks []startupCheck, timeout, interval time.Duration) {
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+
+        for {
+                select {
+                case <-ctx.Done():
+                        return
+                case <-ticker.C:
+                }
+
+                if err := runStartupChecks(ctx, checks, timeout); err != nil {
+                        slog.Warn("startup checks still failing", "error", err)
+                        continue
+                }
+                if ctx.Err() == nil && s.markReady() {
+                        slog.Info("startup checks passed, service ready")
+                }
+                return
+        }
+}
+
+// pingURL GETs url and expects a 2xx answer
+func pingURL(ctx context.Context, url string) error {
+        req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+        if err != nil {
+                return err
+        }
+        resp, err := http.DefaultClient.Do(req)
+        if err != nil {
+                return err
+        }
+        resp.Body.Close()
+
+        if resp.StatusCode < 200 || resp.StatusCode > 299 {
+                return fmt.Errorf("GET %s: HTTP %d", url, resp.StatusCode)
+        }
+        return nil
+}
+"""
+
+GO_STATS = r"""package main
+
+import (
+        "net/http"
+        "sort"
+        "sync"
+        "sync/atomic"
+        "time"
+)
+
+// statsReservoirSize is how many recent latencies each endpoint keeps for /stats
+const statsReservoirSize = 1024
+
+// maxStatsEndpoints caps distinct routes tracked so the map cannot grow without bound
+const maxStatsEndpoints = 100
+
+// statsOverflowKey collects requests for routes beyond maxStatsEndpoints
+const statsOverflowKey = "other"
+
+// inFlightMiddleware keeps inFlight accurate; the deferred decrement also runs when a handler panics
+func inFlightMiddleware(inFlight *atomic.Int64, next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                inFlight.Add(1)
+                defer inFlight.Add(-1)
+                next.ServeHTTP(w, r)
+        })
+}
+
+// endpointStats accumulates one path's counters and a ring of its most recent latencies
+type endpointStats struct {
+        count        atomic.Int64
+        errors       atomic.Int64
+        clientErrors atomic.Int64
+
+        mu        sync.Mutex
+        latencies [statsReservoirSize]time.Duration
+        next      int
+        filled    bool
+}
+
+func (e *endpointStats) record(status int, dur time.Duration) {
+        e.count.Add(1)
+        switch {
+        case status >= 500:
+                e.errors.Add(1)
+        case status >= 400:
+                e.clientErrors.Add(1)
+        }
+
+        e.mu.Lock()
+        e.latencies[e.next] = dur
+        e.next = (e.next + 1) % statsReservoirSize
+        if e.next == 0 {
+                e.filled = true
+        }
+        e.mu.Unlock()
+}
+
+// LatencySummary describes the latencies in an endpoint's reservoir, in milliseconds
+type LatencySummary struct {
+        Samples int     `json:"samples"`
+        Avg     float64 `json:"avg"`
+        P50     float64 `json:"p50"`
+        P90     float64 `json:"p90"`
+        P99     float64 `json:"p99"`
+        Max     float64 `json:"max"`
+}
+
+// EndpointSnapshot is one endpoint's entry in /stats
+type EndpointSnapshot struct {
+        Count        int64          `json:"count"`
+        Errors       int64          `json:"errors"`
+        ClientErrors int64          `json:"client_errors"`
+        LatencyMS    LatencySummary `json:"latency_ms"`
+}
+
+func (e *endpointStats) snapshot() EndpointSnapshot {
+        e.mu.Lock()
+        n := e.next
+        if e.filled {
+                n = statsReservoirSize
+        }
+        samples := make([]time.Duration, n)
+        copy(samples, e.latencies[:n])
+        e.mu.Unlock()
+
+        snap := EndpointSnapshot{
+                Count:        e.count.Load(),
+                Errors:       e.errors.Load(),
+                ClientErrors: e.clientErrors.Load(),
+        }
+        if n == 0 {
+                return snap
+        }
+
+        sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+        var total time.Duration
+        for _, d := range samples {
+                total += d
+        }
+        ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+        pct := func(p float64) float64 { return ms(samples[int(p*float64(n-1))]) }
+
+        snap.LatencyMS = LatencySummary{
+                Samples: n,
+                Avg:     ms(total / time.Duration(n)),
+                P50:     pct(0.50),
+                P90:     pct(0.90),
+                P99:     pct(0.99),
+                Max:     ms(samples[n-1]),
+        }
+        return snap
+}
+
+// statsCollector holds endpointStats by route label
+type statsCollector struct {
+        mu        sync.RWMutex
+        endpoints map[string]*endpointStats
+}
+
+func newStatsCollector() *statsCollector {
+        return &statsCollector{endpoints: make(map[string]*endpointStats)}
+}
+
+// observe records a completed request for route
+func (c *statsCollector) observe(path string, status int, dur time.Duration) {
+        c.mu.RLock()
+        e, ok := c.endpoints[path]
+        c.mu.RUnlock()
+        if !ok {
+                e = c.getOrCreate(path)
+        }
+        e.record(status, dur)
+}
+
+func (c *statsCollector) getOrCreate(path string) *endpointStats {
+        c.mu.Lock()
+        defer c.mu.Unlock()
+
+        if e, ok := c.endpoints[path]; ok {
+                return e
+        }
+        if len(c.endpoints) >= maxStatsEndpoints {
+                path = statsOverflowKey
+                if e, ok := c.endpoints[path]; ok {
+                        return e
+                }
+        }
+        e := &endpointStats{}
+        c.endpoints[path] = e
+        return e
+}
+
+// Stats is the body of GET /stats
+type Stats struct {
+        UptimeSeconds float64                     `json:"uptime_seconds"`
+        InFlight      int64                       `json:"in_flight"`
+        Endpoints     map[string]EndpointSnapshot `json:"endpoints"`
+        Breakers      []BreakerState              `json:"breakers,omitempty"`
+        Transforms    *TransformCacheStats        `json:"transform_cache,omitempty"`
+}
+
+// snapshot reports every tracked endpoint by route label
+func (c *statsCollector) snapshot() map[string]EndpointSnapshot {
+        c.mu.RLock()
+        defer c.mu.RUnlock()
+
+        out := make(map[string]EndpointSnapshot, len(c.endpoints))
+        for path, e := range c.endpoints {
+                out[path] = e.snapshot()
+        }
+        return out
+}
+
+// handleStats serves the in-process request statistics for deployments without Prometheus
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+                methodNotAllowed(w, r, http.MethodGet)
+                return
+        }
+        writeJSON(w, r, http.StatusOK, Stats{
+                UptimeSeconds: s.uptime().Seconds(),
+                InFlight:      s.inFlight.Load(),
+                Endpoints:     s.stats.snapshot(),
+                Breakers:      s.breakers.snapshot(),
+                Transforms:    s.transformResults.snapshot(),
+        })
+}
+"""
+
+GO_AUTH = r"""package main
+
+import (
+        "context"
+        "crypto/sha256"
+        "crypto/subtle"
+        "log/slog"
+        "net/http"
+        "strings"
+)
+
+// unprotectedPaths never require credentials so orchestrator probes keep working
+var unprotectedPaths = []string{"/health", "/ready"}
+
+// basicAuth guards PROTECTED_PATHS with a single BASIC_AUTH_USER / BASIC_AUTH_PASS pair.
+// Credentials are kept as SHA-256 digests so comparisons are constant time regardless of length.
+type basicAuth struct {
+        user  [sha256.Size]byte
+        pass  [sha256.Size]byte
+        paths []string
+}
+
+func newBasicAuth(user, pass string, paths []string) *basicAuth {
+        return &basicAuth{
+                user:  sha256.Sum256([]byte(user)),
+                pass:  sha256.Sum256([]byte(pass)),
+                paths: paths,
+        }
+}
+
+// protects reports whether path is one of the protected paths or beneath one
+func (a *basicAuth) protects(path string) bool {
+        for _, p := range unprotectedPaths {
+                if pathWithin(path, p) {
+                        return false
+                }
+        }
+        for _, p := range a.paths {
+                if pathWithin(path, p) {
+                        return true
+                }
+        }
+        return false
+}
+
+// pathWithin reports whether path is base or a sub-path of it
+func pathWithin(path, base string) bool {
+        return path == base || strings.HasPrefix(path, strings.TrimSuffix(base, "/")+"/")
+}
+
+// valid checks the request's Basic credentials; both halves are always compared
+func (a *basicAuth) valid(r *http.Request) bool {
+        user, pass, ok := r.BasicAuth()
+        if !ok {
+                return false
+        }
+        gotUser := sha256.Sum256([]byte(user))
+        gotPass := sha256.Sum256([]byte(pass))
+        userOK := subtle.ConstantTimeCompare(gotUser[:], a.user[:])
+        passOK := subtle.ConstantTimeCompare(gotPass[:], a.pass[:])
+        return userOK&passOK == 1
+}
+
+func (a *basicAuth) middleware(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                // CORS preflights never carry credentials
+                isPreflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+                if isPreflight || !a.protects(r.URL.Path) {
+                        next.ServeHTTP(w, r)
+                        return
+                }
+                if a.valid(r) {
+                        user, _, _ := r.BasicAuth()
+                        next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authUserKey, user)))
+                        return
+                }
+
+                slog.Warn("basic auth rejected",
+                        "path", r.URL.Path,
+                        "remote_addr", r.RemoteAddr,
+                        "request_id", requestIDFromContext(r.Context()))
+                w.Header().Set("WWW-Authenticate", `Basic realm="`+serviceName+`", charset="UTF-8"`)
+                httpError(w, r, http.StatusUnauthorized, errUnauthorized, "unauthorized")
+        })
+}
+
+// authUserFromContext returns the user whose credentials basicAuth accepted, or "" when the
+// request needed none
+func authUserFromContext(ctx context.Context) string {
+        user, _ := ctx.Value(authUserKey).(string)
+        return user
+}
+
+// basicAuthProtected wraps h with a when BASIC_AUTH_USER and BASIC_AUTH_PASS are set
+func basicAuthProtected(a *basicAuth, h http.Handler) http.Handler {
+        if a == nil {
+                return h
+        }
+        return a.middleware(h)
+}
+"""
+
+GO_FIELDSTYLE = r"""package main
+
+import (
+        "encoding/json"
+        "time"
+)
+
+// JSON_FIELD_STYLE values
+const (
+        fieldStyleSnake = "snake"
+        fieldStyleCamel = "camel"
+)
+
+// jsonFieldStyle selects the JSON field names of Echo, EchoChecksum and DetailedHealth,
+// including its dependencies. Only the wire names change; the OpenAPI document and the Go
+// client describe the snake form.
+var jsonFieldStyle = fieldStyleSnake
+
+// echoSnake, echoChecksumSnake, detailedHealthSnake and dependencyStatusSnake drop the
+// MarshalJSON methods so the default tags apply
+type (
+        echoSnake             Echo
+        echoChecksumSnake     EchoChecksum
+        detailedHealthSnake   DetailedHealth
+        dependencyStatusSnake DependencyStatus
+)
+
+// echoCamel mirrors Echo field for field so the two convert directly
+type echoCamel struct {
+        Message     string    `json:"message"`
+        Metadata    Metadata  `json:"metadata,omitempty"`
+        Timestamp   time.Time `json:"timestamp"`
+        ReceivedAt  time.Time `json:"receivedAt"`
+        ProcessedAt time.Time `json:"processedAt"`
+        Service     string    `json:"service"`
+        RequestID   string    `json:"requestId,omitempty"`
+        TraceID     string    `json:"traceId,omitempty"`
+}
+
+// echoChecksumCamel mirrors EchoChecksum
+type echoChecksumCamel struct {
+        Algorithm   string    `json:"algorithm"`
+        Checksum    string    `json:"checksum"`
+        Length      int       `json:"length"`
+        Metadata    Metadata  `json:"metadata,omitempty"`
+        Timestamp   time.Time `json:"timestamp"`
+        ReceivedAt  time.Time `json:"receivedAt"`
+        ProcessedAt time.Time `json:"processedAt"`
+        Service     string    `json:"service"`
+        RequestID   string    `json:"requestId,omitempty"`
+        TraceID     string    `json:"traceId,omitempty"`
+}
+
+// detailedHealthCamel mirrors DetailedHealth; the embedded Health fields are single words
+// and read the same in either style
+type detailedHealthCamel struct {
+        Health
+        Status         string             `json:"status"`
+        UptimeSeconds  float64            `json:"uptimeSeconds"`
+        Goroutines     int                `json:"goroutines"`
+        HeapAllocBytes uint64             `json:"heapAllocBytes"`
+        Checks         []CheckResult      `json:"checks,omitempty"`
+        Dependencies   []DependencyStatus `json:"dependencies,omitempty"`
+}
+
+// dependencyStatusCamel mirrors DependencyStatus
+type dependencyStatusCamel struct {
+        Name        string     `json:"name"`
+        Required    bool       `json:"required"`
+        Status      string     `json:"status"`
+        LastChecked *time.Time `json:"lastChecked,omitempty"`
+        LatencyMS   float64    `json:"latencyMs,omitempty"`
+        Error       string     `json:"error,omitempty"`
+}
+
+// MarshalJSON emits Echo with the field names selected by JSON_FIELD_STYLE
+func (e Echo) MarshalJSON() ([]byte, error) {
+        if jsonFieldStyle == fieldStyleCamel {
+                return json.Marshal(echoCamel(e))
+        }
+        return json.Marshal(echoSnake(e))
+}
+
+// MarshalJSON emits the id alongside the Echo fields. Without it the embedded Echo's method
+// would be promoted and the id dropped.
+func (m StoredMessage) MarshalJSON() ([]byte, error) {
+        if jsonFieldStyle == fieldStyleCamel {
+                return json.Marshal(struct {
+                        ID int64 `json:"id"`
+                        echoCamel
+                }{m.ID, echoCamel(m.Echo)})
+        }
+        return json.Marshal(struct {
+                ID int64 `json:"id"`
+                echoSnake
+        }{m.ID, echoSnake(m.Echo)})
+}
+
+// MarshalJSON emits EchoChecksum with the field names selected by JSON_FIELD_STYLE
+func (c EchoChecksum) MarshalJSON() ([]byte, error) {
+        if jsonFieldStyle == fieldStyleCamel {
+                return json.Marshal(echoChecksumCamel(c))
+        }
+        return json.Marshal(echoChecksumSnake(c))
+}
+
+// MarshalJSON emits DetailedHealth with the field names selected by JSON_FIELD_STYLE
+func (h DetailedHealth) MarshalJSON() ([]byte, error) {
+        if jsonFieldStyle == fieldStyleCamel {
+                return json.Marshal(detailedHealthCamel(h))
+        }
+        return json.Marshal(detailedHealthSnake(h))
+}
+
+// MarshalJSON emits DependencyStatus with the field names selected by JSON_FIELD_STYLE
+func (d DependencyStatus) MarshalJSON() ([]byte, error) {
+        if jsonFieldStyle == fieldStyleCamel {
+                return json.Marshal(dependencyStatusCamel(d))
+        }
+        return json.Marshal(dependencyStatusSnake(d))
+}
+"""
+
+GO_SIGNING = r"""package main
+
+import (
+        "bytes"
+        "crypto/hmac"
+        "crypto/sha256"
+        "encoding/hex"
+        "net/http"
+)
+
+// Signature headers: clients may pick a key with signatureKeyIDHeader, and the response
+// names the key it was signed with
+const (
+        signatureHeader      = "X-Signature"
+        signatureKeyIDHeader = "X-Signature-Key-Id"
+)
+
+// responseSigner adds an HMAC-SHA256 of the response body to every response it wraps.
+// Several keys may be loaded at once so clients can move to a new one before the old is retired.
+type responseSigner struct {
+        keys      map[string][]byte
+        defaultID string
+}
+
+func newResponseSigner(keys map[string]string, defaultID string) *responseSigner {
+        s := &responseSigner{keys: make(map[string][]byte, len(keys)), defaultID: defaultID}
+        for id, secret := range keys {
+                s.keys[id] = []byte(secret)
+        }
+        return s
+}
+
+// sign returns the X-Signature value for body under key
+func sign(key, body []byte) string {
+        mac := hmac.New(sha256.New, key)
+        mac.Write(body)
+        return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *responseSigner) middleware(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                id := r.Header.Get(signatureKeyIDHeader)
+                if id == "" {
+                        id = s.defaultID
+                }
+                key, ok := s.keys[id]
+                if !ok {
+                        httpError(w, r, http.StatusBadRequest, errInvalidHeader, "unknown "+signatureKeyIDHeader)
+                        return
+                }
+
+                bw := &bufferedWriter{ResponseWriter: w, status: http.StatusOK}
+                next.ServeHTTP(bw, r)
+
+                // The signature covers the body as the handler wrote it, before any gzip encoding
+                w.Header().Set(signatureHeader, sign(key, bw.body.Bytes()))
+                w.Header().Set(signatureKeyIDHeader, id)
+                w.WriteHeader(bw.status)
+                w.Write(bw.body.Bytes())
+        })
+}
+
+// signed wraps h with s when SIGNING_KEY or SIGNING_KEYS is set
+func signed(s *responseSigner, h http.Handler) http.Handler {
+        if s == nil {
+                return h
+        }
+        return s.middleware(h)
+}
+
+// bufferedWriter holds the status and body back until the wrapper has seen all of it
+type bufferedWriter struct {
+        http.ResponseWriter
+        status      int
+        wroteHeader bool
+        body        bytes.Buffer
+}
+
+func (bw *bufferedWriter) WriteHeader(code int) {
+        if !bw.wroteHeader {
+                bw.status = code
+                bw.wroteHeader = true
+        }
+}
+
+func (bw *bufferedWriter) Write(b []byte) (int, error) {
+        bw.wroteHeader = true
+        return bw.body.Write(b)
+}
+"""
+
+GO_RELOAD = r"""package main
+
+import (
+        "log/slog"
+        "os"
+        "os/signal"
+        "sync/atomic"
+        "syscall"
+)
+
+// liveConfig is the configuration read per request by middleware whose settings can
+// change on SIGHUP. Everything else is fixed at startup through applyConfig.
+var liveConfig atomic.Pointer[Config]
+
+func init() {
+        cfg := defaultConfig()
+        cfg.routeLimits = routeLimitsFor(cfg)
+        liveConfig.Store(&cfg)
+}
+
+// currentConfig returns the configuration in effect for the request being served
+func currentConfig() *Config {
+        return liveConfig.Load()
+}
+
+// reloadable copies the settings that take effect without a restart from next into cfg
+func reloadable(cfg, next Config) Config {
+        cfg.LogLevel = next.LogLevel
+        cfg.RateLimitRPS = next.RateLimitRPS
+        cfg.RateLimitBurst = next.RateLimitBurst
+        cfg.MaxBodyBytes = next.MaxBodyBytes
+        cfg.EchoBatchMaxBodyBytes = next.EchoBatchMaxBodyBytes
+        cfg.ResponseCacheTTL = next.ResponseCacheTTL
+        cfg.ResponseCachePaths = next.ResponseCachePaths
+        cfg.routeLimits = routeLimitsFor(cfg)
+        return cfg
+}
+
+// reloadConfig re-runs loadConfig and swaps in the reloadable settings. A process's
+// environment is fixed once it starts, so in practice new values arrive through CONFIG_FILE.
+// An invalid configuration is logged and the running one kept. The access log file is
+// reopened either way, so SIGHUP also serves as the log rotation signal.
+func (s *Server) reloadConfig() {
+        if accessLogs != nil {
+                if err := accessLogs.reopen(); err != nil {
+                        slog.Error("reopening access log failed, still writing to the old file", "path", accessLogs.path, "error", err)
+                }
+        }
+
+        next, err := loadConfig()
+        if err != nil {
+                slog.Error("configuration reload failed, keeping current settings", "error", err)
+                return
+        }
+
+        prev := *currentConfig()
+        cfg := reloadable(prev, next)
+        if ignored := changedSettings(cfg, next); len(ignored) > 0 {
+                slog.Warn("configuration reload ignored settings that need a restart", "settings", ignored)
+        }
+
+        logLevel.Set(cfg.LogLevel)
+        liveConfig.Store(&cfg)
+        responses.purge()
+        s.transformResults.purge()
+        slog.Info("configuration reloaded", "changed", changedSettings(prev, cfg))
+}
+
+// changedSettings names the startup-summary fields that differ between a and b
+func changedSettings(a, b Config) []string {
+        before := a.LogValue().Group()
+        after := b.LogValue().Group()
+
+        var changed []string
+        for i := range before {
+                if !before[i].Equal(after[i]) {
+                        changed = append(changed, before[i].Key)
+                }
+        }
+        return changed
+}
+
+// watchReload reloads the configuration on every SIGHUP until the process exits. SIGHUP
+// is caught from the moment it returns, so it can no longer terminate the process.
+func (s *Server) watchReload() {
+        hup := make(chan os.Signal, 1)
+        signal.Notify(hup, syscall.SIGHUP)
+        go func() {
+                for range hup {
+                        slog.Info("SIGHUP received, reloading configuration")
+                        s.reloadConfig()
+                }
+        }()
+}
+"""
+
+GO_HEALTHCHECK = r"""package main
+
+import (
+        "context"
+        "fmt"
+        "log/slog"
+        "os"
+        "runtime"
+        "sync"
+        "time"
+)
+
+// healthCheckTimeout bounds each check run by /health/detailed
+const healthCheckTimeout = 2 * time.Second
+
+// HealthChecker is one component of /health/detailed. Check returns nil when healthy.
+type HealthChecker interface {
+        Name() string
+        Check(ctx context.Context) error
+}
+
+// healthCheckFunc adapts a plain function to HealthChecker
+type healthCheckFunc struct {
+        name string
+        fn   func(ctx context.Context) error
+}
+
+func (c healthCheckFunc) Name() string                    { return c.name }
+func (c healthCheckFunc) Check(ctx context.Context) error { return c.fn(ctx) }
+
+// CheckResult is one checker's entry in /health/detailed
+type CheckResult struct {
+        Name     string `json:"name"`
+        Required bool   `json:"required"`
+        OK       bool   `json:"ok"`
+        Error    string `json:"error,omitempty"`
+}
+
+type registeredCheck struct {
+        checker  HealthChecker
+        required bool
+}
+
+// healthRegistry holds the checks /health/detailed reports on. A failing required check
+// makes the service unhealthy; an optional one is reported but does not.
+type healthRegistry struct {
+        mu     sync.RWMutex
+        checks []registeredCheck
+}
+
+// healthChecks is the registry served by /health/detailed
+var healthChecks = &healthRegistry{}
+
+// register adds c; checks are reported in registration order
+func (h *healthRegistry) register(c HealthChecker, required bool) {
+        h.mu.Lock()
+        defer h.mu.Unlock()
+        h.checks = append(h.checks, registeredCheck{checker: c, required: required})
+}
+
+// run executes every check concurrently, each bounded by timeout, and reports whether
+// all required checks passed
+func (h *healthRegistry) run(ctx context.Context, timeout time.Duration) ([]CheckResult, bool) {
+        h.mu.RLock()
+        checks := append([]registeredCheck(nil), h.checks...)
+        h.mu.RUnlock()
+
+        results := make([]CheckResult, len(checks))
+        var wg sync.WaitGroup
+        for i, c := range checks {
+                wg.Add(1)
+                go func(i int, c registeredCheck) {
+                        defer wg.Done()
+                        checkCtx, cancel := context.WithTimeout(ctx, timeout)
+                        defer cancel()
+
+                        res := CheckResult{Name: c.checker.Name(), Required: c.required, OK: true}
+                        if err := c.checker.Check(checkCtx); err != nil {
+                                res.OK = false
+                                res.Error = err.Error()
+                        }
+                        results[i] = res
+                }(i, c)
+        }
+        wg.Wait()
+
+        healthy := true
+        for _, res := range results {
+                if !res.OK && res.Required {
+                        healthy = false
+                }
+        }
+        return results, healthy
+}
+
+// registerBuiltinHealthChecks installs the checks enabled by cfg
+func registerBuiltinHealthChecks(cfg Config) {
+        if cfg.HealthDiskPath != "" {
+                healthChecks.register(diskWritableCheck(cfg.HealthDiskPath), true)
+        }
+        if cfg.HealthMaxHeapBytes > 0 {
+                healthChecks.register(memoryThresholdCheck(uint64(cfg.HealthMaxHeapBytes)), false)
+        }
+}
+
+// diskWritableCheck fails unless a file can be created and removed in dir
+func diskWritableCheck(dir string) HealthChecker {
+        return healthCheckFunc{name: "disk_writable", fn: func(ctx context.Context) error {
+                f, err := os.CreateTemp(dir, ".healthcheck-*")
+                if err != nil {
+                        return err
+                }
+                name := f.Name()
+                if err := f.Close(); err != nil {
+                        os.Remove(name)
+                        return err
+                }
+                return os.Remove(name)
+        }}
+}
+
+// memoryThresholdCheck fails once the live heap grows past max bytes
+func memoryThresholdCheck(max uint64) HealthChecker {
+        return healthCheckFunc{name: "memory", fn: func(ctx context.Context) error {
+                var mem runtime.MemStats
+                runtime.ReadMemStats(&mem)
+                if mem.HeapAlloc > max {
+                        return fmt.Errorf("heap %d bytes exceeds %d", mem.HeapAlloc, max)
+                }
+                return nil
+        }}
+}
+
+// logCheckFailures records failing checks so an unhealthy 503 can be traced afterwards
+func logCheckFailures(results []CheckResult) {
+        for _, res := range results {
+                if !res.OK {
+                        slog.Warn("health check failed", "check", res.Name, "required", res.Required, "error", res.Error)
+                }
+        }
+}
+"""
+
+GO_CACHE = r"""package main
+
+import (
+        "net/http"
+        "slices"
+        "strconv"
+        "sync"
+        "time"
+)
+
+// maxResponseCacheEntries bounds the cache; Accept and query variants could otherwise grow it freely
+const maxResponseCacheEntries = 256
+
+// hopByHopHeaders describe one connection rather than the response, so they are never stored
+var hopByHopHeaders = map[string]bool{
+        "Connection":          true,
+        "Keep-Alive":          true,
+        "Proxy-Authenticate":  true,
+        "Proxy-Authorization": true,
+        "Te":                  true,
+        "Trailer":             true,
+        "Transfer-Encoding":   true,
+        "Upgrade":             true,
+}
+
+// cacheEntry is a stored 200: the headers the handler set and the body it wrote
+type cacheEntry struct {
+        header  http.Header
+        body    []byte
+        expires time.Time
+}
+
+// responseCache keeps 200 responses to GET requests on RESPONSE_CACHE_PATHS for
+// RESPONSE_CACHE_TTL. Both settings are read from the live configuration, and a reload
+// empties the cache.
+type responseCache struct {
+        mu      sync.Mutex
+        entries map[string]cacheEntry
+}
+
+// responses is the process-wide response cache
+var responses = &responseCache{entries: make(map[string]cacheEntry)}
+
+// cacheKey separates responses by everything that changes the body: path, query (for
+// ?pretty), Accept and X-Pretty
+func cacheKey(r *http.Request) string {
+        return r.URL.Path + "?" + r.URL.RawQuery + "\x00" + r.Header.Get("Accept") + "\x00" + r.Header.Get("X-Pretty")
+}
+
+func (c *responseCache) get(key string, now time.Time) (cacheEntry, bool) {
+        c.mu.Lock()
+        defer c.mu.Unlock()
+        e, ok := c.entries[key]
+        if ok && now.After(e.expires) {
+                delete(c.entries, key)
+                return cacheEntry{}, false
+        }
+        return e, ok
+}
+
+func (c *responseCache) put(key string, e cacheEntry) {
+        c.mu.Lock()
+        defer c.mu.Unlock()
+        if len(c.entries) >= maxResponseCacheEntries {
+                for k, old := range c.entries {
+                        if time.Now().After(old.expires) {
+                                delete(c.entries, k)
+                        }
+                }
+                if len(c.entries) >= maxResponseCacheEntries {
+                        return
+                }
+        }
+        c.entries[key] = e
+}
+
+// purge drops every entry
+func (c *responseCache) purge() {
+        c.mu.Lock()
+        defer c.mu.Unlock()
+        clear(c.entries)
+}
+
+func (c *responseCache) middleware(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                cfg := currentConfig()
+                if cfg.ResponseCacheTTL <= 0 || r.Method != http.MethodGet || !slices.Contains(cfg.ResponseCachePaths, r.URL.Path) {
+                        next.ServeHTTP(w, r)
+                        return
+                }
+
+                key := cacheKey(r)
+                now := time.Now()
+                if e, ok := c.get(key, now); ok {
+                        h := w.Header()
+                        for name, values := range e.header {
+                                h[name] = slices.Clone(values)
+                        }
+                        h.Set("X-Cache", "HIT")
+                        if etag := h.Get("ETag"); etag != "" && etagMatches(r.Header.Get("If-None-Match"), etag) {
+                                h.Del("Content-Length")
+                                w.WriteHeader(http.StatusNotModified)
+                                return
+                        }
+                        h.Set("Content-Length", strconv.Itoa(len(e.body)))
+                        w.WriteHeader(http.StatusOK)
+                        w.Write(e.body)
+                        return
+                }
+
+                w.Header().Set("X-Cache", "MISS")
+                before := w.Header().Clone()
+                rec := &recordingWriter{ResponseWriter: w}
+                next.ServeHTTP(rec, r)
+                if rec.status == http.StatusOK {
+                        c.put(key, cacheEntry{
+                                header:  handlerHeaders(before, w.Header()),
+                                body:    rec.body.Bytes(),
+                                expires: now.Add(cfg.ResponseCacheTTL),
+                        })
+                }
+        })
+}
+
+// handlerHeaders returns the headers in after that the handler set or changed from before,
+// less the hop-by-hop ones. A hit replays exactly these, so per-request headers set further
+// out, such as X-Request-ID and CORS, stay the current request's own.
+func handlerHeaders(before, after http.Header) http.Header {
+        out := make(http.Header, len(after))
+        for name, values := range after {
+                if hopByHopHeaders[name] || slices.Equal(before[name], values) {
+                        continue
+                }
+                out[name] = slices.Clone(values)
+        }
+        return out
+}
+"""
+
+GO_WEBSOCKET = r"""//go:build websocket
+
+package main
+
+import (
+        "bufio"
+        "errors"
+        "log/slog"
+        "net"
+        "net/http"
+        "strings"
+        "sync"
+        "time"
+
+        "github.com/gorilla/websocket"
+)
+
+// WebSocket timing: a client must answer pings within wsPongWait; pings go out every wsPingPeriod
+const (
+        wsPongWait     = 60 * time.Second
+        wsPingPeriod   = wsPongWait * 9 / 10
+        wsWriteTimeout = 10 * time.Second
+)
+
+var wsUpgrader = websocket.Upgrader{CheckOrigin: wsCheckOrigin}
+
+func init() {
+        builtFeatures["websocket"] = websocketFeature{}
+}
+
+// websocketFeature serves GET /ws/echo
+type websocketFeature struct{}
+
+func (websocketFeature) routes(s *Server, mux *http.ServeMux) []string {
+        mux.HandleFunc("/ws/echo", s.handleWSEcho)
+        return []string{"GET /ws/echo"}
+}
+
+func (websocketFeature) adminRoutes(*Server, *http.ServeMux) {}
+
+func (websocketFeature) shutdown() { websockets.close() }
+
+// wsCheckOrigin allows same-host pages, non-browser clients and CORS_ALLOWED_ORIGINS
+func wsCheckOrigin(r *http.Request) bool {
+        origin := r.Header.Get("Origin")
+        if origin == "" || matchOrigin(origin) != "" {
+                return true
+        }
+        _, host, _ := strings.Cut(origin, "://")
+        return strings.EqualFold(host, r.Host)
+}
+
+// wsHub tracks open connections so shutdown can close them; http.Server.Shutdown does not
+// wait for hijacked connections
+type wsHub struct {
+        mu     sync.Mutex
+        conns  map[*websocket.Conn]struct{}
+        closed bool
+}
+
+var websockets = &wsHub{conns: make(map[*websocket.Conn]struct{})}
+
+func (h *wsHub) add(c *websocket.Conn) bool {
+        h.mu.Lock()
+        defer h.mu.Unlock()
+        if h.closed {
+                return false
+        }
+        h.conns[c] = struct{}{}
+        return true
+}
+
+func (h *wsHub) remove(c *websocket.Conn) {
+        h.mu.Lock()
+        defer h.mu.Unlock()
+        delete(h.conns, c)
+}
+
+// close sends every client a going-away frame and drops the connections; registered with
+// server.RegisterOnShutdown
+func (h *wsHub) close() {
+        h.mu.Lock()
+        defer h.mu.Unlock()
+        h.closed = true
+        msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+        for c := range h.conns {
+                c.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+                c.Close()
+        }
+}
+
+// hijackWriter exposes Hijack through the middleware wrappers, which only implement Unwrap;
+// gorilla/websocket type-asserts http.Hijacker directly
+type hijackWriter struct {
+        http.ResponseWriter
+}
+
+func (w hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+        return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// handleWSEcho serves GET /ws/echo: each text frame comes back as a stamped Echo in JSON,
+// and an invalid message gets an ErrorResponse frame without closing the connection
+func (s *Server) handleWSEcho(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+                methodNotAllowed(w, r, http.MethodGet)
+                return
+        }
+
+        conn, err := wsUpgrader.Upgrade(hijackWriter{w}, r, nil)
+        if err != nil {
+                // Upgrade has already answered the client
+                slog.Debug("websocket upgrade failed", "request_id", requestIDFromContext(r.Context()), "error", err)
+                return
+        }
+        defer conn.Close()
+        if !websockets.add(conn) {
+                return
+        }
+        defer websockets.remove(conn)
+
+        conn.SetReadLimit(bodyLimitFor(r.URL.Path))
+        conn.SetReadDeadline(time.Now().Add(wsPongWait))
+        conn.SetPongHandler(func(string) error {
+                return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+        })
+
+        done := make(chan struct{})
+        defer close(done)
+        go wsPing(conn, done)
+
+        for {
+                msgType, data, err := conn.ReadMessage()
+                if err != nil {
+                        if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) && !errors.Is(err, net.ErrClosed) {
+                                slog.Debug("websocket read failed", "request_id", requestIDFromContext(r.Context()), "error", err)
+                        }
+                        return
+                }
+                if msgType != websocket.TextMessage {
+                        msg := websocket.FormatCloseMessage(websocket.CloseUnsupportedData, "text frames only")
+                        conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsWriteTimeout))
+                        return
+                }
+
+                // Each frame is its own request, received when it was read
+                var reply any
+                echo := Echo{Message: string(data), ReceivedAt: s.clock.Now()}
+                if err := echo.Validate(); err != nil {
+                        reply = ErrorResponse{Code: errValidation, Message: err.Error(), RequestID: requestIDFromContext(r.Context())}
+                } else {
+                        s.stampEcho(&echo, r)
+                        reply = echo
+                }
+
+                conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+                if err := conn.WriteJSON(reply); err != nil {
+                        slog.Debug("websocket write failed", "request_id", requestIDFromContext(r.Context()), "error", err)
+                        return
+                }
+        }
+}
+
+// wsPing keeps the connection alive and lets the read deadline catch dead peers
+func wsPing(conn *websocket.Conn, done <-chan struct{}) {
+        ticker := time.NewTicker(wsPingPeriod)
+        defer ticker.Stop()
+        for {
+                select {
+                case <-done:
+                        return
+                case <-ticker.C:
+                        if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+                                return
+                        }
+                }
+        }
+}
+"""
+
+GO_DEBUGBODY = r"""package main
+
+import (
+        "io"
+        "log/slog"
+        "net/http"
+        "regexp"
+        "strings"
+)
+
+// bodyLogger logs the request bodies of 4xx responses so operators can see what a client
+// actually sent. Bodies can carry personal data, so it only exists with DEBUG_LOG_BODIES.
+type bodyLogger struct {
+        maxBytes int
+        redact   *regexp.Regexp
+}
+
+// newBodyLogger keeps up to maxBytes of each body and blanks the string values of keys
+func newBodyLogger(maxBytes int, keys []string) *bodyLogger {
+        l := &bodyLogger{maxBytes: maxBytes}
+        if len(keys) > 0 {
+                quoted := make([]string, len(keys))
+                for i, k := range keys {
+                        quoted[i] = regexp.QuoteMeta(k)
+                }
+                // A pattern rather than a JSON walk, so malformed bodies, the usual reason to look, are
+                // redacted too; a value cut off by truncation is matched up to the end
+                l.redact = regexp.MustCompile(`(?i)("(?:` + strings.Join(quoted, "|") + `)"\s*:\s*)"(?:[^"\\]|\\.)*(?:"|$)`)
+        }
+        return l
+}
+
+// capWriter keeps the first max bytes written to it and discards the rest
+type capWriter struct {
+        buf       []byte
+        max       int
+        truncated bool
+}
+
+func (c *capWriter) Write(p []byte) (int, error) {
+        if room := c.max - len(c.buf); room > 0 {
+                if len(p) > room {
+                        c.buf = append(c.buf, p[:room]...)
+                        c.truncated = true
+                } else {
+                        c.buf = append(c.buf, p...)
+                }
+        } else if len(p) > 0 {
+                c.truncated = true
+        }
+        return len(p), nil
+}
+
+func (l *bodyLogger) middleware(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                captured := &capWriter{max: l.maxBytes}
+                r.Body = struct {
+                        io.Reader
+                        io.Closer
+                }{io.TeeReader(r.Body, captured), r.Body}
+
+                rw := newResponseWriter(w)
+                next.ServeHTTP(rw, r)
+                if rw.status < 400 || rw.status >= 500 {
+                        return
+                }
+
+                body := string(captured.buf)
+                if l.redact != nil {
+                        body = l.redact.ReplaceAllString(body, `$1"[REDACTED]"`)
+                }
+                slog.Info("rejected request body",
+                        "path", r.URL.Path,
+                        "status", rw.status,
+                        "body", body,
+                        "truncated", captured.truncated,
+                        "request_id", requestIDFromContext(r.Context()))
+        })
+}
+
+// bodiesLogged wraps h with l when DEBUG_LOG_BODIES is set
+func bodiesLogged(l *bodyLogger, h http.Handler) http.Handler {
+        if l == nil {
+                return h
+        }
+        return l.middleware(h)
+}
+"""
+
+GO_FORWARD = r"""package main
+
+import (
+        "bytes"
+        "context"
+        "crypto/sha256"
+        "encoding/hex"
+        "encoding/json"
+        "errors"
+        "fmt"
+        "io"
+        "log/slog"
+        "math"
+        "net"
+        "net/http"
+        "strconv"
+        "strings"
+        "time"
+
+        "github.com/sony/gobreaker"
+        "go.opentelemetry.io/otel"
+        "go.opentelemetry.io/otel/propagation"
+        "golang.org/x/sync/singleflight"
+)
+
+// maxForwardResponseBytes caps how much of a downstream reply /echo/forward will relay
+const maxForwardResponseBytes = 1 << 20
+
+// forwardPropagatedHeaders are copied from the incoming request onto the downstream one so
+// the trace and correlation id continue across the hop. X-Request-ID is set separately.
+var forwardPropagatedHeaders = []string{"traceparent", "tracestate", "baggage"}
+
+// forwarder relays /echo/forward messages to FORWARD_URL over one shared client. With a
+// breaker, repeated downstream failures stop further calls for a while. Identical payloads
+// forwarded at the same time under the same request ID and trace share one downstream call.
+type forwarder struct {
+        url         string
+        client      *http.Client
+        breaker     *gobreaker.CircuitBreaker
+        openTimeout time.Duration
+        inflight    singleflight.Group
+}
+
+// newForwarder relays to cfg.ForwardURL, registering its breaker, if any, in breakers
+func newForwarder(cfg Config, breakers *breakerRegistry) *forwarder {
+        timeout := cfg.ForwardTimeout
+        f := &forwarder{
+                url:         cfg.ForwardURL,
+                openTimeout: cfg.ForwardBreakerOpenTimeout,
+                client: &http.Client{
+                        Timeout: timeout,
+                        Transport: &http.Transport{
+                                Proxy:                 http.ProxyFromEnvironment,
+                                DialContext:           (&net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}).DialContext,
+                                MaxIdleConns:          100,
+                                MaxIdleConnsPerHost:   10,
+                                IdleConnTimeout:       90 * time.Second,
+                                TLSHandshakeTimeout:   timeout,
+                                ResponseHeaderTimeout: timeout,
+                        },
+                },
+        }
+        if cfg.ForwardBreakerFailures > 0 {
+                f.breaker = breakers.newBreaker("forward", uint32(cfg.ForwardBreakerFailures),
+                        cfg.ForwardBreakerOpenTimeout, uint32(cfg.ForwardBreakerHalfOpenRequests))
+        }
+        return f
+}
+
+// forwardRequest is the body sent downstream: the fields another /echo accepts
+type forwardRequest struct {
+        Message  string   `json:"message"`
+        Metadata Metadata `json:"metadata,omitempty"`
+}
+
+// ForwardHop describes this service's leg of a forwarded echo
+type ForwardHop struct {
+        Service    string  `json:"service"`
+        URL        string  `json:"url"`
+        Status     int     `json:"status"`
+        DurationMS float64 `json:"duration_ms"`
+        RequestID  string  `json:"request_id,omitempty"`
+
+        // DownstreamRequestID is the X-Request-ID the downstream answered with
+        DownstreamRequestID string `json:"downstream_request_id,omitempty"`
+}
+
+// ForwardResponse is the body of POST /echo/forward: the downstream JSON untouched, plus the hop
+type ForwardResponse struct {
+        Downstream json.RawMessage `json:"downstream"`
+        Hop        ForwardHop      `json:"hop"`
+}
+
+// handleForward serves POST /echo/forward through s.forwarder. Timeouts, transport errors,
+// non-2xx answers and non-JSON bodies from downstream all become 502 with the reason in the
+// message; while the breaker is open, calls are refused with 503 without reaching downstream.
+func (s *Server) handleForward(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodPost {
+                methodNotAllowed(w, r, http.MethodPost)
+                return
+        }
+
+        f := s.forwarder
+        echo, ok := s.decodeEcho(w, r)
+        if !ok {
+                return
+        }
+
+        start := time.Now()
+        res, err := f.call(r.Context(), forwardRequest{Message: echo.Message, Metadata: echo.Metadata}, r.Header)
+        if breakerRejected(err) {
+                w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(f.openTimeout.Seconds()))))
+                httpError(w, r, http.StatusServiceUnavailable, errCircuitOpen, "downstream is failing; forwarding is paused")
+                return
+        }
+        if err != nil {
+                slog.Warn("forwarding echo failed",
+                        "url", f.url,
+                        "request_id", requestIDFromContext(r.Context()),
+                        "error", err)
+                httpError(w, r, http.StatusBadGateway, errBadGateway, err.Error())
+                return
+        }
+
+        writeJSON(w, r, http.StatusOK, ForwardResponse{
+                Downstream: res.body,
+                Hop: ForwardHop{
+                        Service:             serviceName,
+                        URL:                 f.url,
+                        Status:              res.status,
+                        DurationMS:          float64(time.Since(start).Microseconds()) / 1000,
+                        RequestID:           requestIDFromContext(r.Context()),
+                        DownstreamRequestID: res.requestID,
+                },
+        })
+}
+
+// call forwards payload, joining any identical call already in flight. The shared call runs
+// detached from the caller that started it, so one client hanging up does not fail the
+// others; each waiter still stops waiting when its own context ends. Nothing is kept once
+// the call returns, so a failure is never replayed to later requests.
+func (f *forwarder) call(ctx context.Context, payload forwardRequest, incoming http.Header) (forwardResult, error) {
+        data, err := json.Marshal(payload)
+        if err != nil {
+                return forwardResult{}, err
+        }
+        shared := context.WithoutCancel(ctx)
+
+        ch := f.inflight.DoChan(inflightKey(ctx, data, incoming), func() (any, error) {
+                return f.execute(shared, payload, incoming)
+        })
+        select {
+        case <-ctx.Done():
+                return forwardResult{}, ctx.Err()
+        case res := <-ch:
+                if res.Err != nil {
+                        return forwardResult{}, res.Err
+                }
+                out := res.Val.(forwardResult)
+                // Waiters share the result, so each gets its own copy of the body
+                out.body = bytes.Clone(out.body)
+                return out, nil
+        }
+}
+
+// inflightKey identifies the calls that may share one downstream request. That request
+// carries the first caller's X-Request-ID and trace headers, so besides the payload the key
+// holds the request ID, trace ID and propagated headers: callers that would send anything
+// different downstream each make their own call.
+func inflightKey(ctx context.Context, data []byte, incoming http.Header) string {
+        h := sha256.New()
+        h.Write(data)
+        for _, part := range []string{requestIDFromContext(ctx), traceIDFromContext(ctx)} {
+                h.Write([]byte{0})
+                h.Write([]byte(part))
+        }
+        for _, name := range forwardPropagatedHeaders {
+                h.Write([]byte{0})
+                h.Write([]byte(strings.Join(incoming.Values(name), ",")))
+        }
+        return hex.EncodeToString(h.Sum(nil))
+}
+
+// execute runs post through the breaker, when there is one
+func (f *forwarder) execute(ctx context.Context, payload forwardRequest, incoming http.Header) (forwardResult, error) {
+        if f.breaker == nil {
+                return f.post(ctx, payload, incoming)
+        }
+        res, err := f.breaker.Execute(func() (any, error) {
+                return f.post(ctx, payload, incoming)
+        })
+        if err != nil {
+                return forwardResult{}, err
+        }
+        return res.(forwardResult), nil
+}
+
+// forwardResult is a usable downstream answer
+type forwardResult struct {
+        status    int
+        body      json.RawMessage
+        requestID string
+}
+
+// post sends payload downstream, carrying the propagation headers of incoming, and returns
+// the answer or why it was unusable
+func (f *forwarder) post(ctx context.Context, payload forwardRequest, incoming http.Header) (forwardResult, error) {
+        data, err := json.Marshal(payload)
+        if err != nil {
+                return forwardResult{}, err
+        }
+        req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.url, bytes.NewReader(data))
+        if err != nil {
+                return forwardResult{}, err
+        }
+        req.Header.Set("Content-Type", contentTypeJSON)
+        req.Header.Set("Accept", contentTypeJSON)
+        if requestID := requestIDFromContext(ctx); requestID != "" {
+                req.Header.Set(requestIDHeader, requestID)
+        }
+        for _, name := range forwardPropagatedHeaders {
+                if v := incoming.Values(name); len(v) > 0 {
+                        req.Header[http.CanonicalHeaderKey(name)] = append([]string(nil), v...)
+                }
+        }
+        // With tracing on, our own span becomes the downstream's parent instead of the caller's
+        if tracingEnabled {
+                otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+        }
+
+        resp, err := f.client.Do(req)
+        if err != nil {
+                var netErr net.Error
+                if errors.As(err, &netErr) && netErr.Timeout() {
+                        return forwardResult{}, fmt.Errorf("downstream timed out after %s", f.client.Timeout)
+                }
+                return forwardResult{}, fmt.Errorf("downstream unreachable: %w", err)
+        }
+        defer resp.Body.Close()
+
+        body, err := io.ReadAll(io.LimitReader(resp.Body, maxForwardResponseBytes+1))
+        if err != nil {
+                return forwardResult{}, fmt.Errorf("reading downstream response: %w", err)
+        }
+        if resp.StatusCode < 200 || resp.StatusCode > 299 {
+                return forwardResult{}, fmt.Errorf("downstream returned HTTP %d", resp.StatusCode)
+        }
+        if len(body) > maxForwardResponseBytes {
+                return forwardResult{}, fmt.Errorf("downstream response exceeds %d bytes", maxForwardResponseBytes)
+        }
+        if !json.Valid(body) {
+                return forwardResult{}, errors.New("downstream response is not JSON")
+        }
+        return forwardResult{status: resp.StatusCode, body: body, requestID: resp.Header.Get(requestIDHeader)}, nil
+}
+"""
+
+GO_UNIX = r"""package main
+
+import (
+        "errors"
+        "fmt"
+        "io/fs"
+        "net"
+        "os"
+)
+
+// listenUnix listens on a Unix domain socket at path with the given file mode. A stale
+// socket left by a crashed process is removed first; any other file there is an error.
+// Closing the listener, which Shutdown does, unlinks the socket file.
+func listenUnix(path string, mode fs.FileMode) (net.Listener, error) {
+        if info, err := os.Lstat(path); err == nil {
+                if info.Mode().Type() != fs.ModeSocket {
+                        return nil, fmt.Errorf("%s exists and is not a socket", path)
+                }
+                if err := os.Remove(path); err != nil {
+                        return nil, fmt.Errorf("removing stale socket: %w", err)
+                }
+        } else if !errors.Is(err, fs.ErrNotExist) {
+                return nil, err
+        }
+
+        ln, err := net.Listen("unix", path)
+        if err != nil {
+                return nil, err
+        }
+        if err := os.Chmod(path, mode); err != nil {
+                ln.Close()
+                return nil, fmt.Errorf("setting socket permissions: %w", err)
+        }
+        return ln, nil
+}
+"""
+
+GO_SCHEMA = r"""package main
+
+import (
+        "bytes"
+        _ "embed"
+        "encoding/json"
+        "errors"
+        "fmt"
+
+        "github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// echoSchemaJSON is the JSON Schema every /echo and /echo/batch message must satisfy.
+// Limits that come from configuration, such as MAX_MESSAGE_LEN, stay in Echo.Validate.
+//
+//go:embed echo.schema.json
+var echoSchemaJSON string
+
+var echoSchema = jsonschema.MustCompileString("echo.schema.json", echoSchemaJSON)
+
+// schemaValidation turns echo schema checks on; ECHO_SCHEMA_VALIDATION=false skips them
+var schemaValidation = true
+
+// rejectDuplicateKeys makes a repeated top-level key a 400 instead of last-one-wins;
+// REJECT_DUPLICATE_KEYS=true turns it on
+var rejectDuplicateKeys = false
+
+// duplicateKey returns the first key that appears twice in raw's top-level object, or ""
+// if there is none. Malformed input also yields "" so the regular decode reports it.
+func duplicateKey(raw []byte) string {
+        dec := json.NewDecoder(bytes.NewReader(raw))
+        if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+                return ""
+        }
+        seen := make(map[string]bool)
+        for dec.More() {
+                tok, err := dec.Token()
+                if err != nil {
+                        return ""
+                }
+                key, ok := tok.(string)
+                if !ok {
+                        return ""
+                }
+                if seen[key] {
+                        return key
+                }
+                seen[key] = true
+                // Nested objects are skipped whole; only the top level is checked
+                var value json.RawMessage
+                if err := dec.Decode(&value); err != nil {
+                        return ""
+                }
+        }
+        return ""
+}
+
+// validateEchoSchema checks raw against echoSchema. It returns the violations, one per
+// failing keyword as "<instance location>: <message>", or an error if raw is not JSON.
+func validateEchoSchema(raw []byte, preserveNumbers bool) ([]string, error) {
+        var doc any
+        if preserveNumbers {
+                dec := json.NewDecoder(bytes.NewReader(raw))
+                dec.UseNumber()
+                if err := dec.Decode(&doc); err != nil {
+                        return nil, err
+                }
+                metadataNumbersAsText(doc)
+        } else if err := json.Unmarshal(raw, &doc); err != nil {
+                return nil, err
+        }
+
+        err := echoSchema.Validate(doc)
+        var ve *jsonschema.ValidationError
+        if !errors.As(err, &ve) {
+                return nil, err
+        }
+        var violations []string
+        collectViolations(ve, &violations)
+        return violations, nil
+}
+
+// metadataNumbersAsText turns numeric metadata values in doc into their literal text, so
+// the schema, which types metadata values as strings, accepts them under PRESERVE_NUMBERS
+func metadataNumbersAsText(doc any) {
+        obj, _ := doc.(map[string]any)
+        meta, _ := obj["metadata"].(map[string]any)
+        for k, v := range meta {
+                if num, ok := v.(json.Number); ok {
+                        meta[k] = num.String()
+                }
+        }
+}
+
+// collectViolations flattens the validation tree to its leaves, which name the actual failures
+func collectViolations(ve *jsonschema.ValidationError, out *[]string) {
+        if len(ve.Causes) == 0 {
+                loc := ve.InstanceLocation
+                if loc == "" {
+                        loc = "/"
+                }
+                *out = append(*out, fmt.Sprintf("%s: %s", loc, ve.Message))
+                return
+        }
+        for _, cause := range ve.Causes {
+                collectViolations(cause, out)
+        }
+}
+"""
+
+ECHO_SCHEMA_JSON = r"""{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "title": "EchoRequest",
+  "type": "object",
+  "required": ["message"],
+  "additionalProperties": false,
+  "properties": {
+    "message": {
+      "type": "string",
+      "minLength": 1,
+      "pattern": "\\S"
+    },
+    "metadata": {
+      "type": "object",
+      "additionalProperties": { "type": "string" }
+    }
+  }
+}
+"""
+
+GO_CHAOS = r"""package main
+
+import (
+        "log/slog"
+        "math/rand"
+        "net/http"
+        "sync"
+        "time"
+)
+
+// chaosInjector delays and fails a fraction of requests so clients can rehearse slow and
+// flaky dependencies. It only exists when a CHAOS_ setting is non-zero.
+type chaosInjector struct {
+        delay       time.Duration
+        jitter      time.Duration
+        delayRate   float64
+        errorRate   float64
+        errorStatus int
+
+        // rng is seeded from CHAOS_SEED so a run can be reproduced
+        mu  sync.Mutex
+        rng *rand.Rand
+}
+
+func newChaosInjector(cfg Config) *chaosInjector {
+        return &chaosInjector{
+                delay:       cfg.ChaosDelay,
+                jitter:      cfg.ChaosJitter,
+                delayRate:   cfg.ChaosDelayRate,
+                errorRate:   cfg.ChaosErrorRate,
+                errorStatus: cfg.ChaosErrorStatus,
+                rng:         rand.New(rand.NewSource(cfg.ChaosSeed)),
+        }
+}
+
+// roll decides one request's fate: how long to stall and whether to fail
+func (c *chaosInjector) roll() (time.Duration, bool) {
+        c.mu.Lock()
+        defer c.mu.Unlock()
+
+        var wait time.Duration
+        if c.delay > 0 || c.jitter > 0 {
+                if c.rng.Float64() < c.delayRate {
+                        wait = c.delay
+                        if c.jitter > 0 {
+                                wait += time.Duration(c.rng.Int63n(int64(c.jitter) + 1))
+                        }
+                }
+        }
+        fail := c.rng.Float64() < c.errorRate
+        return wait, fail
+}
+
+func (c *chaosInjector) middleware(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                wait, fail := c.roll()
+                if wait > 0 {
+                        timer := time.NewTimer(wait)
+                        select {
+                        case <-timer.C:
+                        case <-r.Context().Done():
+                                timer.Stop()
+                                return
+                        }
+                }
+                if fail {
+                        slog.Debug("chaos: injecting failure", "path", r.URL.Path, "status", c.errorStatus)
+                        httpError(w, r, c.errorStatus, errChaosInjected, "failure injected for chaos testing")
+                        return
+                }
+                next.ServeHTTP(w, r)
+        })
+}
+
+// chaotic wraps h with c when chaos injection is configured
+func chaotic(c *chaosInjector, h http.Handler) http.Handler {
+        if c == nil {
+                return h
+        }
+        return c.middleware(h)
+}
+"""
+
+GO_FORM = r"""package main
+
+import (
+        "errors"
+        "fmt"
+        "mime"
+        "net/http"
+)
+
+// handleEchoForm is POST /echo/form: the /echo round trip for clients posting
+// multipart/form-data or application/x-www-form-urlencoded with a message field
+func (s *Server) handleEchoForm(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodPost {
+                methodNotAllowed(w, r, http.MethodPost)
+                return
+        }
+
+        contentType, ok := negotiateContentType(r)
+        if !ok {
+                notAcceptable(w, r)
+                return
+        }
+
+        echo, ok := s.decodeEchoForm(w, r)
+        if !ok || clientGone(w, r, "decoded") {
+                return
+        }
+
+        s.stampEcho(&echo, r)
+        if !s.saveEcho(w, r, echo) {
+                return
+        }
+
+        if clientGone(w, r, "encoding") {
+                return
+        }
+        writeBody(w, r, http.StatusOK, contentType, echo)
+}
+
+// decodeEchoForm parses the form body into an Echo and validates it like decodeEcho.
+// On failure it has already written the error response and returns false.
+func (s *Server) decodeEchoForm(w http.ResponseWriter, r *http.Request) (Echo, bool) {
+        var echo Echo
+        mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+        var err error
+        switch mediaType {
+        case "multipart/form-data":
+                // The body is already capped by bodyLimitMiddleware, so keeping that much in
+                // memory never spills uploads to disk
+                err = r.ParseMultipartForm(bodyLimitFor(r.URL.Path))
+                if r.MultipartForm != nil {
+                        defer r.MultipartForm.RemoveAll()
+                }
+        case "application/x-www-form-urlencoded":
+                err = r.ParseForm()
+        default:
+                httpError(w, r, http.StatusUnsupportedMediaType, errUnsupportedMediaType,
+                        "Content-Type must be multipart/form-data or application/x-www-form-urlencoded")
+                return echo, false
+        }
+        if err != nil {
+                var maxErr *http.MaxBytesError
+                if errors.As(err, &maxErr) {
+                        httpError(w, r, http.StatusRequestEntityTooLarge, errBodyTooLarge, fmt.Sprintf("Request body exceeds %d bytes", maxErr.Limit))
+                        return echo, false
+                }
+                httpError(w, r, http.StatusBadRequest, errInvalidForm, fmt.Sprintf("Invalid form: %v", err))
+                return echo, false
+        }
+
+        if !r.PostForm.Has("message") {
+                httpError(w, r, http.StatusBadRequest, errValidation, "message field is required")
+                return echo, false
+        }
+        echo.Message = r.PostForm.Get("message")
+
+        if err := s.validateEcho(echo); err != nil {
+                httpError(w, r, http.StatusBadRequest, errValidation, err.Error())
+                return echo, false
+        }
+        return echo, s.applyTransform(w, r, &echo)
+}
+"""
+
+GO_DEPENDENCIES = r"""package main
+
+import (
+        "context"
+        "log/slog"
+        "slices"
+        "sort"
+        "sync"
+        "time"
+)
+
+// Dependency health states reported by /health/detailed
+const (
+        dependencyUnknown = "unknown"
+        dependencyUp      = "up"
+        dependencyDown    = "down"
+)
+
+// DependencyStatus is one downstream service's last known state in /health/detailed
+type DependencyStatus struct {
+        Name        string     `json:"name"`
+        Required    bool       `json:"required"`
+        Status      string     `json:"status"`
+        LastChecked *time.Time `json:"last_checked,omitempty"`
+        LatencyMS   float64    `json:"latency_ms,omitempty"`
+        Error       string     `json:"error,omitempty"`
+}
+
+type dependencyTarget struct {
+        name     string
+        url      string
+        required bool
+}
+
+// dependencyMonitor polls the DEPENDENCIES health URLs in the background and keeps the
+// latest result per dependency, so /health/detailed reports them without waiting on any.
+// A required dependency being down makes the service unhealthy; any other only degrades it.
+type dependencyMonitor struct {
+        targets  []dependencyTarget
+        interval time.Duration
+        timeout  time.Duration
+
+        mu      sync.RWMutex
+        results map[string]DependencyStatus
+}
+
+// dependencies is nil unless DEPENDENCIES is set
+var dependencies *dependencyMonitor
+
+// newDependencyMonitor watches urls, keyed by dependency name; they are reported in name order
+func newDependencyMonitor(urls map[string]string, required []string, interval, timeout time.Duration) *dependencyMonitor {
+        m := &dependencyMonitor{
+                interval: interval,
+                timeout:  timeout,
+                results:  make(map[string]DependencyStatus, len(urls)),
+        }
+        for name, url := range urls {
+                m.targets = append(m.targets, dependencyTarget{name: name, url: url, required: slices.Contains(required, name)})
+        }
+        sort.Slice(m.targets, func(i, j int) bool { return m.targets[i].name < m.targets[j].name })
+        return m
+}
+
+// run checks every dependency immediately and then once per interval until ctx ends
+func (m *dependencyMonitor) run(ctx context.Context) {
+        ticker := time.NewTicker(m.interval)
+        defer ticker.Stop()
+
+        for {
+                m.checkAll(ctx)
+                select {
+                case <-ctx.Done():
+                        return
+                case <-ticker.C:
+                }
+        }
+}
+
+// checkAll pings the dependencies concurrently, each bounded by m.timeout
+func (m *dependencyMonitor) checkAll(ctx context.Context) {
+        var wg sync.WaitGroup
+        for _, t := range m.targets {
+                wg.Add(1)
+                go func(t dependencyTarget) {
+                        defer wg.Done()
+                        m.record(t.name, m.check(ctx, t))
+                }(t)
+        }
+        wg.Wait()
+}
+
+func (m *dependencyMonitor) check(ctx context.Context, t dependencyTarget) DependencyStatus {
+        checkCtx, cancel := context.WithTimeout(ctx, m.timeout)
+        defer cancel()
+
+        start := time.Now()
+        err := pingURL(checkCtx, t.url)
+        checked := time.Now()
+        status := DependencyStatus{
+                Name:        t.name,
+                Required:    t.required,
+                Status:      dependencyUp,
+                LastChecked: &checked,
+                LatencyMS:   float64(checked.Sub(start).Microseconds()) / 1000,
+        }
+        if err != nil {
+                status.Status = dependencyDown
+                status.Error = err.Error()
+        }
+        return status
+}
+
+// record stores status, logging when a dependency changes state
+func (m *dependencyMonitor) record(name string, status DependencyStatus) {
+        m.mu.Lock()
+        prev, seen := m.results[name]
+        m.results[name] = status
+        m.mu.Unlock()
+
+        if seen && prev.Status == status.Status {
+                return
+        }
+        if status.Status == dependencyDown {
+                slog.Warn("dependency down", "dependency", name, "error", status.Error)
+        } else if seen {
+                slog.Info("dependency recovered", "dependency", name)
+        }
+}
+
+// snapshot returns the latest status of every dependency; ones not yet checked are unknown
+func (m *dependencyMonitor) snapshot() []DependencyStatus {
+        m.mu.RLock()
+        defer m.mu.RUnlock()
+
+        out := make([]DependencyStatus, len(m.targets))
+        for i, t := range m.targets {
+                status, ok := m.results[t.name]
+                if !ok {
+                        status = DependencyStatus{Name: t.name, Required: t.required, Status: dependencyUnknown}
+                }
+                out[i] = status
+        }
+        return out
+}
+
+// dependenciesDown reports whether any dependency, and any required one, failed its last check
+func dependenciesDown(statuses []DependencyStatus) (down, requiredDown bool) {
+        for _, s := range statuses {
+                if s.Status == dependencyDown {
+                        down = true
+                        requiredDown = requiredDown || s.Required
+                }
+        }
+        return down, requiredDown
+}
+"""
+
+GO_UI = r"""//go:build ui
+
+package main
+
+import (
+        "bytes"
+        _ "embed"
+        "html/template"
+        "log/slog"
+        "mime"
+        "net/http"
+        "strconv"
+        "strings"
+)
+
+const contentTypeHTML = "text/html; charset=utf-8"
+
+// indexHTML is the landing page served at / to browsers when ENABLE_UI is set
+//
+//go:embed index.html
+var indexHTML string
+
+var indexTemplate = template.Must(template.New("index.html").Parse(indexHTML))
+
+func init() {
+        builtFeatures["ui"] = uiFeature{}
+}
+
+// uiFeature answers browsers at / with the landing page
+type uiFeature struct{}
+
+func (uiFeature) routes(s *Server, _ *http.ServeMux) []string {
+        s.landingPage = s.serveLandingPage
+        return nil
+}
+
+func (uiFeature) adminRoutes(*Server, *http.ServeMux) {}
+
+func (uiFeature) shutdown() {}
+
+// serveLandingPage renders the landing page for clients that prefer HTML and reports whether
+// it did; the JSON banner answers everyone else
+func (s *Server) serveLandingPage(w http.ResponseWriter, r *http.Request) bool {
+        w.Header().Add("Vary", "Accept")
+        if !prefersHTML(r) {
+                return false
+        }
+        s.serveUI(w, r)
+        return true
+}
+
+// uiRoute is one row of the landing page's endpoint table
+type uiRoute struct {
+        Method string
+        Path   string
+}
+
+// uiPage is the data indexTemplate renders
+type uiPage struct {
+        Service  string
+        Version  string
+        BasePath string
+        Routes   []uiRoute
+}
+
+// prefersHTML reports whether the Accept header ranks text/html at least as high as JSON.
+// Only explicit types count, so API clients sending */* or nothing keep getting JSON.
+func prefersHTML(r *http.Request) bool {
+        htmlQ, jsonQ := 0.0, 0.0
+        for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+                mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+                if err != nil {
+                        continue
+                }
+                q := 1.0
+                if v, found := params["q"]; found {
+                        if f, err := strconv.ParseFloat(v, 64); err == nil {
+                                q = f
+                        }
+                }
+                switch mediaType {
+                case "text/html", "application/xhtml+xml":
+                        htmlQ = max(htmlQ, q)
+                case "application/json":
+                        jsonQ = max(jsonQ, q)
+                }
+        }
+        return htmlQ > 0 && htmlQ >= jsonQ
+}
+
+// serveUI renders the landing page. HEAD gets the headers alone.
+func (s *Server) serveUI(w http.ResponseWriter, r *http.Request) {
+        page := uiPage{Service: serviceName, Version: version, BasePath: basePath}
+        for _, e := range s.endpoints {
+                method, path, _ := strings.Cut(e, " ")
+                page.Routes = append(page.Routes, uiRoute{Method: method, Path: basePath + path})
+        }
+
+        var buf bytes.Buffer
+        if err := indexTemplate.Execute(&buf, page); err != nil {
+                slog.Error("rendering landing page failed", "error", err)
+                httpError(w, r, http.StatusInternalServerError, errInternal, "internal server error")
+                return
+        }
+
+        writeBytes(w, r, http.StatusOK, contentTypeHTML, buf.Bytes())
+}
+"""
+
+INDEX_HTML = r"""<!doctype html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>{{.Service}}</title>
+  <style>
+    body { font-family: system-ui, sans-serif; max-width: 46rem; margin: 2rem auto; padding: 0 1rem; color: #222; }
+    h1 { margin-bottom: 0; }
+    .version { color: #666; margin-top: .25rem; }
+    table { border-collapse: collapse; width: 100%; }
+    td { padding: .25rem .5rem; border-bottom: 1px solid #eee; font-family: ui-monospace, monospace; }
+    td.method { width: 5rem; font-weight: bold; }
+    form { display: flex; gap: .5rem; }
+    input { flex: 1; padding: .4rem; }
+    pre { background: #f6f8fa; padding: 1rem; overflow-x: auto; min-height: 1.5rem; }
+  </style>
+</head>
+<body>
+  <h1>{{.Service}}</h1>
+  <p class="version">version {{.Version}}</p>
+
+  <h2>Try /echo</h2>
+  <form id="echo">
+    <input name="message" placeholder="Message to echo" required>
+    <button type="submit">Send</button>
+  </form>
+  <pre id="result"></pre>
+
+  <h2>Endpoints</h2>
+  <table>
+    {{- range .Routes}}
+    <tr><td class="method">{{.Method}}</td><td>{{.Path}}</td></tr>
+    {{- end}}
+  </table>
+
+  <script>
+    const echoURL = {{.BasePath}} + "/echo";
+    document.getElementById("echo").addEventListener("submit", async (event) => {
+      event.preventDefault();
+      const result = document.getElementById("result");
+      const message = new FormData(event.target).get("message");
+      try {
+        const resp = await fetch(echoURL, {
+          method: "POST",
+          headers: { "Content-Type": "application/json", "Accept": "application/json" },
+          body: JSON.stringify({ message }),
+        });
+        const body = await resp.json();
+        result.textContent = resp.status + " " + resp.statusText + "\n" + JSON.stringify(body, null, 2);
+      } catch (err) {
+        result.textContent = "request failed: " + err;
+      }
+    });
+  </script>
+</body>
+</html>
+"""
+
+GO_ACCESSLOG = r"""package main
+
+import (
+        "bufio"
+        "log/slog"
+        "os"
+        "sync"
+        "time"
+)
+
+// accessLogFlushInterval bounds how long an access line can sit in the buffer
+const accessLogFlushInterval = time.Second
+
+// accessLogBufferBytes is the write buffer in front of the access log file
+const accessLogBufferBytes = 64 << 10
+
+// accessLogs is nil unless ACCESS_LOG_FILE is set, in which case per-request lines go
+// there as JSON while application logs stay on stdout
+var accessLogs *accessLogFile
+
+// accessLogFile is a buffered, append-only sink for access lines. The file is opened with
+// O_APPEND so copytruncate rotation is safe, and reopen picks up a fresh file after a
+// rename-style rotation.
+type accessLogFile struct {
+        path   string
+        logger *slog.Logger
+
+        mu  sync.Mutex
+        f   *os.File
+        buf *bufio.Writer
+}
+
+func openAccessLog(path string) (*accessLogFile, error) {
+        f, err := openAppend(path)
+        if err != nil {
+                return nil, err
+        }
+        a := &accessLogFile{path: path, f: f, buf: bufio.NewWriterSize(f, accessLogBufferBytes)}
+        a.logger = slog.New(slog.NewJSONHandler(a, nil)).With("service", serviceName)
+        return a, nil
+}
+
+func openAppend(path string) (*os.File, error) {
+        return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+}
+
+// Write buffers p; slog hands each record over in a single call, so lines never interleave
+func (a *accessLogFile) Write(p []byte) (int, error) {
+        a.mu.Lock()
+        defer a.mu.Unlock()
+        return a.buf.Write(p)
+}
+
+func (a *accessLogFile) flush() error {
+        a.mu.Lock()
+        defer a.mu.Unlock()
+        return a.buf.Flush()
+}
+
+// flushLoop writes buffered lines out every interval until done is closed
+func (a *accessLogFile) flushLoop(done <-chan struct{}, interval time.Duration) {
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+        for {
+                select {
+                case <-done:
+                        return
+                case <-ticker.C:
+                        if err := a.flush(); err != nil {
+                                slog.Warn("flushing access log failed", "path", a.path, "error", err)
+                        }
+                }
+        }
+}
+
+// reopen flushes and switches to a newly opened file at the same path
+func (a *accessLogFile) reopen() error {
+        f, err := openAppend(a.path)
+        if err != nil {
+                return err
+        }
+        a.mu.Lock()
+        defer a.mu.Unlock()
+        flushErr := a.buf.Flush()
+        a.f.Close()
+        a.f = f
+        a.buf.Reset(f)
+        return flushErr
+}
+
+// close flushes any buffered lines and closes the file
+func (a *accessLogFile) close() error {
+        a.mu.Lock()
+        defer a.mu.Unlock()
+        if err := a.buf.Flush(); err != nil {
+                a.f.Close()
+                return err
+        }
+        return a.f.Close()
+}
+
+// accessLogger is where loggingMiddleware writes: the access log file when configured,
+// otherwise the default logger
+func accessLogger() *slog.Logger {
+        if accessLogs != nil {
+                return accessLogs.logger
+        }
+        return slog.Default()
+}
+"""
+
+GO_TRANSFORM = r"""package main
+
+import (
+        "container/list"
+        "fmt"
+        "strings"
+        "sync"
+        "time"
+)
+
+// Transform rewrites an echoed message
+type Transform func(string) string
+
+// maxTransformSteps bounds a ?transform= chain so one request cannot queue unbounded work
+const maxTransformSteps = 16
+
+// transformRegistry holds the named transforms ?transform= may chain. Names are kept in
+// registration order so listings are stable.
+type transformRegistry struct {
+        names  []string
+        byName map[string]Transform
+}
+
+func newTransformRegistry() *transformRegistry {
+        return &transformRegistry{byName: make(map[string]Transform)}
+}
+
+// register adds fn under name; registering a name twice is a programming error
+func (t *transformRegistry) register(name string, fn Transform) {
+        if _, dup := t.byName[name]; dup {
+                panic(fmt.Sprintf("transform %q registered twice", name))
+        }
+        t.names = append(t.names, name)
+        t.byName[name] = fn
+}
+
+// Names lists the registered transforms in registration order
+func (t *transformRegistry) Names() []string {
+        return append([]string(nil), t.names...)
+}
+
+// pipeline resolves a comma-separated chain such as "upper,reverse" into one Transform
+// applying the steps left to right. An empty spec is the identity. The first unknown or
+// empty step is reported as an error.
+func (t *transformRegistry) pipeline(spec string) (Transform, error) {
+        if strings.TrimSpace(spec) == "" {
+                return func(s string) string { return s }, nil
+        }
+
+        parts := strings.Split(spec, ",")
+        if len(parts) > maxTransformSteps {
+                return nil, fmt.Errorf("transform chain has %d steps, at most %d allowed", len(parts), maxTransformSteps)
+        }
+        steps := make([]Transform, len(parts))
+        for i, part := range parts {
+                name := strings.TrimSpace(part)
+                if name == "" {
+                        return nil, fmt.Errorf("transform step %d is empty", i+1)
+                }
+                fn, ok := t.byName[name]
+                if !ok {
+                        return nil, fmt.Errorf("unknown transform %q at step %d; available: %s", name, i+1, strings.Join(t.Names(), ", "))
+                }
+                steps[i] = fn
+        }
+        return func(s string) string {
+                for _, step := range steps {
+                        s = step(s)
+                }
+                return s
+        }, nil
+}
+
+// transforms are the message rewrites selectable with /echo?transform=<name>[,<name>...]
+var transforms = newTransformRegistry()
+
+func init() {
+        transforms.register("upper", strings.ToUpper)
+        transforms.register("lower", strings.ToLower)
+        transforms.register("reverse", reverseString)
+}
+
+// reverseString reverses s rune by rune so multi-byte characters stay intact
+func reverseString(s string) string {
+        runes := []rune(s)
+        for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+                runes[i], runes[j] = runes[j], runes[i]
+        }
+        return string(runes)
+}
+
+// TransformCacheStats is the transform cache's share of /stats
+type TransformCacheStats struct {
+        Entries int    `json:"entries"`
+        Hits    uint64 `json:"hits"`
+        Misses  uint64 `json:"misses"`
+}
+
+type transformCacheEntry struct {
+        key     string
+        result  string
+        expires time.Time
+}
+
+// transformCache remembers the output of a ?transform= chain for a message, so a burst of
+// identical requests runs the chain once. Transforms are pure, so entries only go stale
+// when the registry or configuration changes; the TTL bounds how long one can outlive that.
+// Past size entries the least recently used one is evicted. A nil *transformCache never
+// hits.
+type transformCache struct {
+        mu      sync.Mutex
+        size    int
+        ttl     time.Duration
+        order   *list.List // front is most recently used
+        entries map[string]*list.Element
+        hits    uint64
+        misses  uint64
+}
+
+// newTransformCache returns a cache of up to size results, or nil when size or ttl is zero
+func newTransformCache(size int, ttl time.Duration) *transformCache {
+        if size <= 0 || ttl <= 0 {
+                return nil
+        }
+        return &transformCache{size: size, ttl: ttl, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// transformCacheKey separates results by chain and message. Only resolved chains are cached,
+// and no transform name contains NUL.
+func transformCacheKey(spec, message string) string {
+        return spec + "\x00" + message
+}
+
+func (c *transformCache) get(spec, message string, now time.Time) (string, bool) {
+        if c == nil {
+                return "", false
+        }
+        c.mu.Lock()
+        defer c.mu.Unlock()
+        el, ok := c.entries[transformCacheKey(spec, message)]
+        if ok && now.After(el.Value.(*transformCacheEntry).expires) {
+                c.order.Remove(el)
+                delete(c.entries, el.Value.(*transformCacheEntry).key)
+                ok = false
+        }
+        if !ok {
+                c.misses++
+                return "", false
+        }
+        c.hits++
+        c.order.MoveToFront(el)
+        return el.Value.(*transformCacheEntry).result, true
+}
+
+func (c *transformCache) put(spec, message, result string, now time.Time) {
+        if c == nil {
+                return
+        }
+        key := transformCacheKey(spec, message)
+        c.mu.Lock()
+        defer c.mu.Unlock()
+        if el, ok := c.entries[key]; ok {
+                e := el.Value.(*transformCacheEntry)
+                e.result, e.expires = result, now.Add(c.ttl)
+                c.order.MoveToFront(el)
+                return
+        }
+        c.entries[key] = c.order.PushFront(&transformCacheEntry{key: key, result: result, expires: now.Add(c.ttl)})
+        if c.order.Len() > c.size {
+                oldest := c.order.Back()
+                c.order.Remove(oldest)
+                delete(c.entries, oldest.Value.(*transformCacheEntry).key)
+        }
+}
+
+// purge drops every entry; the hit and miss counts are kept
+func (c *transformCache) purge() {
+        if c == nil {
+                return
+        }
+        c.mu.Lock()
+        defer c.mu.Unlock()
+        c.order.Init()
+        clear(c.entries)
+}
+
+// snapshot reports the cache for /stats, or nil when caching is off
+func (c *transformCache) snapshot() *TransformCacheStats {
+        if c == nil {
+                return nil
+        }
+        c.mu.Lock()
+        defer c.mu.Unlock()
+        return &TransformCacheStats{Entries: c.order.Len(), Hits: c.hits, Misses: c.misses}
+}
+"""
+
+GO_NDJSON = r"""package main
+
+import (
+        "bufio"
+        "bytes"
+        "encoding/json"
+        "errors"
+        "fmt"
+        "log/slog"
+        "mime"
+        "net/http"
+)
+
+const contentTypeNDJSON = "application/x-ndjson"
+
+// NDJSON batch limits; set from NDJSON_MAX_LINES and NDJSON_MAX_LINE_BYTES
+var (
+        ndjsonMaxLines     = 10000
+        ndjsonMaxLineBytes = 64 << 10
+)
+
+// isNDJSON reports whether the request body is newline-delimited JSON
+func isNDJSON(r *http.Request) bool {
+        mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+        return err == nil && mediaType == contentTypeNDJSON
+}
+
+// echoBatchNDJSON serves /echo/batch for Content-Type: application/x-ndjson. Lines are read
+// and echoed one at a time, each result flushed as its own NDJSON line, so memory stays
+// bounded by a single line however long the batch. A line that fails validation gets an
+// ErrorResponse line and the batch carries on; an oversized line, too many lines or a broken
+// body ends it with a final ErrorResponse line. Blank lines are skipped.
+func (s *Server) echoBatchNDJSON(w http.ResponseWriter, r *http.Request) {
+        scanner := bufio.NewScanner(r.Body)
+        scanner.Buffer(make([]byte, 0, min(4096, ndjsonMaxLineBytes)), ndjsonMaxLineBytes)
+
+        rc := http.NewResponseController(w)
+        enc := json.NewEncoder(w)
+        started := false
+        // emit writes one result line, sending the 200 and NDJSON headers before the first
+        emit := func(v any) bool {
+                if !started {
+                        w.Header().Set("Content-Type", contentTypeNDJSON)
+                        w.Header().Set("X-Content-Type-Options", "nosniff")
+                        w.WriteHeader(http.StatusOK)
+                        started = true
+                }
+                if err := enc.Encode(v); err != nil {
+                        return false
+                }
+                rc.Flush()
+                return true
+        }
+        lineError := func(line int, code, message string, details []string) ErrorResponse {
+                return ErrorResponse{
+                        Code:      code,
+                        Message:   fmt.Sprintf("line %d: %s", line, message),
+                        Details:   details,
+                        RequestID: requestIDFromContext(r.Context()),
+                }
+        }
+
+        line, lines := 0, 0
+        for scanner.Scan() {
+                line++
+                raw := bytes.TrimSpace(scanner.Bytes())
+                if len(raw) == 0 {
+                        continue
+                }
+                if lines++; lines > ndjsonMaxLines {
+                        emit(lineError(line, errInvalidBatch, fmt.Sprintf("batch exceeds %d lines", ndjsonMaxLines), nil))
+                        return
+                }
+                if r.Context().Err() != nil {
+                        slog.Debug("ndjson batch canceled", "request_id", requestIDFromContext(r.Context()), "lines", lines-1)
+                        return
+                }
+
+                echo, itemErr := s.decodeBatchItem(raw)
+                if itemErr != nil {
+                        if !emit(lineError(line, itemErr.code, itemErr.message, itemErr.details)) {
+                                return
+                        }
+                        continue
+                }
+                s.stampEcho(&echo, r)
+                if err := s.storeEcho(r.Context(), echo); err != nil {
+                        if !emit(lineError(line, errInternal, "storing message failed", nil)) {
+                                return
+                        }
+                        continue
+                }
+                if !emit(echo) {
+                        return
+                }
+        }
+
+        if err := scanner.Err(); err != nil {
+                var maxErr *http.MaxBytesError
+                switch {
+                case errors.Is(err, bufio.ErrTooLong):
+                        emit(lineError(line+1, errBodyTooLarge, fmt.Sprintf("line exceeds %d bytes", ndjsonMaxLineBytes), nil))
+                case errors.As(err, &maxErr):
+                        emit(lineError(line+1, errBodyTooLarge, fmt.Sprintf("Request body exceeds %d bytes", maxErr.Limit), nil))
+                default:
+                        emit(lineError(line+1, errInvalidJSON, fmt.Sprintf("reading request body: %v", err), nil))
+                }
+                return
+        }
+        if !started {
+                httpError(w, r, http.StatusBadRequest, errEmptyBody, "request body is empty")
+        }
+}
+"""
+
+GO_BREAKER = r"""package main
+
+import (
+        "context"
+        "errors"
+        "log/slog"
+        "sync"
+        "time"
+
+        "github.com/sony/gobreaker"
+)
+
+// BreakerState is one circuit breaker's entry in /stats
+type BreakerState struct {
+        Name                string `json:"name"`
+        State               string `json:"state"`
+        Requests            uint32 `json:"requests"`
+        ConsecutiveFailures uint32 `json:"consecutive_failures"`
+        TotalFailures       uint32 `json:"total_failures"`
+}
+
+// breakerRegistry lists the circuit breakers a Server's /stats reports on
+type breakerRegistry struct {
+        mu       sync.Mutex
+        breakers []*gobreaker.CircuitBreaker
+}
+
+// newBreaker adds a circuit breaker to b. It opens after failures consecutive failed calls, rejects calls for
+// openTimeout, then lets halfOpenRequests probes through; a successful probe closes it again.
+// Calls abandoned by their caller do not count as downstream failures.
+func (b *breakerRegistry) newBreaker(name string, failures uint32, openTimeout time.Duration, halfOpenRequests uint32) *gobreaker.CircuitBreaker {
+        cb := gobreaker.NewCircuitBreaker(gobreaker.Settings{
+                Name:        name,
+                MaxRequests: halfOpenRequests,
+                Timeout:     openTimeout,
+                ReadyToTrip: func(counts gobreaker.Counts) bool {
+                        return counts.ConsecutiveFailures >= failures
+                },
+                IsSuccessful: func(err error) bool {
+                        return err == nil || errors.Is(err, context.Canceled)
+                },
+                OnStateChange: func(name string, from, to gobreaker.State) {
+                        slog.Warn("circuit breaker state changed", "breaker", name, "from", from.String(), "to", to.String())
+                },
+        })
+
+        b.mu.Lock()
+        defer b.mu.Unlock()
+        b.breakers = append(b.breakers, cb)
+        return cb
+}
+
+// snapshot reports every registered breaker in registration order
+func (b *breakerRegistry) snapshot() []BreakerState {
+        b.mu.Lock()
+        defer b.mu.Unlock()
+
+        out := make([]BreakerState, len(b.breakers))
+        for i, cb := range b.breakers {
+                counts := cb.Counts()
+                out[i] = BreakerState{
+                        Name:                cb.Name(),
+                        State:               cb.State().String(),
+                        Requests:            counts.Requests,
+                        ConsecutiveFailures: counts.ConsecutiveFailures,
+                        TotalFailures:       counts.TotalFailures,
+                }
+        }
+        return out
+}
+
+// breakerRejected reports whether err is a breaker refusing the call rather than the call failing
+func breakerRejected(err error) bool {
+        return errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests)
+}
+"""
+
+GO_WHOAMI = r"""package main
+
+import (
+        "net/http"
+)
+
+// whoamiRedactHeaders are the canonical WHOAMI_REDACT_HEADERS names whose values /whoami hides
+var whoamiRedactHeaders = map[string]bool{}
+
+// WhoAmI is the body of GET /whoami: the request as this process received it, after any
+// proxies in front have had their say
+type WhoAmI struct {
+        ClientIP   string              `json:"client_ip"`
+        RemoteAddr string              `json:"remote_addr"`
+        Scheme     string              `json:"scheme"`
+        Protocol   string              `json:"protocol"`
+        Method     string              `json:"method"`
+        Host       string              `json:"host"`
+        URI        string              `json:"uri"`
+        UserAgent  string              `json:"user_agent,omitempty"`
+        Route      string              `json:"route"`
+        Headers    map[string][]string `json:"headers"`
+        RequestID  string              `json:"request_id,omitempty"`
+}
+
+// whoamiHandler serves GET /whoami for diagnosing proxies that rewrite requests on the way in
+func whoamiHandler(mux *http.ServeMux) http.HandlerFunc {
+        return func(w http.ResponseWriter, r *http.Request) {
+                if r.Method != http.MethodGet {
+                        methodNotAllowed(w, r, http.MethodGet)
+                        return
+                }
+                // Never cache: the answer is about this one request
+                w.Header().Set("Cache-Control", "no-store")
+                writeJSON(w, r, http.StatusOK, WhoAmI{
+                        ClientIP:   clientIP(r),
+                        RemoteAddr: r.RemoteAddr,
+                        Scheme:     requestScheme(r),
+                        Protocol:   r.Proto,
+                        Method:     r.Method,
+                        Host:       r.Host,
+                        URI:        r.RequestURI,
+                        UserAgent:  r.UserAgent(),
+                        Route:      routeLabel(mux, r),
+                        Headers:    redactHeaders(r.Header),
+                        RequestID:  requestIDFromContext(r.Context()),
+                })
+        }
+}
+
+// requestScheme is how the request reached this process: https, h2c for cleartext HTTP/2,
+// or http. Forwarding headers are not consulted; they are listed with the rest.
+func requestScheme(r *http.Request) string {
+        switch {
+        case r.TLS != nil:
+                return "https"
+        case r.ProtoMajor == 2:
+                return "h2c"
+        default:
+                return "http"
+        }
+}
+
+// redactHeaders copies h with the values of whoamiRedactHeaders replaced by redactedValue
+func redactHeaders(h http.Header) map[string][]string {
+        out := make(map[string][]string, len(h))
+        for name, values := range h {
+                if whoamiRedactHeaders[http.CanonicalHeaderKey(name)] {
+                        masked := make([]string, len(values))
+                        for i := range masked {
+                                masked[i] = redactedValue
+                        }
+                        out[name] = masked
+                        continue
+                }
+                out[name] = values
+        }
+        return out
+}
+"""
+
+GO_FILESTORE = r"""package main
+
+import (
+        "bufio"
+        "bytes"
+        "context"
+        "encoding/json"
+        "errors"
+        "fmt"
+        "io"
+        "os"
+        "sync"
+)
+
+// storeRecord is one line of the file backend. The echo is always written in the snake
+// form so JSON_FIELD_STYLE can change without invalidating the file.
+type storeRecord struct {
+        ID   int64     `json:"id"`
+        Echo echoSnake `json:"echo"`
+}
+
+// fileStore is the file backend: every saved message is appended to STORE_FILE as a JSON
+// line, and the file is replayed on startup. The newest MESSAGE_STORE_SIZE messages are
+// also held in memory to serve Get and List; the file itself is never truncated.
+type fileStore struct {
+        mu    sync.Mutex
+        path  string
+        file  *os.File
+        index *messageStore
+}
+
+// openFileStore opens or creates path and replays it, returning the store and how many
+// messages it read. A line that does not decode, such as one torn by a crash mid-write,
+// is skipped and counted in skipped rather than failing startup.
+func openFileStore(path string, capacity int) (s *fileStore, loaded, skipped int, err error) {
+        f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+        if err != nil {
+                return nil, 0, 0, err
+        }
+        s = &fileStore{path: path, file: f, index: newMessageStore(capacity)}
+
+        r := bufio.NewReader(f)
+        unterminated := false
+        for {
+                line, readErr := r.ReadBytes('\n')
+                unterminated = errors.Is(readErr, io.EOF) && len(line) > 0
+                if line = bytes.TrimSpace(line); len(line) > 0 {
+                        var rec storeRecord
+                        if json.Unmarshal(line, &rec) != nil || rec.ID < s.index.nextID {
+                                skipped++
+                        } else {
+                                s.index.keep(StoredMessage{ID: rec.ID, Echo: Echo(rec.Echo)})
+                                loaded++
+                        }
+                }
+                if errors.Is(readErr, io.EOF) {
+                        break
+                }
+                if readErr != nil {
+                        f.Close()
+                        return nil, 0, 0, fmt.Errorf("reading %s: %w", path, readErr)
+                }
+        }
+        // A torn final line has no newline; start the next record on a line of its own
+        if unterminated {
+                if _, err := f.Write([]byte("\n")); err != nil {
+                        f.Close()
+                        return nil, 0, 0, err
+                }
+        }
+        return s, loaded, skipped, nil
+}
+
+// Save appends e to the file under the next ID, then indexes it. Nothing is indexed if
+// the write fails, so the ID is reused by the next call.
+func (s *fileStore) Save(ctx context.Context, e Echo) (StoredMessage, error) {
+        s.mu.Lock()
+        defer s.mu.Unlock()
+
+        s.index.mu.Lock()
+        msg := StoredMessage{ID: s.index.nextID, Echo: e}
+        s.index.mu.Unlock()
+
+        line, err := json.Marshal(storeRecord{ID: msg.ID, Echo: echoSnake(e)})
+        if err != nil {
+                return StoredMessage{}, err
+        }
+        if _, err := s.file.Write(append(line, '\n')); err != nil {
+                return StoredMessage{}, fmt.Errorf("appending to %s: %w", s.path, err)
+        }
+
+        s.index.mu.Lock()
+        s.index.keep(msg)
+        s.index.mu.Unlock()
+        return msg, nil
+}
+
+// Get returns the message with the given ID if it is among the newest MESSAGE_STORE_SIZE
+func (s *fileStore) Get(ctx context.Context, id int64) (StoredMessage, bool, error) {
+        return s.index.Get(ctx, id)
+}
+
+// List returns up to limit messages, newest first
+func (s *fileStore) List(ctx context.Context, limit int) ([]StoredMessage, error) {
+        return s.index.List(ctx, limit)
+}
+
+// close flushes the file to disk and closes it
+func (s *fileStore) close() error {
+        s.mu.Lock()
+        defer s.mu.Unlock()
+        if err := s.file.Sync(); err != nil {
+                s.file.Close()
+                return err
+        }
+        return s.file.Close()
+}
+"""
+
+GO_CLOCK = r"""package main
+
+import "time"
+
+// Clock tells the time for everything a Server stamps onto responses; tests give it a
+// frozenClock for exact timestamps
+type Clock interface {
+        Now() time.Time
+}
+
+// systemClock is the wall clock
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+"""
+
+GO_SERVER = r"""package main
+
+import (
+        "context"
+        "crypto/tls"
+        "errors"
+        "fmt"
+        "log/slog"
+        "net"
+        "net/http"
+        "sync/atomic"
+        "time"
+
+        "github.com/prometheus/client_golang/prometheus"
+        "golang.org/x/net/http2"
+        "golang.org/x/net/http2/h2c"
+        "golang.org/x/net/netutil"
+)
+
+// Server holds what the handlers depend on. main builds one from the loaded configuration
+// and runs it; a test can build one around another Store or Clock and drive s.routes()
+// through httptest without opening a listener.
+type Server struct {
+        cfg     Config
+        logger  *slog.Logger
+        clock   Clock
+        store   Store
+        metrics *prometheus.Registry
+
+        // stop begins a graceful shutdown as SIGTERM does; POST /admin/shutdown calls it
+        stop func()
+
+        // landingPage, set by the ui feature, may answer GET / in place of the JSON banner
+        landingPage func(w http.ResponseWriter, r *http.Request) bool
+
+        // startTime is when the Server was built, by clock; /health/detailed and /stats report uptime from it
+        startTime time.Time
+
+        // endpoints lists the public routes advertised by the root banner, the landing page and
+        // the startup log; routes rebuilds it with the optional ones it registers
+        endpoints []string
+
+        // readiness decides what /ready reports; see markReady. shuttingDown is set once
+        // draining begins, after PRE_SHUTDOWN_DELAY; from then on new requests are turned away.
+        readiness    atomic.Int32
+        shuttingDown atomic.Bool
+
+        // inFlight counts public requests currently being served
+        inFlight atomic.Int64
+
+        stats            *statsCollector
+        breakers         *breakerRegistry
+        transformResults *transformCache
+        events           *eventHub
+
+        limiter         *ipRateLimiter
+        concurrency     *concurrencyLimiter
+        auth            *basicAuth
+        signer          *responseSigner
+        bodyLog         *bodyLogger
+        chaos           *chaosInjector
+        idempotencyKeys *idempotencyCache
+        forwarder       *forwarder
+}
+
+// newServer builds the middleware cfg turns on around store and clock, with slog's default
+// logger and the process metrics registry
+func newServer(cfg Config, store Store, clock Clock) *Server {
+        s := &Server{
+                cfg:       cfg,
+                logger:    slog.Default(),
+                clock:     clock,
+                store:     store,
+                metrics:   metricsRegistry,
+                stop:      func() {},
+                startTime: clock.Now(),
+                endpoints: coreEndpoints,
+                stats:     newStatsCollector(),
+                breakers:  &breakerRegistry{},
+                events:    newEventHub(),
+
+                // Caches ?transform= output; sized by TRANSFORM_CACHE_SIZE and TRANSFORM_CACHE_TTL
+                transformResults: newTransformCache(cfg.TransformCacheSize, cfg.TransformCacheTTL),
+        }
+
+        // Per-IP rate limiting for public endpoints; the limiter always exists so SIGHUP can turn it on
+        s.limiter = newIPRateLimiter(cfg.RateLimitRPS, cfg.RateLimitBurst)
+        if cfg.RateLimitRPS > 0 {
+                s.logger.Info("rate limiting enabled", "rps", cfg.RateLimitRPS, "burst", cfg.RateLimitBurst)
+        }
+
+        // Optional cap on in-progress requests
+        if cfg.MaxConcurrentRequests > 0 {
+                s.concurrency = newConcurrencyLimiter(cfg.MaxConcurrentRequests, cfg.ConcurrencyMode, cfg.ConcurrencyQueueTimeout)
+                s.logger.Info("concurrency limit enabled", "max", cfg.MaxConcurrentRequests, "mode", cfg.ConcurrencyMode)
+        }
+
+        // Optional Basic Auth in front of PROTECTED_PATHS
+        if cfg.BasicAuthUser != "" {
+                s.auth = newBasicAuth(cfg.BasicAuthUser, cfg.BasicAuthPass, cfg.ProtectedPaths)
+                s.logger.Info("basic auth enabled", "paths", cfg.ProtectedPaths)
+        }
+
+        // Optional HMAC signatures on /echo responses
+        if len(cfg.SigningKeys) > 0 {
+                s.signer = newResponseSigner(cfg.SigningKeys, cfg.SigningKeyID)
+                s.logger.Info("response signing enabled", "default_key_id", cfg.SigningKeyID)
+        }
+
+        // Opt-in logging of rejected /echo bodies for debugging malformed clients
+        if cfg.DebugLogBodies {
+                s.bodyLog = newBodyLogger(cfg.DebugBodyMaxBytes, cfg.DebugRedactKeys)
+                s.logger.Warn("request body logging enabled; rejected /echo bodies are logged and may contain personal data",
+                        "max_bytes", cfg.DebugBodyMaxBytes)
+        }
+
+        // Chaos injection for client resilience testing; never enable in production
+        if cfg.ChaosEnabled() {
+                s.chaos = newChaosInjector(cfg)
+                s.logger.Warn("chaos injection enabled on /echo",
+                        "delay", cfg.ChaosDelay.String(),
+                        "jitter", cfg.ChaosJitter.String(),
+                        "delay_rate", cfg.ChaosDelayRate,
+                        "error_rate", cfg.ChaosErrorRate,
+                        "error_status", cfg.ChaosErrorStatus,
+                        "seed", cfg.ChaosSeed)
+        }
+
+        // Retries carrying the same Idempotency-Key replay the first response instead of storing twice
+        if cfg.IdempotencyTTL > 0 {
+                s.idempotencyKeys = newIdempotencyCache(cfg.IdempotencyTTL)
+        }
+
+        // Optional relay of /echo/forward messages to FORWARD_URL
+        if cfg.ForwardURL != "" {
+                s.forwarder = newForwarder(cfg, s.breakers)
+        }
+        return s
+}
+
+// close stops the background work newServer started. A reload keeps the same components,
+// retuning them from the live configuration, so only shutdown ends them.
+func (s *Server) close() {
+        s.limiter.stop()
+        s.idempotencyKeys.stop()
+}
+
+// uptime is how long s has been running by its clock
+func (s *Server) uptime() time.Duration {
+        return s.clock.Now().Sub(s.startTime)
+}
+
+// routes registers the public routes on a new mux, plus the admin ones when there is no
+// ADMIN_PORT, and wraps it in the middleware chain. The advertised endpoints are rebuilt
+// to match.
+func (s *Server) routes() http.Handler {
+        cfg := s.cfg
+        advertised := append([]string(nil), coreEndpoints...)
+        mux := http.NewServeMux()
+
+        mux.HandleFunc("/health", s.handleHealth)
+        mux.HandleFunc("/health/detailed", s.handleDetailedHealth)
+        mux.HandleFunc("/ready", s.handleReady)
+        mux.HandleFunc("/version", s.handleVersion)
+        mux.HandleFunc("/info", s.handleInfo)
+        mux.HandleFunc("/whoami", whoamiHandler(mux))
+        mux.Handle("/echo", rateLimited(s.limiter, chaotic(s.chaos, signed(s.signer, idempotent(s.idempotencyKeys, bodiesLogged(s.bodyLog, http.HandlerFunc(s.handleEcho)))))))
+        mux.Handle("/echo/batch", rateLimited(s.limiter, http.HandlerFunc(s.handleEchoBatch)))
+        mux.Handle("/echo/form", rateLimited(s.limiter, http.HandlerFunc(s.handleEchoForm)))
+        mux.Handle("/echo/stream", rateLimited(s.limiter, http.HandlerFunc(s.handleEchoStream)))
+        mux.HandleFunc("/messages", s.handleMessages)
+        mux.HandleFunc("/messages/", s.handleMessages)
+        mux.HandleFunc("/events", s.handleEvents)
+        mux.HandleFunc("/openapi.json", s.handleOpenAPI)
+
+        // With ADMIN_PORT set, metrics, stats, pprof and /admin/ move to adminRoutes instead
+        if cfg.AdminAddr == "" {
+                s.registerAdminRoutes(mux)
+                for _, f := range s.features() {
+                        f.adminRoutes(s, mux)
+                }
+                advertised = append(advertised, "GET /metrics", "GET /stats")
+        }
+        if s.forwarder != nil {
+                mux.Handle("/echo/forward", rateLimited(s.limiter, http.HandlerFunc(s.handleForward)))
+                advertised = append(advertised, "POST /echo/forward")
+        }
+        for _, f := range s.features() {
+                advertised = append(advertised, f.routes(s, mux)...)
+        }
+        if cfg.EnableTestEndpoints {
+                registerTestRoutes(mux)
+                s.logger.Warn("test endpoints enabled", "routes", "GET /slow")
+        }
+        mux.HandleFunc("/", s.handleRoot)
+        s.endpoints = advertised
+
+        // CORS sits outside every check that can refuse a request, so browsers can read those
+        // refusals too, and preflights are answered before auth and limits see them
+        return defaultHeadersMiddleware(cfg.DefaultHeaders, receivedAtMiddleware(s.clock, withBasePath(inFlightMiddleware(&s.inFlight, tracingMiddleware(mux, requestIDMiddleware(corsMiddleware(loggingMiddleware(mux, s.stats, headerLimitMiddleware(urlLimitMiddleware(shutdownMiddleware(&s.shuttingDown, basicAuthProtected(s.auth, concurrencyLimited(s.concurrency, gzipMiddleware(recoverMiddleware(timeoutMiddleware(bodyLimitMiddleware(responses.middleware(mux))))))))))))))))))
+}
+
+// publicHandler is s.routes() as the public listener serves it. With ENABLE_H2C and no TLS
+// it also accepts HTTP/2 over cleartext, and h2s is the HTTP/2 server to configure for
+// graceful shutdown; HTTP/1.1 clients are served as before.
+func (s *Server) publicHandler() (handler http.Handler, h2s *http2.Server) {
+        handler = s.routes()
+        if !s.cfg.EnableH2C {
+                return handler, nil
+        }
+        if s.cfg.TLSEnabled() {
+                s.logger.Warn("ENABLE_H2C ignored because TLS is enabled; HTTP/2 is negotiated over TLS instead")
+                return handler, nil
+        }
+        h2s = &http2.Server{IdleTimeout: s.cfg.IdleTimeout}
+        return h2c.NewHandler(handler, h2s), h2s
+}
+
+// adminRoutes is the handler for the ADMIN_PORT listener, or nil when there is none
+func (s *Server) adminRoutes() http.Handler {
+        if s.cfg.AdminAddr == "" {
+                return nil
+        }
+        mux := http.NewServeMux()
+        mux.HandleFunc("/", notFound)
+        s.registerAdminRoutes(mux)
+        for _, f := range s.features() {
+                f.adminRoutes(s, mux)
+        }
+        return defaultHeadersMiddleware(s.cfg.DefaultHeaders, requestIDMiddleware(loggingMiddleware(mux, s.stats, headerLimitMiddleware(urlLimitMiddleware(recoverMiddleware(mux))))))
+}
+
+// run serves until ctx ends or a listener fails, then shuts down gracefully: /ready turns
+// 503, PRE_SHUTDOWN_DELAY passes and in-flight requests get SHUTDOWN_TIMEOUT to finish.
+// It returns an error only when serving could not start or stopped on its own.
+func (s *Server) run(ctx context.Context) error {
+        defer s.close()
+        cfg := s.cfg
+        handler, h2s := s.publicHandler()
+
+        // Per-request deadlines must stay below the write timeout so the 503 can be sent
+        if cfg.RequestTimeout > 0 && cfg.WriteTimeout > 0 && cfg.RequestTimeout >= cfg.WriteTimeout {
+                s.logger.Warn("REQUEST_TIMEOUT should be lower than WRITE_TIMEOUT; timed-out requests may be dropped instead of receiving 503",
+                        "request_timeout", cfg.RequestTimeout.String(),
+                        "write_timeout", cfg.WriteTimeout.String())
+        }
+        for path, l := range cfg.routeLimits {
+                if l.timeout > 0 && cfg.WriteTimeout > 0 && l.timeout >= cfg.WriteTimeout {
+                        s.logger.Warn("route timeout override should be lower than WRITE_TIMEOUT",
+                                "path", path,
+                                "request_timeout", l.timeout.String(),
+                                "write_timeout", cfg.WriteTimeout.String())
+                }
+        }
+
+        server := &http.Server{
+                Addr:              cfg.Addr,
+                Handler:           handler,
+                ReadTimeout:       cfg.ReadTimeout,
+                ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+                WriteTimeout:      cfg.WriteTimeout,
+                IdleTimeout:       cfg.IdleTimeout,
+                MaxHeaderBytes:    serverHeaderLimit(cfg.MaxHeaderBytes),
+                TLSConfig:         &tls.Config{MinVersion: tls.VersionTLS12},
+        }
+        server.SetKeepAlivesEnabled(!cfg.DisableKeepAlive)
+        // Long-lived SSE streams would otherwise hold Shutdown until its deadline
+        server.RegisterOnShutdown(s.events.close)
+        for _, f := range s.features() {
+                server.RegisterOnShutdown(f.shutdown)
+        }
+        if h2s != nil {
+                // Lets Shutdown send GOAWAY to h2c connections, which the server no longer tracks once upgraded
+                if err := http2.ConfigureServer(server, h2s); err != nil {
+                        return fmt.Errorf("configuring HTTP/2: %w", err)
+                }
+        }
+
+        // The admin listener has no write timeout because pprof profiles and traces stream for as long as asked
+        var adminServer *http.Server
+        if adminHandler := s.adminRoutes(); adminHandler != nil {
+                adminServer = &http.Server{
+                        Addr:              cfg.AdminAddr,
+                        Handler:           adminHandler,
+                        ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+                        IdleTimeout:       cfg.IdleTimeout,
+                        MaxHeaderBytes:    serverHeaderLimit(cfg.MaxHeaderBytes),
+                }
+        }
+
+        // The Unix socket shares server, so it shares graceful shutdown; it is plain HTTP even with TLS on
+        var unixListener net.Listener
+        if cfg.UnixSocket != "" {
+                ln, err := listenUnix(cfg.UnixSocket, cfg.UnixSocketMode)
+                if err != nil {
+                        return fmt.Errorf("listening on unix socket %s: %w", cfg.UnixSocket, err)
+                }
+                unixListener = ln
+        }
+
+        // Past MAX_CONNECTIONS, new connections are not refused: they wait in the accept
+        // backlog until an open one closes
+        var tcpListener net.Listener
+        if !cfg.UnixSocketOnly {
+                ln, err := net.Listen("tcp", cfg.Addr)
+                if err != nil {
+                        return fmt.Errorf("listening on %s: %w", cfg.Addr, err)
+                }
+                if cfg.MaxConnections > 0 {
+                        ln = netutil.LimitListener(ln, cfg.MaxConnections)
+                }
+                tcpListener = ln
+        }
+
+        s.logger.Info("service starting", "addr", cfg.Addr, "tcp", !cfg.UnixSocketOnly, "unix_socket", cfg.UnixSocket, "base_path", basePath)
+        s.logger.Info("endpoints", "routes", s.endpointSummary(), "build_tags", builtTags())
+        if cfg.TLSEnabled() {
+                s.logger.Info("TLS enabled", "cert", cfg.TLSCertFile)
+        }
+        if h2s != nil {
+                s.logger.Info("h2c enabled")
+        }
+        s.logger.Info("connection limits",
+                "max_connections", cfg.MaxConnections,
+                "keepalive", !cfg.DisableKeepAlive,
+                "idle_timeout", cfg.IdleTimeout.String())
+        if adminServer != nil {
+                s.logger.Info("admin server starting", "addr", cfg.AdminAddr)
+        }
+
+        errCh := make(chan error, 3)
+        if unixListener != nil {
+                go func() {
+                        if err := server.Serve(unixListener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+                                errCh <- fmt.Errorf("unix socket: %w", err)
+                        }
+                }()
+        }
+        if adminServer != nil {
+                go func() {
+                        if err := adminServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+                                errCh <- fmt.Errorf("admin server: %w", err)
+                        }
+                }()
+        }
+        if tcpListener != nil {
+                go func() {
+                        var err error
+                        if cfg.TLSEnabled() {
+                                err = server.ServeTLS(tcpListener, cfg.TLSCertFile, cfg.TLSKeyFile)
+                        } else {
+                                err = server.Serve(tcpListener)
+                        }
+                        if err != nil && !errors.Is(err, http.ErrServerClosed) {
+                                errCh <- err
+                        }
+                }()
+        }
+
+        // /ready stays 503 until the self-checks pass
+        checks := startupChecks(cfg)
+        if err := runStartupChecks(ctx, checks, cfg.StartupCheckTimeout); err != nil {
+                if cfg.StrictStartup {
+                        return fmt.Errorf("startup checks failed: %w", err)
+                }
+                s.logger.Warn("startup checks failed, staying unready and retrying",
+                        "error", err,
+                        "interval", cfg.StartupRetryInterval.String())
+                go s.retryStartupChecks(ctx, checks, cfg.StartupCheckTimeout, cfg.StartupRetryInterval)
+        } else {
+                s.markReady()
+        }
+
+        select {
+        case err := <-errCh:
+                return err
+        case <-ctx.Done():
+        }
+        s.stop()
+        s.logger.Info("shutdown signal received", "pre_shutdown_delay", cfg.PreShutdownDelay.String())
+        s.enterShutdown(cfg.PreShutdownDelay)
+
+        s.logger.Info("draining connections",
+                "timeout", cfg.ShutdownTimeout.String(),
+                "in_flight", s.inFlight.Load())
+        shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+        defer cancel()
+
+        // Public traffic drains first; the admin listener stays up for metrics until it has
+        drained := make(chan struct{})
+        go s.reportDrain(drained, drainLogInterval)
+        clean := s.shutdownServer(shutdownCtx, "public", server)
+        close(drained)
+        if adminServer != nil {
+                clean = s.shutdownServer(shutdownCtx, "admin", adminServer) && clean
+        }
+        if clean {
+                s.logger.Info("server stopped cleanly")
+        }
+        return nil
+}
+"""
+
+GO_FEATURES = r"""package main
+
+import (
+        "net/http"
+        "sort"
+)
+
+// optionalFeature is a feature compiled in only with its build tag, as in
+// `go build -tags pprof,websocket,ui`. Its tagged file adds it to builtFeatures from init,
+// so a build without the tag carries neither its code nor its dependencies.
+type optionalFeature interface {
+        // routes mounts the feature's public routes and returns the ones to advertise
+        routes(s *Server, mux *http.ServeMux) []string
+        // adminRoutes mounts its operator routes on the mux serving /metrics
+        adminRoutes(s *Server, mux *http.ServeMux)
+        // shutdown releases what http.Server.Shutdown does not track, such as hijacked connections
+        shutdown()
+}
+
+// builtFeatures maps build tag to feature for those compiled into this binary
+var builtFeatures = map[string]optionalFeature{}
+
+// featureToggle is the setting that turns on one build-tagged feature
+type featureToggle struct {
+        tag     string
+        setting string
+        on      bool
+}
+
+// featureToggles lists every build-tagged feature with whether c turns it on
+func (c Config) featureToggles() []featureToggle {
+        return []featureToggle{
+                {tag: "pprof", setting: "ENABLE_PPROF", on: c.EnablePprof},
+                {tag: "ui", setting: "ENABLE_UI", on: c.EnableUI},
+                {tag: "websocket", setting: "ENABLE_WEBSOCKET", on: c.EnableWebSocket},
+        }
+}
+
+// builtTags lists the build tags this binary was compiled with, sorted
+func builtTags() []string {
+        tags := make([]string, 0, len(builtFeatures))
+        for tag := range builtFeatures {
+                tags = append(tags, tag)
+        }
+        sort.Strings(tags)
+        return tags
+}
+
+// features returns the compiled-in features s's configuration turns on; validate has
+// already refused a setting whose feature is missing
+func (s *Server) features() []optionalFeature {
+        var out []optionalFeature
+        for _, t := range s.cfg.featureToggles() {
+                if f, ok := builtFeatures[t.tag]; ok && t.on {
+                        out = append(out, f)
+                }
+        }
+        return out
+}
+"""
+
+GO_PPROF = r"""//go:build pprof
+
+package main
+
+import (
+        "log/slog"
+        "net/http"
+        "net/http/pprof"
+)
+
+func init() {
+        builtFeatures["pprof"] = pprofFeature{}
+}
+
+// pprofFeature serves the runtime profiles at /debug/pprof/ alongside /metrics
+type pprofFeature struct{}
+
+func (pprofFeature) routes(*Server, *http.ServeMux) []string { return nil }
+
+// adminRoutes mounts the profiles; they skip the rate limiter but still run under panic recovery
+func (pprofFeature) adminRoutes(_ *Server, mux *http.ServeMux) {
+        mux.HandleFunc("/debug/pprof/", pprof.Index)
+        mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+        mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+        mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+        mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+        slog.Warn("pprof endpoints enabled at /debug/pprof/")
+}
+
+func (pprofFeature) shutdown() {}
+"""
+
+GO_CHECKSUM = r"""package main
+
+import (
+        "crypto/sha256"
+        "crypto/sha512"
+        "encoding/hex"
+        "fmt"
+        "hash"
+        "hash/crc32"
+        "net/http"
+        "sort"
+        "strings"
+        "time"
+)
+
+// checksumAlgorithms are the digests /echo?checksum= may ask for
+var checksumAlgorithms = map[string]func() hash.Hash{
+        "crc32":  func() hash.Hash { return crc32.NewIEEE() },
+        "sha256": sha256.New,
+        "sha512": sha512.New,
+}
+
+// EchoChecksum answers /echo?checksum=<algorithm>: the stamped Echo with the message replaced
+// by its digest and length, so a client can check a large or sensitive payload arrived
+// intact without it coming back
+type EchoChecksum struct {
+        Algorithm   string    `json:"algorithm" xml:"algorithm"`
+        Checksum    string    `json:"checksum" xml:"checksum"`
+        Length      int       `json:"length" xml:"length"`
+        Metadata    Metadata  `json:"metadata,omitempty" xml:"metadata,omitempty"`
+        Timestamp   time.Time `json:"timestamp" xml:"timestamp"`
+        ReceivedAt  time.Time `json:"received_at" xml:"received_at"`
+        ProcessedAt time.Time `json:"processed_at" xml:"processed_at"`
+        Service     string    `json:"service" xml:"service"`
+        RequestID   string    `json:"request_id,omitempty" xml:"request_id,omitempty"`
+        TraceID     string    `json:"trace_id,omitempty" xml:"trace_id,omitempty"`
+}
+
+// checksumAlgorithm reads ?checksum=, answering 400 for one not in checksumAlgorithms. ok is
+// false only after that error; an absent parameter is "" with ok true.
+func checksumAlgorithm(w http.ResponseWriter, r *http.Request) (algorithm string, ok bool) {
+        algorithm = r.URL.Query().Get("checksum")
+        if algorithm == "" {
+                return "", true
+        }
+        if _, known := checksumAlgorithms[algorithm]; !known {
+                names := make([]string, 0, len(checksumAlgorithms))
+                for name := range checksumAlgorithms {
+                        names = append(names, name)
+                }
+                sort.Strings(names)
+                httpError(w, r, http.StatusBadRequest, errInvalidParameter,
+                        fmt.Sprintf("unknown checksum algorithm %q; available: %s", algorithm, strings.Join(names, ", ")))
+                return "", false
+        }
+        return algorithm, true
+}
+
+// newEchoChecksum digests e's message in UTF-8 with algorithm. decodeEcho has already run
+// any ?transform= chain, so this is the message as it would have been echoed.
+func newEchoChecksum(e Echo, algorithm string) EchoChecksum {
+        h := checksumAlgorithms[algorithm]()
+        h.Write([]byte(e.Message))
+        return EchoChecksum{
+                Algorithm:   algorithm,
+                Checksum:    hex.EncodeToString(h.Sum(nil)),
+                Length:      len(e.Message),
+                Metadata:    e.Metadata,
+                Timestamp:   e.Timestamp,
+                ReceivedAt:  e.ReceivedAt,
+                ProcessedAt: e.ProcessedAt,
+                Service:     e.Service,
+                RequestID:   e.RequestID,
+                TraceID:     e.TraceID,
+        }
+}
+"""
+
+GO_CONFIG = r"""package main
+
+import (
+        "bytes"
+        "encoding/json"
+        "errors"
+        "fmt"
+        "io/fs"
+        "log/slog"
+        "net"
+        "net/http"
+        "net/netip"
+        "net/url"
+        "os"
+        "reflect"
+        "sort"
+        "strconv"
+        "strings"
+        "time"
+)
+
+// Config is the effective service configuration, built once at startup by loadConfig
+type Config struct {
+        ConfigFile  string
+        ServiceName string
+        BasePath    string
+        LogFormat   string
+        LogLevel    slog.Level
+
+        // AccessLogFile receives per-request JSON lines instead of stdout; SIGHUP reopens it
+        AccessLogFile string
+
+        // JSONFieldStyle is snake (request_id) or camel (requestId) for Echo and health bodies
+        JSONFieldStyle string
+
+        Addr        string
+        AdminAddr   string
+        TLSCertFile string
+        TLSKeyFile  string
+
+        // DefaultHeaders are set on every response: secureHeaders with DEFAULT_HEADERS applied
+        DefaultHeaders map[string]string
+
+        // UnixSocket also serves the public handler on a Unix domain socket; UnixSocketOnly drops TCP
+        UnixSocket     string
+        UnixSocketMode fs.FileMode
+        UnixSocketOnly bool
+
+        EnableH2C bool
+        // EnablePprof serves /debug/pprof/ beside /metrics; the binary needs -tags pprof
+        EnablePprof bool
+        AdminToken  string `secret:"true"`
+
+        // BasicAuthUser and BasicAuthPass, set together, guard ProtectedPaths and everything beneath them
+        BasicAuthUser  string
+        BasicAuthPass  string `secret:"true"`
+        ProtectedPaths []string
+
+        // SigningKeys maps key id to HMAC secret for /echo response signatures; SigningKeyID
+        // is used when the client does not name one. Empty disables signing.
+        SigningKeys  map[string]string `secret:"true"`
+        SigningKeyID string
+
+        // EchoSchemaValidation checks echo bodies against the embedded JSON Schema
+        EchoSchemaValidation bool
+
+        // RejectDuplicateKeys answers 400 when an echo object repeats a top-level key
+        RejectDuplicateKeys bool
+
+        // PreserveNumbers accepts numeric metadata values, kept as their exact literal text
+        PreserveNumbers bool
+
+        // StrictContentType answers 415 to JSON endpoints sent anything but application/json
+        StrictContentType bool
+
+        // DebugLogBodies logs the first DebugBodyMaxBytes of /echo bodies rejected with a 4xx,
+        // blanking the string values of DebugRedactKeys. Off by default: bodies may hold PII.
+        DebugLogBodies    bool
+        DebugBodyMaxBytes int
+        DebugRedactKeys   []string
+
+        // WhoAmIRedactHeaders are request headers GET /whoami lists with their values hidden
+        WhoAmIRedactHeaders []string
+
+        // ForwardURL enables POST /echo/forward, which relays messages there
+        ForwardURL     string
+        ForwardTimeout time.Duration
+
+        // The forward circuit breaker opens after ForwardBreakerFailures consecutive failed
+        // calls, refuses calls for ForwardBreakerOpenTimeout, then lets
+        // ForwardBreakerHalfOpenRequests probes through. Zero failures disables it.
+        ForwardBreakerFailures         int
+        ForwardBreakerOpenTimeout      time.Duration
+        ForwardBreakerHalfOpenRequests int
+
+        // Chaos settings for /echo: ChaosDelayRate of requests wait ChaosDelay plus up to
+        // ChaosJitter, and ChaosErrorRate of them fail with ChaosErrorStatus. All zero by default.
+        ChaosDelay       time.Duration
+        ChaosJitter      time.Duration
+        ChaosDelayRate   float64
+        ChaosErrorRate   float64
+        ChaosErrorStatus int
+        ChaosSeed        int64
+
+        // EnableWebSocket mounts GET /ws/echo; the binary needs -tags websocket
+        EnableWebSocket bool
+
+        // EnableUI serves an HTML landing page at / to clients that prefer text/html; the
+        // binary needs -tags ui
+        EnableUI bool
+
+        // EnableTestEndpoints mounts diagnostic routes such as /slow; never for production
+        EnableTestEndpoints bool
+
+        ReadTimeout       time.Duration
+        ReadHeaderTimeout time.Duration
+        WriteTimeout      time.Duration
+        IdleTimeout       time.Duration
+        RequestTimeout    time.Duration
+        ShutdownTimeout   time.Duration
+
+        // PreShutdownDelay keeps serving, while /ready reports 503, this long after SIGTERM
+        PreShutdownDelay time.Duration
+
+        // SlowRequestThreshold logs a warning for requests taking at least this long; zero disables
+        SlowRequestThreshold time.Duration
+
+        // MaxConnections caps open connections on the public TCP listener; zero is unlimited.
+        // DisableKeepAlive closes each connection after one request.
+        MaxConnections   int
+        DisableKeepAlive bool
+
+        MaxBodyBytes     int64
+        MaxHeaderBytes   int
+        MaxURLLength     int
+        MaxMessageLen    int
+        MaxBatchSize     int
+        MaxMetadataKeys  int
+        MaxMetadataBytes int
+        MessageStoreSize int
+        GzipMinBytes     int
+
+        // StoreBackend is memory, lost on restart, or file, which appends every message to
+        // StoreFile and replays it on startup. Either way MessageStoreSize bounds what
+        // GET /messages can return.
+        StoreBackend string
+        StoreFile    string
+
+        // Overrides for /echo/batch; zero inherits MaxBodyBytes and RequestTimeout
+        EchoBatchMaxBodyBytes   int64
+        EchoBatchRequestTimeout time.Duration
+
+        // Caps for NDJSON /echo/batch bodies, which are streamed rather than buffered, so
+        // ECHO_BATCH_MAX_BODY_BYTES can be raised for them without growing memory
+        NDJSONMaxLines     int
+        NDJSONMaxLineBytes int
+
+        CORSAllowedOrigins []string
+        RateLimitRPS       float64
+        RateLimitBurst     int
+        IdempotencyTTL     time.Duration
+
+        // TrustedProxies are the peers allowed to report the client address via
+        // X-Forwarded-For or X-Real-IP; empty means those headers are ignored
+        TrustedProxies []netip.Prefix
+
+        // GET responses on ResponseCachePaths are reused for ResponseCacheTTL; zero disables caching
+        ResponseCacheTTL   time.Duration
+        ResponseCachePaths []string
+
+        // ?transform= results are cached for TransformCacheTTL, at most TransformCacheSize
+        // of them; zero for either disables the cache
+        TransformCacheSize int
+        TransformCacheTTL  time.Duration
+
+        // Dependencies maps name to health URL; each is polled every DependencyPollInterval
+        // for /health/detailed. RequiredDependencies names those whose failure means unhealthy.
+        Dependencies           map[string]string
+        RequiredDependencies   []string
+        DependencyPollInterval time.Duration
+        DependencyPollTimeout  time.Duration
+
+        // Startup self-checks gate readiness; StrictStartup exits on failure instead of retrying
+        DependencyCheckURL   string
+        StrictStartup        bool
+        StartupCheckTimeout  time.Duration
+        StartupRetryInterval time.Duration
+
+        // Built-in /health/detailed checks; empty or zero leaves a check out
+        HealthDiskPath     string
+        HealthMaxHeapBytes int64
+
+        // Zero MaxConcurrentRequests disables the limiter
+        MaxConcurrentRequests   int
+        ConcurrencyMode         string
+        ConcurrencyQueueTimeout time.Duration
+
+        // routeLimits holds the per-route overrides derived from the fields above
+        routeLimits map[string]routeLimit
+}
+
+// defaultConfig is the configuration used when no environment variables are set
+func defaultConfig() Config {
+        return Config{
+                ServiceName:    "aurora-go-service",
+                LogFormat:      "text",
+                LogLevel:       slog.LevelInfo,
+                JSONFieldStyle: fieldStyleSnake,
+
+                EchoSchemaValidation: true,
+
+                Addr:              ":8080",
+                UnixSocketMode:    0o660,
+                ReadTimeout:       5 * time.Second,
+                ReadHeaderTimeout: 5 * time.Second,
+                WriteTimeout:      35 * time.Second,
+                IdleTimeout:       120 * time.Second,
+                RequestTimeout:    30 * time.Second,
+                ShutdownTimeout:   15 * time.Second,
+                MaxBodyBytes:      1 << 20,
+                MaxHeaderBytes:    1 << 20,
+                MaxURLLength:      8 << 10,
+                MaxMessageLen:     4096,
+                MaxBatchSize:      100,
+                MaxMetadataKeys:   20,
+                MaxMetadataBytes:  8 << 10,
+                MessageStoreSize:  defaultMessageStoreSize,
+                StoreBackend:      storeBackendMemory,
+                GzipMinBytes:      1024,
+                RateLimitBurst:    20,
+                IdempotencyTTL:    10 * time.Minute,
+
+                ResponseCachePaths: []string{"/", "/version"},
+                TransformCacheSize: 1024,
+                TransformCacheTTL:  time.Minute,
+
+                DependencyPollInterval: 15 * time.Second,
+                DependencyPollTimeout:  2 * time.Second,
+
+                StartupCheckTimeout:  5 * time.Second,
+                StartupRetryInterval: 5 * time.Second,
+
+                ConcurrencyMode:         concurrencyModeReject,
+                ConcurrencyQueueTimeout: 100 * time.Millisecond,
+
+                EchoBatchMaxBodyBytes: 4 << 20,
+
+                NDJSONMaxLines:     10000,
+                NDJSONMaxLineBytes: 64 << 10,
+
+                ProtectedPaths: []string{"/echo"},
+
+                ForwardTimeout: 5 * time.Second,
+
+                ForwardBreakerFailures:         5,
+                ForwardBreakerOpenTimeout:      30 * time.Second,
+                ForwardBreakerHalfOpenRequests: 1,
+
+                ChaosDelayRate:   1,
+                ChaosErrorStatus: http.StatusServiceUnavailable,
+                ChaosSeed:        time.Now().UnixNano(),
+
+                DebugBodyMaxBytes: 1024,
+                DebugRedactKeys:   []string{"password", "token", "secret", "api_key", "authorization"},
+
+                WhoAmIRedactHeaders: []string{"Authorization", "Proxy-Authorization", "Cookie", "X-Api-Key"},
+        }
+}
+
+// loadConfig layers the optional CONFIG_FILE and then the environment over the defaults
+// and validates the result. Every bad setting is reported in the returned error, not just the first.
+func loadConfig() (Config, error) {
+        cfg := defaultConfig()
+        env := &envReader{}
+
+        // CONFIG_FILE supplies a base layer; any environment variable that is set wins over it
+        if path := os.Getenv("CONFIG_FILE"); path != "" {
+                file, err := loadConfigFile(path)
+                if err != nil {
+                        return Config{}, fmt.Errorf("CONFIG_FILE %s: %w", path, err)
+                }
+                env.file = file
+                cfg.ConfigFile = path
+        }
+
+        if v := env.get("SERVICE_NAME"); v != "" {
+                cfg.ServiceName = v
+        }
+        cfg.BasePath = normalizeBasePath(env.get("BASE_PATH"))
+        if v := env.get("LOG_FORMAT"); v != "" {
+                cfg.LogFormat = strings.ToLower(v)
+        }
+        cfg.AccessLogFile = env.get("ACCESS_LOG_FILE")
+        if v := env.get("JSON_FIELD_STYLE"); v != "" {
+                cfg.JSONFieldStyle = strings.ToLower(v)
+        }
+        if v := env.get("LOG_LEVEL"); v != "" {
+                if err := cfg.LogLevel.UnmarshalText([]byte(v)); err != nil {
+                        env.fail("LOG_LEVEL", v, "want debug, info, warn or error")
+                }
+        }
+
+        // BIND_ADDR (or HOST) restricts the listening interface; empty binds all interfaces
+        host, legacyHost := env.get("BIND_ADDR"), env.get("HOST")
+        if host == "" {
+                host = legacyHost
+        }
+        port := env.port("PORT", "8080")
+        addr, err := listenAddr(host, port)
+        if err != nil {
+                env.errs = append(env.errs, fmt.Errorf("invalid listen address: %w", err))
+        }
+        cfg.Addr = addr
+
+        // ADMIN_PORT moves metrics, stats, pprof and /admin/ onto a separate listener on the same interface
+        if adminPort := env.port("ADMIN_PORT", ""); adminPort != "" {
+                adminAddr, err := listenAddr(host, adminPort)
+                if err != nil {
+                        env.errs = append(env.errs, fmt.Errorf("invalid admin listen address: %w", err))
+                } else if adminPort == port {
+                        env.errs = append(env.errs, fmt.Errorf("ADMIN_PORT must differ from PORT, both are %s", port))
+                }
+                cfg.AdminAddr = adminAddr
+        }
+
+        cfg.UnixSocket = env.get("UNIX_SOCKET")
+        if v := env.get("UNIX_SOCKET_MODE"); v != "" {
+                mode, err := strconv.ParseUint(v, 8, 32)
+                if err != nil || mode > 0o777 {
+                        env.fail("UNIX_SOCKET_MODE", v, "want octal permissions such as 0660")
+                } else {
+                        cfg.UnixSocketMode = fs.FileMode(mode)
+                }
+        }
+        cfg.UnixSocketOnly = env.boolean("UNIX_SOCKET_ONLY", cfg.UnixSocketOnly)
+        cfg.TLSCertFile = env.get("TLS_CERT_FILE")
+        cfg.TLSKeyFile = env.get("TLS_KEY_FILE")
+        cfg.DefaultHeaders = env.headers("DEFAULT_HEADERS", secureHeaders(cfg.TLSEnabled()))
+        cfg.EnableH2C = env.boolean("ENABLE_H2C", cfg.EnableH2C)
+        cfg.EnablePprof = env.boolean("ENABLE_PPROF", cfg.EnablePprof)
+        cfg.AdminToken = env.get("ADMIN_TOKEN")
+        cfg.BasicAuthUser = env.get("BASIC_AUTH_USER")
+        cfg.BasicAuthPass = env.get("BASIC_AUTH_PASS")
+        if paths := env.list("PROTECTED_PATHS"); paths != nil {
+                cfg.ProtectedPaths = paths
+        }
+        cfg.SigningKeys, cfg.SigningKeyID = env.signingKeys()
+        cfg.EchoSchemaValidation = env.boolean("ECHO_SCHEMA_VALIDATION", cfg.EchoSchemaValidation)
+        cfg.RejectDuplicateKeys = env.boolean("REJECT_DUPLICATE_KEYS", cfg.RejectDuplicateKeys)
+        cfg.PreserveNumbers = env.boolean("PRESERVE_NUMBERS", cfg.PreserveNumbers)
+        cfg.StrictContentType = env.boolean("STRICT_CONTENT_TYPE", cfg.StrictContentType)
+        cfg.DebugLogBodies = env.boolean("DEBUG_LOG_BODIES", cfg.DebugLogBodies)
+        cfg.DebugBodyMaxBytes = env.integer("DEBUG_BODY_MAX_BYTES", cfg.DebugBodyMaxBytes)
+        if keys := env.list("DEBUG_REDACT_KEYS"); keys != nil {
+                cfg.DebugRedactKeys = keys
+        }
+        if headers := env.list("WHOAMI_REDACT_HEADERS"); headers != nil {
+                cfg.WhoAmIRedactHeaders = headers
+        }
+        cfg.ForwardURL = env.get("FORWARD_URL")
+        cfg.ForwardTimeout = env.duration("FORWARD_TIMEOUT", cfg.ForwardTimeout)
+        cfg.ForwardBreakerFailures = env.integer("FORWARD_BREAKER_FAILURES", cfg.ForwardBreakerFailures)
+        cfg.ForwardBreakerOpenTimeout = env.duration("FORWARD_BREAKER_OPEN_TIMEOUT", cfg.ForwardBreakerOpenTimeout)
+        cfg.ForwardBreakerHalfOpenRequests = env.integer("FORWARD_BREAKER_HALF_OPEN_REQUESTS", cfg.ForwardBreakerHalfOpenRequests)
+        cfg.ChaosDelay = time.Duration(env.integer("CHAOS_DELAY_MS", int(cfg.ChaosDelay/time.Millisecond))) * time.Millisecond
+        cfg.ChaosJitter = time.Duration(env.integer("CHAOS_JITTER_MS", int(cfg.ChaosJitter/time.Millisecond))) * time.Millisecond
+        cfg.ChaosDelayRate = env.number("CHAOS_DELAY_RATE", cfg.ChaosDelayRate)
+        cfg.ChaosErrorRate = env.number("CHAOS_ERROR_RATE", cfg.ChaosErrorRate)
+        cfg.ChaosErrorStatus = env.integer("CHAOS_ERROR_STATUS", cfg.ChaosErrorStatus)
+        cfg.ChaosSeed = int64(env.integer("CHAOS_SEED", int(cfg.ChaosSeed)))
+        cfg.EnableWebSocket = env.boolean("ENABLE_WEBSOCKET", cfg.EnableWebSocket)
+        cfg.EnableUI = env.boolean("ENABLE_UI", cfg.EnableUI)
+        cfg.EnableTestEndpoints = env.boolean("ENABLE_TEST_ENDPOINTS", cfg.EnableTestEndpoints)
+
+        cfg.ReadTimeout = env.duration("READ_TIMEOUT", cfg.ReadTimeout)
+        cfg.ReadHeaderTimeout = env.duration("READ_HEADER_TIMEOUT", cfg.ReadHeaderTimeout)
+        cfg.WriteTimeout = env.duration("WRITE_TIMEOUT", cfg.WriteTimeout)
+        cfg.IdleTimeout = env.duration("IDLE_TIMEOUT", cfg.IdleTimeout)
+        cfg.RequestTimeout = env.duration("REQUEST_TIMEOUT", cfg.RequestTimeout)
+        cfg.ShutdownTimeout = env.duration("SHUTDOWN_TIMEOUT", cfg.ShutdownTimeout)
+        cfg.PreShutdownDelay = env.duration("PRE_SHUTDOWN_DELAY", cfg.PreShutdownDelay)
+        cfg.SlowRequestThreshold = env.duration("SLOW_REQUEST_THRESHOLD", cfg.SlowRequestThreshold)
+        cfg.MaxConnections = env.integer("MAX_CONNECTIONS", cfg.MaxConnections)
+        cfg.DisableKeepAlive = env.boolean("DISABLE_KEEPALIVE", cfg.DisableKeepAlive)
+
+        cfg.MaxBodyBytes = int64(env.integer("MAX_BODY_BYTES", int(cfg.MaxBodyBytes)))
+        cfg.MaxHeaderBytes = env.integer("MAX_HEADER_BYTES", cfg.MaxHeaderBytes)
+        cfg.MaxURLLength = env.integer("MAX_URL_LENGTH", cfg.MaxURLLength)
+        cfg.MaxMessageLen = env.integer("MAX_MESSAGE_LEN", cfg.MaxMessageLen)
+        cfg.MaxBatchSize = env.integer("MAX_BATCH_SIZE", cfg.MaxBatchSize)
+        cfg.MaxMetadataKeys = env.integer("MAX_METADATA_KEYS", cfg.MaxMetadataKeys)
+        cfg.MaxMetadataBytes = env.integer("MAX_METADATA_BYTES", cfg.MaxMetadataBytes)
+        cfg.MessageStoreSize = env.integer("MESSAGE_STORE_SIZE", cfg.MessageStoreSize)
+        if v := env.get("STORE_BACKEND"); v != "" {
+                cfg.StoreBackend = strings.ToLower(v)
+        }
+        cfg.StoreFile = env.get("STORE_FILE")
+        cfg.GzipMinBytes = env.integer("GZIP_MIN_BYTES", cfg.GzipMinBytes)
+        cfg.EchoBatchMaxBodyBytes = int64(env.integer("ECHO_BATCH_MAX_BODY_BYTES", int(cfg.EchoBatchMaxBodyBytes)))
+        cfg.EchoBatchRequestTimeout = env.duration("ECHO_BATCH_REQUEST_TIMEOUT", cfg.EchoBatchRequestTimeout)
+        cfg.NDJSONMaxLines = env.integer("NDJSON_MAX_LINES", cfg.NDJSONMaxLines)
+        cfg.NDJSONMaxLineBytes = env.integer("NDJSON_MAX_LINE_BYTES", cfg.NDJSONMaxLineBytes)
+
+        cfg.CORSAllowedOrigins = env.list("CORS_ALLOWED_ORIGINS")
+        cfg.RateLimitRPS = env.number("RATE_LIMIT_RPS", cfg.RateLimitRPS)
+        cfg.RateLimitBurst = env.integer("RATE_LIMIT_BURST", cfg.RateLimitBurst)
+        cfg.IdempotencyTTL = env.duration("IDEMPOTENCY_TTL", cfg.IdempotencyTTL)
+        cfg.TrustedProxies = env.prefixes("TRUSTED_PROXIES")
+        cfg.ResponseCacheTTL = env.duration("RESPONSE_CACHE_TTL", cfg.ResponseCacheTTL)
+        if paths := env.list("RESPONSE_CACHE_PATHS"); paths != nil {
+                cfg.ResponseCachePaths = paths
+        }
+        cfg.TransformCacheSize = env.integer("TRANSFORM_CACHE_SIZE", cfg.TransformCacheSize)
+        cfg.TransformCacheTTL = env.duration("TRANSFORM_CACHE_TTL", cfg.TransformCacheTTL)
+        cfg.Dependencies = env.dependencies()
+        cfg.RequiredDependencies = env.list("REQUIRED_DEPENDENCIES")
+        cfg.DependencyPollInterval = env.duration("DEPENDENCY_POLL_INTERVAL", cfg.DependencyPollInterval)
+        cfg.DependencyPollTimeout = env.duration("DEPENDENCY_POLL_TIMEOUT", cfg.DependencyPollTimeout)
+        cfg.DependencyCheckURL = env.get("DEPENDENCY_CHECK_URL")
+        cfg.StrictStartup = env.boolean("STRICT_STARTUP", cfg.StrictStartup)
+        cfg.StartupCheckTimeout = env.duration("STARTUP_CHECK_TIMEOUT", cfg.StartupCheckTimeout)
+        cfg.StartupRetryInterval = env.duration("STARTUP_RETRY_INTERVAL", cfg.StartupRetryInterval)
+        cfg.HealthDiskPath = env.get("HEALTH_DISK_PATH")
+        cfg.HealthMaxHeapBytes = int64(env.integer("HEALTH_MAX_HEAP_BYTES", int(cfg.HealthMaxHeapBytes)))
+        cfg.MaxConcurrentRequests = env.integer("MAX_CONCURRENT_REQUESTS", cfg.MaxConcurrentRequests)
+        if v := env.get("CONCURRENCY_MODE"); v != "" {
+                cfg.ConcurrencyMode = strings.ToLower(v)
+        }
+        cfg.ConcurrencyQueueTimeout = env.duration("CONCURRENCY_QUEUE_TIMEOUT", cfg.ConcurrencyQueueTimeout)
+
+        env.checkUnusedFileKeys()
+        if err := errors.Join(env.errs...); err != nil {
+                return Config{}, err
+        }
+        if err := cfg.validate(); err != nil {
+                return Config{}, err
+        }
+        cfg.routeLimits = routeLimitsFor(cfg)
+        return cfg, nil
+}
+
+// validate checks ranges and combinations that parse fine but make no sense
+func (c Config) validate() error {
+        var errs []error
+        check := func(ok bool, format string, args ...any) {
+                if !ok {
+                        errs = append(errs, fmt.Errorf(format, args...))
+                }
+        }
+
+        check(c.LogFormat == "text" || c.LogFormat == "json", "LOG_FORMAT %q: want text or json", c.LogFormat)
+        check(c.JSONFieldStyle == fieldStyleSnake || c.JSONFieldStyle == fieldStyleCamel,
+                "JSON_FIELD_STYLE %q: want snake or camel", c.JSONFieldStyle)
+        check((c.TLSCertFile == "") == (c.TLSKeyFile == ""), "TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+        check(!c.UnixSocketOnly || c.UnixSocket != "", "UNIX_SOCKET_ONLY requires UNIX_SOCKET")
+        for _, t := range c.featureToggles() {
+                _, built := builtFeatures[t.tag]
+                check(!t.on || built, "%s requires a binary built with -tags %s", t.setting, t.tag)
+        }
+        check((c.BasicAuthUser == "") == (c.BasicAuthPass == ""), "BASIC_AUTH_USER and BASIC_AUTH_PASS must be set together")
+        for _, p := range c.ProtectedPaths {
+                check(strings.HasPrefix(p, "/"), "PROTECTED_PATHS entry %q must start with /", p)
+        }
+        for _, p := range c.ResponseCachePaths {
+                check(strings.HasPrefix(p, "/"), "RESPONSE_CACHE_PATHS entry %q must start with /", p)
+        }
+        if len(c.SigningKeys) > 0 {
+                _, ok := c.SigningKeys[c.SigningKeyID]
+                check(c.SigningKeyID != "", "SIGNING_KEY_ID is required when more than one signing key is set")
+                check(c.SigningKeyID == "" || ok, "SIGNING_KEY_ID %q does not name a configured signing key", c.SigningKeyID)
+        }
+
+        // Zero disables a connection timeout, as with http.Server, and turns off idempotency keys
+        for _, t := range []struct {
+                key string
+                d   time.Duration
+        }{
+                {"READ_TIMEOUT", c.ReadTimeout},
+                {"READ_HEADER_TIMEOUT", c.ReadHeaderTimeout},
+                {"WRITE_TIMEOUT", c.WriteTimeout},
+                {"IDLE_TIMEOUT", c.IdleTimeout},
+                {"REQUEST_TIMEOUT", c.RequestTimeout},
+                {"ECHO_BATCH_REQUEST_TIMEOUT", c.EchoBatchRequestTimeout},
+                {"IDEMPOTENCY_TTL", c.IdempotencyTTL},
+                {"RESPONSE_CACHE_TTL", c.ResponseCacheTTL},
+                {"TRANSFORM_CACHE_TTL", c.TransformCacheTTL},
+                {"PRE_SHUTDOWN_DELAY", c.PreShutdownDelay},
+                {"SLOW_REQUEST_THRESHOLD", c.SlowRequestThreshold},
+        } {
+                check(t.d >= 0, "%s must not be negative, got %s", t.key, t.d)
+        }
+        check(c.ShutdownTimeout > 0, "SHUTDOWN_TIMEOUT must be positive, got %s", c.ShutdownTimeout)
+        check(c.MaxConnections >= 0, "MAX_CONNECTIONS must not be negative, got %d", c.MaxConnections)
+
+        check(c.MaxBodyBytes > 0, "MAX_BODY_BYTES must be positive, got %d", c.MaxBodyBytes)
+        check(c.MaxHeaderBytes > 0, "MAX_HEADER_BYTES must be positive, got %d", c.MaxHeaderBytes)
+        check(c.MaxURLLength > 0, "MAX_URL_LENGTH must be positive, got %d", c.MaxURLLength)
+        check(c.MaxMessageLen > 0, "MAX_MESSAGE_LEN must be positive, got %d", c.MaxMessageLen)
+        check(c.MaxBatchSize > 0, "MAX_BATCH_SIZE must be positive, got %d", c.MaxBatchSize)
+        check(c.MaxMetadataKeys >= 0, "MAX_METADATA_KEYS must not be negative, got %d", c.MaxMetadataKeys)
+        check(c.MaxMetadataBytes >= 0, "MAX_METADATA_BYTES must not be negative, got %d", c.MaxMetadataBytes)
+        check(c.MessageStoreSize >= 0, "MESSAGE_STORE_SIZE must not be negative, got %d", c.MessageStoreSize)
+        check(c.TransformCacheSize >= 0, "TRANSFORM_CACHE_SIZE must not be negative, got %d", c.TransformCacheSize)
+        check(c.StoreBackend == storeBackendMemory || c.StoreBackend == storeBackendFile,
+                "STORE_BACKEND %q: want memory or file", c.StoreBackend)
+        check(c.StoreBackend != storeBackendFile || c.StoreFile != "", "STORE_BACKEND=file requires STORE_FILE")
+        check(c.DebugBodyMaxBytes > 0, "DEBUG_BODY_MAX_BYTES must be positive, got %d", c.DebugBodyMaxBytes)
+        check(c.GzipMinBytes >= 0, "GZIP_MIN_BYTES must not be negative, got %d", c.GzipMinBytes)
+        check(c.EchoBatchMaxBodyBytes >= 0, "ECHO_BATCH_MAX_BODY_BYTES must not be negative, got %d", c.EchoBatchMaxBodyBytes)
+        check(c.NDJSONMaxLines > 0, "NDJSON_MAX_LINES must be positive, got %d", c.NDJSONMaxLines)
+        check(c.NDJSONMaxLineBytes > 0, "NDJSON_MAX_LINE_BYTES must be positive, got %d", c.NDJSONMaxLineBytes)
+
+        for _, name := range c.dependencyNames() {
+                u, err := url.Parse(c.Dependencies[name])
+                check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+                        "DEPENDENCIES entry %s=%q: want an absolute http or https URL", name, c.Dependencies[name])
+        }
+        for _, name := range c.RequiredDependencies {
+                _, ok := c.Dependencies[name]
+                check(ok, "REQUIRED_DEPENDENCIES entry %q is not listed in DEPENDENCIES", name)
+        }
+        check(c.DependencyPollInterval > 0, "DEPENDENCY_POLL_INTERVAL must be positive, got %s", c.DependencyPollInterval)
+        check(c.DependencyPollTimeout > 0, "DEPENDENCY_POLL_TIMEOUT must be positive, got %s", c.DependencyPollTimeout)
+        if c.DependencyCheckURL != "" {
+                u, err := url.Parse(c.DependencyCheckURL)
+                check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+                        "DEPENDENCY_CHECK_URL %q: want an absolute http or https URL", c.DependencyCheckURL)
+        }
+        if c.ForwardURL != "" {
+                u, err := url.Parse(c.ForwardURL)
+                check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+                        "FORWARD_URL %q: want an absolute http or https URL", c.ForwardURL)
+        }
+        check(c.ForwardTimeout > 0, "FORWARD_TIMEOUT must be positive, got %s", c.ForwardTimeout)
+        check(c.ForwardBreakerFailures >= 0, "FORWARD_BREAKER_FAILURES must not be negative, got %d", c.ForwardBreakerFailures)
+        check(c.ForwardBreakerOpenTimeout > 0, "FORWARD_BREAKER_OPEN_TIMEOUT must be positive, got %s", c.ForwardBreakerOpenTimeout)
+        check(c.ForwardBreakerHalfOpenRequests >= 1, "FORWARD_BREAKER_HALF_OPEN_REQUESTS must be at least 1, got %d", c.ForwardBreakerHalfOpenRequests)
+        check(c.ChaosDelay >= 0, "CHAOS_DELAY_MS must not be negative, got %d", c.ChaosDelay.Milliseconds())
+        check(c.ChaosJitter >= 0, "CHAOS_JITTER_MS must not be negative, got %d", c.ChaosJitter.Milliseconds())
+        check(c.ChaosDelayRate >= 0 && c.ChaosDelayRate <= 1, "CHAOS_DELAY_RATE must be between 0 and 1, got %g", c.ChaosDelayRate)
+        check(c.ChaosErrorRate >= 0 && c.ChaosErrorRate <= 1, "CHAOS_ERROR_RATE must be between 0 and 1, got %g", c.ChaosErrorRate)
+        check(c.ChaosErrorStatus >= 400 && c.ChaosErrorStatus <= 599, "CHAOS_ERROR_STATUS must be a 4xx or 5xx status, got %d", c.ChaosErrorStatus)
+        check(c.StartupCheckTimeout > 0, "STARTUP_CHECK_TIMEOUT must be positive, got %s", c.StartupCheckTimeout)
+        check(c.StartupRetryInterval > 0, "STARTUP_RETRY_INTERVAL must be positive, got %s", c.StartupRetryInterval)
+
+        check(c.HealthMaxHeapBytes >= 0, "HEALTH_MAX_HEAP_BYTES must not be negative, got %d", c.HealthMaxHeapBytes)
+        check(c.MaxConcurrentRequests >= 0, "MAX_CONCURRENT_REQUESTS must not be negative, got %d", c.MaxConcurrentRequests)
+        check(c.ConcurrencyMode == concurrencyModeReject || c.ConcurrencyMode == concurrencyModeQueue,
+                "CONCURRENCY_MODE %q: want reject or queue", c.ConcurrencyMode)
+        check(c.ConcurrencyQueueTimeout > 0, "CONCURRENCY_QUEUE_TIMEOUT must be positive, got %s", c.ConcurrencyQueueTimeout)
+
+        check(c.RateLimitRPS >= 0, "RATE_LIMIT_RPS must not be negative, got %g", c.RateLimitRPS)
+        check(c.RateLimitRPS == 0 || c.RateLimitBurst >= 1, "RATE_LIMIT_BURST must be at least 1 when rate limiting is enabled, got %d", c.RateLimitBurst)
+
+        return errors.Join(errs...)
+}
+
+// defaultHeaderNames lists the default response header names in order
+func (c Config) defaultHeaderNames() []string {
+        names := make([]string, 0, len(c.DefaultHeaders))
+        for name := range c.DefaultHeaders {
+                names = append(names, name)
+        }
+        sort.Strings(names)
+        return names
+}
+
+// signingKeyIDs lists the configured signing key ids in order; the secrets are never logged
+func (c Config) signingKeyIDs() []string {
+        ids := make([]string, 0, len(c.SigningKeys))
+        for id := range c.SigningKeys {
+                ids = append(ids, id)
+        }
+        sort.Strings(ids)
+        return ids
+}
+
+// redactedValue replaces a set secret in GET /config
+const redactedValue = "***"
+
+// redacted renders c for GET /config, keyed by field name. Fields tagged secret:"true" are
+// replaced by redactedValue when set; a secret map keeps its keys, such as signing key
+// ids, and loses its values. Nothing is redacted by name, so every new secret field has to
+// carry the tag. Durations and the socket mode are rendered the way they are configured.
+func (c Config) redacted() map[string]any {
+        v := reflect.ValueOf(c)
+        t := v.Type()
+        out := make(map[string]any, t.NumField())
+        for i := 0; i < t.NumField(); i++ {
+                field := t.Field(i)
+                if !field.IsExported() {
+                        continue
+                }
+                value := v.Field(i)
+                if field.Tag.Get("secret") == "true" {
+                        out[field.Name] = redactSecret(value)
+                        continue
+                }
+                switch x := value.Interface().(type) {
+                case time.Duration:
+                        out[field.Name] = x.String()
+                case fs.FileMode:
+                        out[field.Name] = fmt.Sprintf("%04o", uint32(x))
+                default:
+                        out[field.Name] = x
+                }
+        }
+        return out
+}
+
+func redactSecret(v reflect.Value) any {
+        if v.Kind() == reflect.Map {
+                keys := make(map[string]string, v.Len())
+                iter := v.MapRange()
+                for iter.Next() {
+                        keys[fmt.Sprint(iter.Key().Interface())] = redactedValue
+                }
+                return keys
+        }
+        if v.IsZero() {
+                return ""
+        }
+        return redactedValue
+}
+
+// dependencyNames lists the configured dependencies in name order
+func (c Config) dependencyNames() []string {
+        names := make([]string, 0, len(c.Dependencies))
+        for name := range c.Dependencies {
+                names = append(names, name)
+        }
+        sort.Strings(names)
+        return names
+}
+
+// trustedProxyList renders TrustedProxies for logging
+func (c Config) trustedProxyList() string {
+        ranges := make([]string, len(c.TrustedProxies))
+        for i, p := range c.TrustedProxies {
+                ranges[i] = p.String()
+        }
+        return strings.Join(ranges, ",")
+}
+
+// ChaosEnabled reports whether any chaos injection is configured
+func (c Config) ChaosEnabled() bool {
+        return c.ChaosDelay > 0 || c.ChaosJitter > 0 || c.ChaosErrorRate > 0
+}
+
+// TLSEnabled reports whether a certificate and key were configured
+func (c Config) TLSEnabled() bool {
+        return c.TLSCertFile != ""
+}
+
+// features reports which optional features c turns on, for GET /info. Tracing is left to the
+// caller: it is switched on by the OTEL_* variables, not by Config.
+func (c Config) features() map[string]bool {
+        return map[string]bool{
+                "access_log":        c.AccessLogFile != "",
+                "admin_listener":    c.AdminAddr != "",
+                "basic_auth":        c.BasicAuthUser != "",
+                "chaos":             c.ChaosEnabled(),
+                "concurrency_limit": c.MaxConcurrentRequests > 0,
+                "dependencies":      len(c.Dependencies) > 0,
+                "forward":           c.ForwardURL != "",
+                "h2c":               c.EnableH2C && !c.TLSEnabled(),
+                "idempotency":       c.IdempotencyTTL > 0,
+                "pprof":             c.EnablePprof,
+                "rate_limit":        c.RateLimitRPS > 0,
+                "response_cache":    c.ResponseCacheTTL > 0,
+                "transform_cache":   c.TransformCacheSize > 0 && c.TransformCacheTTL > 0,
+                "schema_validation": c.EchoSchemaValidation,
+                "signing":           len(c.SigningKeys) > 0,
+                "test_endpoints":    c.EnableTestEndpoints,
+                "tls":               c.TLSEnabled(),
+                "ui":                c.EnableUI,
+                "unix_socket":       c.UnixSocket != "",
+                "websocket":         c.EnableWebSocket,
+        }
+}
+
+// LogValue renders the startup summary. Add new fields here deliberately: anything
+// secret must be left out or redacted, never logged as-is.
+func (c Config) LogValue() slog.Value {
+        return slog.GroupValue(
+                slog.String("config_file", c.ConfigFile),
+                slog.String("service_name", c.ServiceName),
+                slog.String("base_path", c.BasePath),
+                slog.String("log_format", c.LogFormat),
+                slog.String("log_level", c.LogLevel.String()),
+                slog.String("access_log_file", c.AccessLogFile),
+                slog.String("json_field_style", c.JSONFieldStyle),
+                slog.String("addr", c.Addr),
+                slog.String("admin_addr", c.AdminAddr),
+                slog.String("unix_socket", c.UnixSocket),
+                slog.String("unix_socket_mode", fmt.Sprintf("%#o", c.UnixSocketMode)),
+                slog.Bool("unix_socket_only", c.UnixSocketOnly),
+                slog.Bool("tls", c.TLSEnabled()),
+                slog.String("default_headers", strings.Join(c.defaultHeaderNames(), ",")),
+                slog.Bool("h2c", c.EnableH2C),
+                slog.Bool("pprof", c.EnablePprof),
+                slog.Bool("admin", c.AdminToken != ""),
+                slog.Bool("basic_auth", c.BasicAuthUser != ""),
+                slog.String("protected_paths", strings.Join(c.ProtectedPaths, ",")),
+                slog.String("signing_key_ids", strings.Join(c.signingKeyIDs(), ",")),
+                slog.String("signing_key_id", c.SigningKeyID),
+                slog.Bool("echo_schema_validation", c.EchoSchemaValidation),
+                slog.Bool("reject_duplicate_keys", c.RejectDuplicateKeys),
+                slog.Bool("preserve_numbers", c.PreserveNumbers),
+                slog.Bool("strict_content_type", c.StrictContentType),
+                slog.Bool("debug_log_bodies", c.DebugLogBodies),
+                slog.Int("debug_body_max_bytes", c.DebugBodyMaxBytes),
+                slog.String("debug_redact_keys", strings.Join(c.DebugRedactKeys, ",")),
+                slog.String("whoami_redact_headers", strings.Join(c.WhoAmIRedactHeaders, ",")),
+                slog.Bool("forward", c.ForwardURL != ""),
+                slog.String("forward_timeout", c.ForwardTimeout.String()),
+                slog.Int("forward_breaker_failures", c.ForwardBreakerFailures),
+                slog.String("forward_breaker_open_timeout", c.ForwardBreakerOpenTimeout.String()),
+                slog.Int("forward_breaker_half_open_requests", c.ForwardBreakerHalfOpenRequests),
+                slog.Bool("chaos", c.ChaosEnabled()),
+                slog.Bool("websocket", c.EnableWebSocket),
+                slog.Bool("ui", c.EnableUI),
+                slog.Bool("test_endpoints", c.EnableTestEndpoints),
+                slog.String("read_timeout", c.ReadTimeout.String()),
+                slog.String("read_header_timeout", c.ReadHeaderTimeout.String()),
+                slog.String("write_timeout", c.WriteTimeout.String()),
+                slog.String("idle_timeout", c.IdleTimeout.String()),
+                slog.String("request_timeout", c.RequestTimeout.String()),
+                slog.String("shutdown_timeout", c.ShutdownTimeout.String()),
+                slog.String("pre_shutdown_delay", c.PreShutdownDelay.String()),
+                slog.String("slow_request_threshold", c.SlowRequestThreshold.String()),
+                slog.Int("max_connections", c.MaxConnections),
+                slog.Bool("disable_keepalive", c.DisableKeepAlive),
+                slog.Int64("max_body_bytes", c.MaxBodyBytes),
+                slog.Int("max_header_bytes", c.MaxHeaderBytes),
+                slog.Int("max_url_length", c.MaxURLLength),
+                slog.Int("max_message_len", c.MaxMessageLen),
+                slog.Int("max_batch_size", c.MaxBatchSize),
+                slog.Int("max_metadata_keys", c.MaxMetadataKeys),
+                slog.Int("max_metadata_bytes", c.MaxMetadataBytes),
+                slog.Int("message_store_size", c.MessageStoreSize),
+                slog.String("store_backend", c.StoreBackend),
+                slog.String("store_file", c.StoreFile),
+                slog.Int("gzip_min_bytes", c.GzipMinBytes),
+                slog.Int64("echo_batch_max_body_bytes", c.EchoBatchMaxBodyBytes),
+                slog.String("echo_batch_request_timeout", c.EchoBatchRequestTimeout.String()),
+                slog.Int("ndjson_max_lines", c.NDJSONMaxLines),
+                slog.Int("ndjson_max_line_bytes", c.NDJSONMaxLineBytes),
+                slog.String("cors_allowed_origins", strings.Join(c.CORSAllowedOrigins, ",")),
+                slog.Float64("rate_limit_rps", c.RateLimitRPS),
+                slog.Int("rate_limit_burst", c.RateLimitBurst),
+                slog.String("trusted_proxies", c.trustedProxyList()),
+                slog.String("idempotency_ttl", c.IdempotencyTTL.String()),
+                slog.String("response_cache_ttl", c.ResponseCacheTTL.String()),
+                slog.String("response_cache_paths", strings.Join(c.ResponseCachePaths, ",")),
+                slog.Int("transform_cache_size", c.TransformCacheSize),
+                slog.String("transform_cache_ttl", c.TransformCacheTTL.String()),
+                slog.String("dependencies", strings.Join(c.dependencyNames(), ",")),
+                slog.Bool("dependency_check", c.DependencyCheckURL != ""),
+                slog.Bool("strict_startup", c.StrictStartup),
+                slog.String("startup_check_timeout", c.StartupCheckTimeout.String()),
+                slog.String("startup_retry_interval", c.StartupRetryInterval.String()),
+                slog.String("health_disk_path", c.HealthDiskPath),
+                slog.Int64("health_max_heap_bytes", c.HealthMaxHeapBytes),
+                slog.Int("max_concurrent_requests", c.MaxConcurrentRequests),
+                slog.String("concurrency_mode", c.ConcurrencyMode),
+                slog.String("concurrency_queue_timeout", c.ConcurrencyQueueTimeout.String()),
+        )
+}
+
+// applyConfig copies cfg into the package-level settings read by handlers and middleware
+// and makes it the live configuration
+func applyConfig(cfg Config) {
+        serviceName = cfg.ServiceName
+        basePath = cfg.BasePath
+        jsonFieldStyle = cfg.JSONFieldStyle
+        strictContentType = cfg.StrictContentType
+        schemaValidation = cfg.EchoSchemaValidation
+        rejectDuplicateKeys = cfg.RejectDuplicateKeys
+        trustedProxies = cfg.TrustedProxies
+        whoamiRedactHeaders = make(map[string]bool, len(cfg.WhoAmIRedactHeaders))
+        for _, name := range cfg.WhoAmIRedactHeaders {
+                whoamiRedactHeaders[http.CanonicalHeaderKey(name)] = true
+        }
+        maxHeaderBytes = cfg.MaxHeaderBytes
+        maxURLLength = cfg.MaxURLLength
+        slowRequestThreshold = cfg.SlowRequestThreshold
+        maxMessageLen = cfg.MaxMessageLen
+        maxBatchSize = cfg.MaxBatchSize
+        ndjsonMaxLines = cfg.NDJSONMaxLines
+        ndjsonMaxLineBytes = cfg.NDJSONMaxLineBytes
+        maxMetadataKeys = cfg.MaxMetadataKeys
+        maxMetadataBytes = cfg.MaxMetadataBytes
+        corsAllowedOrigins = cfg.CORSAllowedOrigins
+        gzipMinBytes = cfg.GzipMinBytes
+        liveConfig.Store(&cfg)
+}
+
+// envReader reads typed settings from the environment, falling back to values from
+// CONFIG_FILE, and collects parse errors instead of stopping at the first one
+type envReader struct {
+        file map[string]string
+        used map[string]bool
+        errs []error
+}
+
+// get returns the environment value for key, or the file value when the variable is unset
+func (e *envReader) get(key string) string {
+        if e.used == nil {
+                e.used = make(map[string]bool)
+        }
+        e.used[key] = true
+        if v := os.Getenv(key); v != "" {
+                return v
+        }
+        return e.file[key]
+}
+
+// checkUnusedFileKeys reports file settings no option read, which are almost always typos
+func (e *envReader) checkUnusedFileKeys() {
+        keys := make([]string, 0, len(e.file))
+        for key := range e.file {
+                if !e.used[key] {
+                        keys = append(keys, key)
+                }
+        }
+        sort.Strings(keys)
+        for _, key := range keys {
+                e.errs = append(e.errs, fmt.Errorf("CONFIG_FILE: unknown setting %q", key))
+        }
+}
+
+// fail records a bad value for key
+func (e *envReader) fail(key, value, want string) {
+        e.errs = append(e.errs, fmt.Errorf("%s=%q: %s", key, value, want))
+}
+
+// duration reads a Go duration such as "5s", falling back to def
+func (e *envReader) duration(key string, def time.Duration) time.Duration {
+        v := e.get(key)
+        if v == "" {
+                return def
+        }
+        d, err := time.ParseDuration(v)
+        if err != nil {
+                e.fail(key, v, "want a duration such as 5s or 250ms")
+                return def
+        }
+        return d
+}
+
+// integer reads a base-10 integer, falling back to def
+func (e *envReader) integer(key string, def int) int {
+        v := e.get(key)
+        if v == "" {
+                return def
+        }
+        n, err := strconv.Atoi(v)
+        if err != nil {
+                e.fail(key, v, "want an integer")
+                return def
+        }
+        return n
+}
+
+// port reads a TCP port number, falling back to def, so a bad PORT is reported by name
+// at startup rather than by the listener
+func (e *envReader) port(key, def string) string {
+        v := e.get(key)
+        if v == "" {
+                return def
+        }
+        if n, err := strconv.Atoi(v); err != nil || n < 0 || n > 65535 {
+                e.fail(key, v, "want a port number between 0 and 65535")
+                return def
+        }
+        return v
+}
+
+// number reads a floating-point number, falling back to def
+func (e *envReader) number(key string, def float64) float64 {
+        v := e.get(key)
+        if v == "" {
+                return def
+        }
+        f, err := strconv.ParseFloat(v, 64)
+        if err != nil {
+                e.fail(key, v, "want a number")
+                return def
+        }
+        return f
+}
+
+// boolean reads a boolean such as true, false, 1 or 0, falling back to def
+func (e *envReader) boolean(key string, def bool) bool {
+        v := e.get(key)
+        if v == "" {
+                return def
+        }
+        b, err := strconv.ParseBool(v)
+        if err != nil {
+                e.fail(key, v, "want true or false")
+                return def
+        }
+        return b
+}
+
+// list reads a comma-separated list, dropping empty entries
+func (e *envReader) list(key string) []string {
+        var out []string
+        for _, item := range strings.Split(e.get(key), ",") {
+                if item = strings.TrimSpace(item); item != "" {
+                        out = append(out, item)
+                }
+        }
+        return out
+}
+
+// prefixes parses a comma-separated list of CIDR ranges; a bare address stands for itself
+func (e *envReader) prefixes(key string) []netip.Prefix {
+        var out []netip.Prefix
+        for _, item := range e.list(key) {
+                if !strings.Contains(item, "/") {
+                        addr, err := netip.ParseAddr(item)
+                        if err != nil {
+                                e.fail(key, item, "want a comma-separated list of CIDR ranges or addresses")
+                                continue
+                        }
+                        addr = addr.Unmap()
+                        out = append(out, netip.PrefixFrom(addr, addr.BitLen()))
+                        continue
+                }
+                p, err := netip.ParsePrefix(item)
+                if err != nil {
+                        e.fail(key, item, "want a comma-separated list of CIDR ranges or addresses")
+                        continue
+                }
+                out = append(out, p.Masked())
+        }
+        return out
+}
+
+// headers applies a comma-separated list of Name:Value pairs to defaults. A pair with an
+// empty value, such as "X-Frame-Options:", removes that default. Values cannot contain commas.
+func (e *envReader) headers(key string, defaults map[string]string) map[string]string {
+        out := make(map[string]string, len(defaults))
+        for name, value := range defaults {
+                out[http.CanonicalHeaderKey(name)] = value
+        }
+        for _, pair := range e.list(key) {
+                name, value, ok := strings.Cut(pair, ":")
+                name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+                if !ok || name == "" || !validHeaderName(name) {
+                        e.fail(key, pair, "want comma-separated Name:Value pairs")
+                        continue
+                }
+                name = http.CanonicalHeaderKey(name)
+                if value == "" {
+                        delete(out, name)
+                        continue
+                }
+                out[name] = value
+        }
+        return out
+}
+
+// validHeaderName reports whether name is an RFC 9110 token
+func validHeaderName(name string) bool {
+        for _, c := range name {
+                if c > 0x7e || c <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, c) {
+                        return false
+                }
+        }
+        return true
+}
+
+// signingKeys reads SIGNING_KEY, a single secret with id "default", and SIGNING_KEYS, a
+// comma-separated list of id:secret pairs for rotation. SIGNING_KEY_ID picks the key used
+// when a client names none; it may be omitted when there is only one key.
+func (e *envReader) signingKeys() (map[string]string, string) {
+        keys := make(map[string]string)
+        if secret := e.get("SIGNING_KEY"); secret != "" {
+                keys["default"] = secret
+        }
+        for _, pair := range e.list("SIGNING_KEYS") {
+                id, secret, ok := strings.Cut(pair, ":")
+                id = strings.TrimSpace(id)
+                if !ok || id == "" || secret == "" {
+                        e.errs = append(e.errs, errors.New("SIGNING_KEYS: want comma-separated id:secret pairs"))
+                        continue
+                }
+                if _, dup := keys[id]; dup {
+                        e.errs = append(e.errs, fmt.Errorf("SIGNING_KEYS: key id %q is defined twice", id))
+                        continue
+                }
+                keys[id] = secret
+        }
+
+        id := e.get("SIGNING_KEY_ID")
+        if id == "" && len(keys) == 1 {
+                for only := range keys {
+                        id = only
+                }
+        }
+        if len(keys) == 0 {
+                return nil, id
+        }
+        return keys, id
+}
+
+// dependencies reads DEPENDENCIES, a comma-separated list of name=url pairs
+func (e *envReader) dependencies() map[string]string {
+        deps := make(map[string]string)
+        for _, pair := range e.list("DEPENDENCIES") {
+                name, url, ok := strings.Cut(pair, "=")
+                name, url = strings.TrimSpace(name), strings.TrimSpace(url)
+                if !ok || name == "" || url == "" {
+                        e.fail("DEPENDENCIES", pair, "want comma-separated name=url pairs")
+                        continue
+                }
+                if _, dup := deps[name]; dup {
+                        e.errs = append(e.errs, fmt.Errorf("DEPENDENCIES: dependency %q is defined twice", name))
+                        continue
+                }
+                deps[name] = url
+        }
+        if len(deps) == 0 {
+                return nil
+        }
+        return deps
+}
+
+// loadConfigFile reads a flat JSON object keyed by the environment variable names, e.g.
+// {"PORT": 9090, "READ_TIMEOUT": "10s", "CORS_ALLOWED_ORIGINS": ["https://example.com"]}.
+// Values are turned into the strings the environment would carry so both sources share
+// one parser; keys are matched case-insensitively.
+func loadConfigFile(path string) (map[string]string, error) {
+        data, err := os.ReadFile(path)
+        if err != nil {
+                return nil, err
+        }
+
+        dec := json.NewDecoder(bytes.NewReader(data))
+        dec.UseNumber()
+        var raw map[string]any
+        if err := dec.Decode(&raw); err != nil {
+                return nil, fmt.Errorf("invalid JSON: %w", err)
+        }
+
+        out := make(map[string]string, len(raw))
+        for key, v := range raw {
+                str, err := configFileValue(v)
+                if err != nil {
+                        return nil, fmt.Errorf("%s: %w", key, err)
+                }
+                out[strings.ToUpper(key)] = str
+        }
+        return out, nil
+}
+
+// configFileValue renders a JSON scalar, or an array of scalars as a comma-separated list
+func configFileValue(v any) (string, error) {
+        switch v := v.(type) {
+        case nil:
+                return "", nil
+        case string:
+                return v, nil
+        case json.Number:
+                return v.String(), nil
+        case bool:
+                return strconv.FormatBool(v), nil
+        case []any:
+                items := make([]string, len(v))
+                for i, item := range v {
+                        if _, nested := item.([]any); nested {
+                                return "", errors.New("nested arrays are not supported")
+                        }
+                        str, err := configFileValue(item)
+                        if err != nil {
+                                return "", err
+                        }
+                        items[i] = str
+                }
+                return strings.Join(items, ","), nil
+        default:
+                return "", fmt.Errorf("unsupported value %T; use a string, number, boolean or array", v)
+        }
+}
+
+// listenAddr joins host and port and checks the result is a usable TCP address
+func listenAddr(host, port string) (string, error) {
+        addr := net.JoinHostPort(host, port)
+        _, p, err := net.SplitHostPort(addr)
+        if err != nil {
+                return "", err
+        }
+        if n, err := strconv.Atoi(p); err != nil || n < 0 || n > 65535 {
+                return "", fmt.Errorf("port %q must be a number between 0 and 65535", p)
+        }
+        return addr, nil
+}
+"""
+
+GO_MAIN_TEST = r"""package main
+
+import (
+        "io"
+        "log/slog"
+        "net/http"
+        "net/http/httptest"
+        "os"
+        "strconv"
+        "strings"
+        "testing"
+        "time"
+)
+
+// testTime is where every test server's frozen clock starts
+var testTime = time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+func TestMain(m *testing.M) {
+        // Handlers log through slog's default logger; keep go test output to the failures
+        slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+        os.Exit(m.Run())
+}
+
+// newTestServer loads the configuration from env as main does and builds a Server on a
+// fresh in-memory store and a frozen clock. Configuration lives in package globals, so
+// tests using it must not run in parallel.
+func newTestServer(t *testing.T, env map[string]string) *Server {
+        t.Helper()
+        return newTestServerWithStore(t, env, nil)
+}
+
+// newTestServerWithStore is newTestServer around store; a nil store gets the in-memory one
+func newTestServerWithStore(t *testing.T, env map[string]string, store Store) *Server {
+        t.Helper()
+        for key, value := range env {
+                t.Setenv(key, value)
+        }
+        cfg, err := loadConfig()
+        if err != nil {
+                t.Fatalf("loadConfig: %v", err)
+        }
+        prev := *currentConfig()
+        applyConfig(cfg)
+        t.Cleanup(func() { applyConfig(prev) })
+        responses.purge()
+
+        if store == nil {
+                store = newMessageStore(cfg.MessageStoreSize)
+        }
+        s := newServer(cfg, store, newFrozenClock(testTime))
+        t.Cleanup(s.close)
+        return s
+}
+
+// serve sends one request through h and returns the recorded response
+func serve(h http.Handler, method, target, body string, header ...string) *httptest.ResponseRecorder {
+        var r *http.Request
+        if body == "" {
+                r = httptest.NewRequest(method, target, nil)
+        } else {
+                r = httptest.NewRequest(method, target, strings.NewReader(body))
+                r.Header.Set("Content-Type", contentTypeJSON)
+        }
+        for i := 0; i+1 < len(header); i += 2 {
+                r.Header.Set(header[i], header[i+1])
+        }
+        w := httptest.NewRecorder()
+        h.ServeHTTP(w, r)
+        return w
+}
+
+// scrapeMetrics returns the /metrics page in the Prometheus text format
+func scrapeMetrics(t *testing.T, h http.Handler) string {
+        t.Helper()
+        w := serve(h, http.MethodGet, "/metrics", "")
+        if w.Code != http.StatusOK {
+                t.Fatalf("GET /metrics: status %d", w.Code)
+        }
+        return w.Body.String()
+}
+
+// metricValue finds series, a metric name with its labels, in a scraped page; a series
+// that was never observed reads as 0
+func metricValue(t *testing.T, page, series string) float64 {
+        t.Helper()
+        for _, line := range strings.Split(page, "\n") {
+                value, ok := strings.CutPrefix(line, series+" ")
+                if !ok {
+                        continue
+                }
+                f, err := strconv.ParseFloat(value, 64)
+                if err != nil {
+                        t.Fatalf("series %s: %v", series, err)
+                }
+                return f
+        }
+        return 0
+}
+
+func TestMetricsCountEchoRequests(t *testing.T) {
+        h := newTestServer(t, nil).routes()
+        const counter = `http_requests_total{path="/echo",status="200"}`
+        const latency = `http_request_duration_seconds_count{path="/echo"}`
+
+        before := scrapeMetrics(t, h)
+        if w := serve(h, http.MethodPost, "/echo", `{"message":"hi"}`); w.Code != http.StatusOK {
+                t.Fatalf("POST /echo: status %d, body %s", w.Code, w.Body)
+        }
+        after := scrapeMetrics(t, h)
+
+        if got := metricValue(t, after, counter) - metricValue(t, before, counter); got != 1 {
+                t.Errorf("%s rose by %v, want 1", counter, got)
+        }
+        if got := metricValue(t, after, latency) - metricValue(t, before, latency); got != 1 {
+                t.Errorf("%s rose by %v, want 1", latency, got)
+        }
+        for _, want := range []string{
+                "# TYPE http_requests_total counter",
+                "# TYPE http_request_duration_seconds histogram",
+                `http_request_duration_seconds_bucket{path="/echo",le="+Inf"}`,
+        } {
+                if !strings.Contains(after, want) {
+                        t.Errorf("/metrics lacks %q", want)
+                }
+        }
+}
+
+func TestPreserveNumbersRoundTripsLargeIntegers(t *testing.T) {
+        // 2^64-1 has more digits than float64 can hold: through float64 it would come back as 18446744073709551616
+        const id = "18446744073709551615"
+        if f, _ := strconv.ParseFloat(id, 64); strconv.FormatFloat(f, 'f', -1, 64) == id {
+                t.Fatalf("%s survives float64, so it cannot show the precision loss", id)
+        }
+        body := `{"message":"hi","metadata":{"id":` + id + `,"ratio":1.50,"name":"x"}}`
+
+        h := newTestServer(t, map[string]string{"PRESERVE_NUMBERS": "true"}).routes()
+        w := serve(h, http.MethodPost, "/echo", body)
+        if w.Code != http.StatusOK {
+                t.Fatalf("POST /echo: status %d, body %s", w.Code, w.Body)
+        }
+        for _, want := range []string{`"id":` + id, `"ratio":1.50`, `"name":"x"`} {
+                if !strings.Contains(w.Body.String(), want) {
+                        t.Errorf("response %s lacks %s", w.Body, want)
+                }
+        }
+
+        // The stored copy keeps the number too
+        w = serve(h, http.MethodGet, "/messages/1", "")
+        if !strings.Contains(w.Body.String(), `"id":`+id) {
+                t.Errorf("GET /messages/1 = %s, want metadata id %s", w.Body, id)
+        }
+}
+
+func TestNumericMetadataRejectedByDefault(t *testing.T) {
+        for _, env := range []map[string]string{nil, {"ECHO_SCHEMA_VALIDATION": "false"}} {
+                h := newTestServer(t, env).routes()
+                w := serve(h, http.MethodPost, "/echo", `{"message":"hi","metadata":{"id":42}}`)
+                if w.Code != http.StatusBadRequest {
+                        t.Errorf("env %v: numeric metadata got status %d, want 400", env, w.Code)
+                }
+        }
+}
+"""
+
+GO_MIDDLEWARE_TEST = r"""package main
+
+import (
+        "encoding/json"
+        "net/http"
+        "net/http/httptest"
+        "strings"
+        "testing"
+)
+
+func TestRecoverMiddlewareAnswersPanicsWith500(t *testing.T) {
+        mux := http.NewServeMux()
+        mux.HandleFunc("/panic", func(http.ResponseWriter, *http.Request) { panic("boom") })
+        mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+                writeJSON(w, r, http.StatusOK, map[string]bool{"ok": true})
+        })
+        srv := httptest.NewServer(requestIDMiddleware(recoverMiddleware(mux)))
+        defer srv.Close()
+
+        req, _ := http.NewRequest(http.MethodGet, srv.URL+"/panic", nil)
+        req.Header.Set(requestIDHeader, "panic-test-1")
+        resp, err := srv.Client().Do(req)
+        if err != nil {
+                t.Fatalf("GET /panic: %v", err)
+        }
+        defer resp.Body.Close()
+        if resp.StatusCode != http.StatusInternalServerError {
+                t.Fatalf("status = %d, want 500", resp.StatusCode)
+        }
+        if ct := resp.Header.Get("Content-Type"); ct != contentTypeJSON {
+                t.Errorf("Content-Type = %q, want JSON", ct)
+        }
+        var body ErrorResponse
+        if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+                t.Fatalf("decoding error body: %v", err)
+        }
+        if body.Code != errInternal {
+                t.Errorf("code = %q, want %q", body.Code, errInternal)
+        }
+        if body.RequestID != "panic-test-1" {
+                t.Errorf("request_id = %q, want panic-test-1", body.RequestID)
+        }
+
+        // The panic was contained, so the same server goes on answering
+        resp, err = srv.Client().Get(srv.URL + "/ok")
+        if err != nil {
+                t.Fatalf("GET /ok after panic: %v", err)
+        }
+        resp.Body.Close()
+        if resp.StatusCode != http.StatusOK {
+                t.Errorf("GET /ok after panic: status = %d, want 200", resp.StatusCode)
+        }
+}
+
+func TestCORSCoversPreflightsAndRefusals(t *testing.T) {
+        h := newTestServer(t, map[string]string{
+                "CORS_ALLOWED_ORIGINS": "https://app.example",
+                "BASIC_AUTH_USER":      "admin",
+                "BASIC_AUTH_PASS":      "secret",
+                "PROTECTED_PATHS":      "/echo",
+                "MAX_URL_LENGTH":       "64",
+        }).routes()
+        const origin = "https://app.example"
+
+        w := serve(h, http.MethodOptions, "/echo", "",
+                "Origin", origin,
+                "Access-Control-Request-Method", http.MethodPost,
+                "Access-Control-Request-Headers", "Authorization, Content-Type")
+        if w.Code != http.StatusNoContent {
+                t.Fatalf("preflight of a protected path: status %d, want 204", w.Code)
+        }
+        if got := w.Header().Get("Access-Control-Allow-Origin"); got != origin {
+                t.Errorf("preflight: Access-Control-Allow-Origin = %q, want %q", got, origin)
+        }
+        if got := w.Header().Get("Access-Control-Allow-Headers"); got != "Authorization, Content-Type" {
+                t.Errorf("preflight: Access-Control-Allow-Headers = %q", got)
+        }
+
+        refusals := []struct {
+                name   string
+                target string
+                status int
+        }{
+                {"missing credentials", "/echo", http.StatusUnauthorized},
+                {"URL too long", "/echo?pad=" + strings.Repeat("x", 64), http.StatusRequestURITooLong},
+        }
+        for _, tt := range refusals {
+                w := serve(h, http.MethodPost, tt.target, `{"message":"hi"}`, "Origin", origin)
+                if w.Code != tt.status {
+                        t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+                }
+                if got := w.Header().Get("Access-Control-Allow-Origin"); got != origin {
+                        t.Errorf("%s: Access-Control-Allow-Origin = %q, want %q", tt.name, got, origin)
+                }
+        }
+}
+"""
+
+GO_RATELIMIT_TEST = r"""package main
+
+import (
+        "net/http"
+        "net/http/httptest"
+        "net/netip"
+        "runtime"
+        "strings"
+        "testing"
+        "time"
+)
+
+// waitForGoroutines polls until at most n goroutines are running
+func waitForGoroutines(t *testing.T, n int) {
+        t.Helper()
+        deadline := time.Now().Add(2 * time.Second)
+        for runtime.NumGoroutine() > n {
+                if time.Now().After(deadline) {
+                        t.Fatalf("%d goroutines running, want at most %d", runtime.NumGoroutine(), n)
+                }
+                time.Sleep(time.Millisecond)
+        }
+}
+
+// echoFrom posts a message to /echo as the peer at remoteAddr
+func echoFrom(h http.Handler, remoteAddr string, header ...string) *httptest.ResponseRecorder {
+        r := httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader(`{"message":"hi"}`))
+        r.Header.Set("Content-Type", contentTypeJSON)
+        r.RemoteAddr = remoteAddr
+        for i := 0; i+1 < len(header); i += 2 {
+                r.Header.Set(header[i], header[i+1])
+        }
+        w := httptest.NewRecorder()
+        h.ServeHTTP(w, r)
+        return w
+}
+
+func TestRateLimitPerClientIP(t *testing.T) {
+        h := newTestServer(t, map[string]string{
+                "RATE_LIMIT_RPS":   "0.5",
+                "RATE_LIMIT_BURST": "2",
+        }).routes()
+
+        for i := 0; i < 2; i++ {
+                if w := echoFrom(h, "192.0.2.1:4000"); w.Code != http.StatusOK {
+                        t.Fatalf("request %d within the burst: status %d", i+1, w.Code)
+                }
+        }
+        w := echoFrom(h, "192.0.2.1:4000")
+        if w.Code != http.StatusTooManyRequests {
+                t.Fatalf("request past the burst: status %d, want 429", w.Code)
+        }
+        if got := w.Header().Get("Retry-After"); got != "2" {
+                t.Errorf("Retry-After = %q, want 2 at 0.5 requests per second", got)
+        }
+        if !strings.Contains(w.Body.String(), errRateLimited) {
+                t.Errorf("body %s lacks code %q", w.Body, errRateLimited)
+        }
+
+        // Each client has its own bucket, so another address is unaffected
+        if w := echoFrom(h, "192.0.2.2:4000"); w.Code != http.StatusOK {
+                t.Errorf("second client: status %d, want 200", w.Code)
+        }
+
+        // Nor does a forged X-Forwarded-For from an untrusted peer buy a fresh bucket
+        if w := echoFrom(h, "192.0.2.1:4000", "X-Forwarded-For", "203.0.113.7"); w.Code != http.StatusTooManyRequests {
+                t.Errorf("spoofed X-Forwarded-For: status %d, want 429", w.Code)
+        }
+}
+
+func TestClientIPTrustsForwardingHeadersOnlyFromProxies(t *testing.T) {
+        prev := trustedProxies
+        trustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+        t.Cleanup(func() { trustedProxies = prev })
+
+        tests := []struct {
+                name       string
+                remoteAddr string
+                header     map[string]string
+                want       string
+        }{
+                {"direct client", "192.0.2.1:4000", nil, "192.0.2.1"},
+                {"spoofed X-Forwarded-For from untrusted peer", "192.0.2.1:4000",
+                        map[string]string{"X-Forwarded-For": "203.0.113.7"}, "192.0.2.1"},
+                {"spoofed X-Real-IP from untrusted peer", "192.0.2.1:4000",
+                        map[string]string{"X-Real-IP": "203.0.113.7"}, "192.0.2.1"},
+                {"trusted proxy", "10.0.0.5:4000",
+                        map[string]string{"X-Forwarded-For": "203.0.113.7"}, "203.0.113.7"},
+                {"client-supplied hop left of the real client", "10.0.0.5:4000",
+                        map[string]string{"X-Forwarded-For": "198.51.100.1, 203.0.113.7, 10.0.0.9"}, "203.0.113.7"},
+                {"X-Real-IP from trusted proxy", "10.0.0.5:4000",
+                        map[string]string{"X-Real-IP": "203.0.113.7"}, "203.0.113.7"},
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        r := httptest.NewRequest(http.MethodGet, "/", nil)
+                        r.RemoteAddr = tt.remoteAddr
+                        for name, value := range tt.header {
+                                r.Header.Set(name, value)
+                        }
+                        if got := clientIP(r); got != tt.want {
+                                t.Errorf("clientIP = %q, want %q", got, tt.want)
+                        }
+                })
+        }
+}
+
+func TestRateLimiterStopEndsEviction(t *testing.T) {
+        before := runtime.NumGoroutine()
+        for i := 0; i < 20; i++ {
+                l := newIPRateLimiter(1, 1)
+                l.stop()
+                l.stop()
+        }
+        waitForGoroutines(t, before)
+}
+
+func TestServerCloseAndReloadLeaveNoGoroutines(t *testing.T) {
+        before := runtime.NumGoroutine()
+        for i := 0; i < 10; i++ {
+                s := newServer(defaultConfig(), newMessageStore(0), systemClock{})
+                s.close()
+        }
+        waitForGoroutines(t, before)
+
+        // A reload retunes the running Server's limiter instead of building another
+        s := newTestServer(t, map[string]string{"RATE_LIMIT_RPS": "5"})
+        before = runtime.NumGoroutine()
+        for i := 0; i < 10; i++ {
+                s.reloadConfig()
+        }
+        waitForGoroutines(t, before)
+}
+"""
+
+GO_CLIENT_TEST = r"""package client
+
+import (
+        "context"
+        "errors"
+        "net/http"
+        "net/http/httptest"
+        "sync"
+        "testing"
+        "time"
+)
+
+// flakyService answers each request with the next status in statuses, repeating the last
+// one, and records the Idempotency-Key every attempt carried
+type flakyService struct {
+        mu       sync.Mutex
+        statuses []int
+        header   http.Header
+        keys     []string
+}
+
+func (f *flakyService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+        f.mu.Lock()
+        status := f.statuses[min(len(f.keys), len(f.statuses)-1)]
+        f.keys = append(f.keys, r.Header.Get("Idempotency-Key"))
+        f.mu.Unlock()
+
+        for name, values := range f.header {
+                w.Header()[name] = values
+        }
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(status)
+        if status == http.StatusOK {
+                w.Write([]byte(`{"message":"hi","service":"test"}`))
+                return
+        }
+        w.Write([]byte(`{"code":"server_busy","message":"try again","request_id":"req-1"}`))
+}
+
+func (f *flakyService) attempts() []string {
+        f.mu.Lock()
+        defer f.mu.Unlock()
+        return append([]string(nil), f.keys...)
+}
+
+func TestEchoRetriesWithOneIdempotencyKey(t *testing.T) {
+        svc := &flakyService{statuses: []int{http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusOK}}
+        srv := httptest.NewServer(svc)
+        defer srv.Close()
+
+        e, err := New(srv.URL, WithRetries(3, time.Millisecond)).Echo(context.Background(), "hi")
+        if err != nil {
+                t.Fatalf("Echo: %v", err)
+        }
+        if e.Message != "hi" {
+                t.Errorf("Message = %q, want hi", e.Message)
+        }
+        keys := svc.attempts()
+        if len(keys) != 3 {
+                t.Fatalf("attempts = %d, want 3", len(keys))
+        }
+        if keys[0] == "" || keys[1] != keys[0] || keys[2] != keys[0] {
+                t.Errorf("Idempotency-Key per attempt = %q, want one non-empty key throughout", keys)
+        }
+}
+
+func TestRetriesGiveUpWithAPIError(t *testing.T) {
+        svc := &flakyService{statuses: []int{http.StatusServiceUnavailable}}
+        srv := httptest.NewServer(svc)
+        defer srv.Close()
+
+        _, err := New(srv.URL, WithRetries(2, time.Millisecond)).Health(context.Background())
+        var apiErr *APIError
+        if !errors.As(err, &apiErr) {
+                t.Fatalf("err = %v, want *APIError", err)
+        }
+        want := APIError{StatusCode: http.StatusServiceUnavailable, Code: "server_busy", Message: "try again", RequestID: "req-1"}
+        if *apiErr != want {
+                t.Errorf("APIError = %+v, want %+v", *apiErr, want)
+        }
+        if got := len(svc.attempts()); got != 3 {
+                t.Errorf("attempts = %d, want 3", got)
+        }
+}
+
+func TestNoRetryWithoutOptionOrOnClientErrors(t *testing.T) {
+        tests := []struct {
+                name   string
+                status int
+                opts   []Option
+        }{
+                {"retries off", http.StatusServiceUnavailable, nil},
+                {"bad request", http.StatusBadRequest, []Option{WithRetries(3, time.Millisecond)}},
+                {"unauthorized", http.StatusUnauthorized, []Option{WithRetries(3, time.Millisecond)}},
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        svc := &flakyService{statuses: []int{tt.status}}
+                        srv := httptest.NewServer(svc)
+                        defer srv.Close()
+
+                        _, err := New(srv.URL, tt.opts...).Echo(context.Background(), "hi")
+                        var apiErr *APIError
+                        if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.status {
+                                t.Fatalf("err = %v, want *APIError with status %d", err, tt.status)
+                        }
+                        keys := svc.attempts()
+                        if len(keys) != 1 {
+                                t.Errorf("attempts = %d, want 1", len(keys))
+                        }
+                        if tt.opts == nil && keys[0] != "" {
+                                t.Errorf("Idempotency-Key = %q without retries, want none", keys[0])
+                        }
+                })
+        }
+}
+
+func TestRetryHonorsRetryAfter(t *testing.T) {
+        svc := &flakyService{
+                statuses: []int{http.StatusTooManyRequests, http.StatusOK},
+                header:   http.Header{"Retry-After": {"1"}},
+        }
+        srv := httptest.NewServer(svc)
+        defer srv.Close()
+
+        start := time.Now()
+        if _, err := New(srv.URL, WithRetries(1, time.Millisecond)).Echo(context.Background(), "hi"); err != nil {
+                t.Fatalf("Echo: %v", err)
+        }
+        if elapsed := time.Since(start); elapsed < time.Second {
+                t.Errorf("retried after %s, want the 1s Retry-After to be waited out", elapsed)
+        }
+}
+
+func TestContextEndsBackoff(t *testing.T) {
+        svc := &flakyService{
+                statuses: []int{http.StatusServiceUnavailable},
+                header:   http.Header{"Retry-After": {"60"}},
+        }
+        srv := httptest.NewServer(svc)
+        defer srv.Close()
+
+        ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+        defer cancel()
+        start := time.Now()
+        _, err := New(srv.URL, WithRetries(3, time.Millisecond)).Echo(ctx, "hi")
+        if !errors.Is(err, context.DeadlineExceeded) {
+                t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+        }
+        if elapsed := time.Since(start); elapsed > 5*time.Second {
+                t.Errorf("returned after %s, want the wait cut short by the context", elapsed)
+        }
+        if got := len(svc.attempts()); got != 1 {
+                t.Errorf("attempts = %d, want 1", got)
+        }
+}
+
+func TestContextCancelsRequestInFlight(t *testing.T) {
+        srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                <-r.Context().Done()
+        }))
+        defer srv.Close()
+
+        ctx, cancel := context.WithCancel(context.Background())
+        time.AfterFunc(20*time.Millisecond, cancel)
+        _, err := New(srv.URL, WithRetries(3, time.Millisecond)).Health(ctx)
+        if !errors.Is(err, context.Canceled) {
+                t.Fatalf("err = %v, want context.Canceled", err)
+        }
+}
+"""
+
+GO_MAIN_CLIENT_TEST = r"""package main
+
+import (
+        "context"
+        "errors"
+        "net/http"
+        "net/http/httptest"
+        "strings"
+        "testing"
+
+        "aurora-service/client"
+)
+
+func TestClientAgainstHandlers(t *testing.T) {
+        srv := httptest.NewServer(newTestServer(t, nil).routes())
+        defer srv.Close()
+        c := client.New(srv.URL, client.WithRetries(2, 0))
+        ctx := context.Background()
+
+        h, err := c.Health(ctx)
+        if err != nil {
+                t.Fatalf("Health: %v", err)
+        }
+        if !h.OK || h.Service != serviceName || !h.Timestamp.Equal(testTime) {
+                t.Errorf("Health = %+v, want ok from %s at %s", h, serviceName, testTime)
+        }
+
+        e, err := c.Echo(ctx, "hello")
+        if err != nil {
+                t.Fatalf("Echo: %v", err)
+        }
+        if e.Message != "hello" || e.RequestID == "" || !e.ReceivedAt.Equal(testTime) {
+                t.Errorf("Echo = %+v, want the message stamped with a request ID at %s", e, testTime)
+        }
+
+        _, err = c.Echo(ctx, strings.Repeat("x", maxMessageLen+1))
+        var apiErr *client.APIError
+        if !errors.As(err, &apiErr) {
+                t.Fatalf("oversized Echo: err = %v, want *client.APIError", err)
+        }
+        if apiErr.StatusCode != http.StatusBadRequest || apiErr.Code != errValidation || apiErr.RequestID == "" {
+                t.Errorf("oversized Echo: APIError = %+v, want 400 %s with a request ID", *apiErr, errValidation)
+        }
+}
+"""
+
+GO_SERVER_TEST = r"""package main
+
+import (
+        "context"
+        "crypto/tls"
+        "encoding/json"
+        "errors"
+        "io"
+        "net"
+        "net/http"
+        "net/http/httptest"
+        "strings"
+        "sync"
+        "testing"
+
+        "golang.org/x/net/http2"
+)
+
+func TestH2CServesPriorKnowledgeHTTP2(t *testing.T) {
+        handler, h2s := newTestServer(t, map[string]string{"ENABLE_H2C": "true"}).publicHandler()
+        if h2s == nil {
+                t.Fatal("ENABLE_H2C=true: no HTTP/2 server to configure")
+        }
+        srv := httptest.NewServer(handler)
+        defer srv.Close()
+
+        // Prior knowledge: the client speaks HTTP/2 from the first byte, over plain TCP
+        h2 := &http.Client{Transport: &http2.Transport{
+                AllowHTTP: true,
+                DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+                        var d net.Dialer
+                        return d.DialContext(ctx, network, addr)
+                },
+        }}
+        resp, err := h2.Get(srv.URL + "/whoami")
+        if err != nil {
+                t.Fatalf("HTTP/2 GET /whoami: %v", err)
+        }
+        defer resp.Body.Close()
+        if resp.ProtoMajor != 2 {
+                t.Fatalf("response protocol = %s, want HTTP/2", resp.Proto)
+        }
+        var who WhoAmI
+        if err := json.NewDecoder(resp.Body).Decode(&who); err != nil {
+                t.Fatalf("decoding /whoami: %v", err)
+        }
+        if who.Protocol != "HTTP/2.0" || who.Scheme != "h2c" {
+                t.Errorf("handler saw protocol %q scheme %q, want HTTP/2.0 over h2c", who.Protocol, who.Scheme)
+        }
+
+        // HTTP/1.1 clients on the same listener are unaffected
+        resp, err = srv.Client().Get(srv.URL + "/health")
+        if err != nil {
+                t.Fatalf("HTTP/1.1 GET /health: %v", err)
+        }
+        resp.Body.Close()
+        if resp.ProtoMajor != 1 || resp.StatusCode != http.StatusOK {
+                t.Errorf("HTTP/1.1 GET /health: %s %d, want HTTP/1.1 200", resp.Proto, resp.StatusCode)
+        }
+}
+
+func TestH2COffByDefault(t *testing.T) {
+        if _, h2s := newTestServer(t, nil).publicHandler(); h2s != nil {
+                t.Error("h2c is on without ENABLE_H2C")
+        }
+}
+
+// fakeStore is a Store holding canned messages that fails every call once err is set
+type fakeStore struct {
+        mu       sync.Mutex
+        messages []StoredMessage
+        err      error
+}
+
+func (f *fakeStore) Save(_ context.Context, e Echo) (StoredMessage, error) {
+        f.mu.Lock()
+        defer f.mu.Unlock()
+        if f.err != nil {
+                return StoredMessage{}, f.err
+        }
+        msg := StoredMessage{ID: int64(100 + len(f.messages)), Echo: e}
+        f.messages = append(f.messages, msg)
+        return msg, nil
+}
+
+func (f *fakeStore) Get(_ context.Context, id int64) (StoredMessage, bool, error) {
+        f.mu.Lock()
+        defer f.mu.Unlock()
+        if f.err != nil {
+                return StoredMessage{}, false, f.err
+        }
+        for _, msg := range f.messages {
+                if msg.ID == id {
+                        return msg, true, nil
+                }
+        }
+        return StoredMessage{}, false, nil
+}
+
+func (f *fakeStore) List(_ context.Context, limit int) ([]StoredMessage, error) {
+        f.mu.Lock()
+        defer f.mu.Unlock()
+        if f.err != nil {
+                return nil, f.err
+        }
+        out := append([]StoredMessage(nil), f.messages...)
+        if limit < len(out) {
+                out = out[:limit]
+        }
+        return out, nil
+}
+
+func TestRoutesUseInjectedStore(t *testing.T) {
+        canned := []StoredMessage{{ID: 7, Echo: Echo{Message: "canned"}}}
+        tests := []struct {
+                name     string
+                storeErr error
+                method   string
+                target   string
+                body     string
+                status   int
+                contains string
+        }{
+                {"echo saves through the store", nil, http.MethodPost, "/echo", `{"message":"hi"}`, http.StatusOK, `"message":"hi"`},
+                {"store failure on echo", errors.New("disk full"), http.MethodPost, "/echo", `{"message":"hi"}`, http.StatusInternalServerError, errInternal},
+                {"list reads the store", nil, http.MethodGet, "/messages", "", http.StatusOK, `"message":"canned"`},
+                {"get reads the store", nil, http.MethodGet, "/messages/7", "", http.StatusOK, `"id":7`},
+                {"get of an unknown id", nil, http.MethodGet, "/messages/8", "", http.StatusNotFound, errNotFound},
+                {"store failure on list", errors.New("disk full"), http.MethodGet, "/messages", "", http.StatusInternalServerError, errInternal},
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        store := &fakeStore{messages: append([]StoredMessage(nil), canned...), err: tt.storeErr}
+                        srv := httptest.NewServer(newTestServerWithStore(t, nil, store).routes())
+                        defer srv.Close()
+
+                        req, err := http.NewRequest(tt.method, srv.URL+tt.target, strings.NewReader(tt.body))
+                        if err != nil {
+                                t.Fatal(err)
+                        }
+                        if tt.body != "" {
+                                req.Header.Set("Content-Type", contentTypeJSON)
+                        }
+                        resp, err := srv.Client().Do(req)
+                        if err != nil {
+                                t.Fatalf("%s %s: %v", tt.method, tt.target, err)
+                        }
+                        defer resp.Body.Close()
+                        body, _ := io.ReadAll(resp.Body)
+
+                        if resp.StatusCode != tt.status {
+                                t.Errorf("status %d, want %d; body %s", resp.StatusCode, tt.status, body)
+                        }
+                        if !strings.Contains(string(body), tt.contains) {
+                                t.Errorf("body %s lacks %s", body, tt.contains)
+                        }
+                })
+        }
+
+        // A successful echo lands in the injected store and nowhere else
+        store := &fakeStore{}
+        h := newTestServerWithStore(t, nil, store).routes()
+        serve(h, http.MethodPost, "/echo", `{"message":"kept"}`)
+        if len(store.messages) != 1 || store.messages[0].Message != "kept" {
+                t.Errorf("store holds %+v, want the echoed message", store.messages)
+        }
+}
+
+func TestServersKeepTheirOwnState(t *testing.T) {
+        a := newTestServer(t, map[string]string{"FORWARD_URL": "http://127.0.0.1:1"})
+        b := newTestServer(t, map[string]string{"FORWARD_URL": ""})
+        ha, hb := a.routes(), b.routes()
+        a.markReady()
+
+        // Each banner advertises the routes its own Server registered
+        banner := func(h http.Handler) string { return serve(h, http.MethodGet, "/", "").Body.String() }
+        if !strings.Contains(banner(ha), "/echo/forward") {
+                t.Errorf("server with FORWARD_URL does not advertise /echo/forward: %s", banner(ha))
+        }
+        if strings.Contains(banner(hb), "/echo/forward") {
+                t.Errorf("server without FORWARD_URL advertises /echo/forward: %s", banner(hb))
+        }
+
+        if w := serve(ha, http.MethodGet, "/ready", ""); w.Code != http.StatusOK {
+                t.Errorf("ready server: /ready %d, want 200", w.Code)
+        }
+        if w := serve(hb, http.MethodGet, "/ready", ""); w.Code != http.StatusServiceUnavailable {
+                t.Errorf("unready server: /ready %d, want 503", w.Code)
+        }
+
+        // Requests to one server do not show up in the other's /stats
+        serve(ha, http.MethodPost, "/echo", `{"message":"hi"}`)
+        var stats Stats
+        if err := json.Unmarshal(serve(hb, http.MethodGet, "/stats", "").Body.Bytes(), &stats); err != nil {
+                t.Fatalf("decoding /stats: %v", err)
+        }
+        if n := stats.Endpoints["/echo"].Count; n != 0 {
+                t.Errorf("other server's /stats counted %d echoes, want 0", n)
+        }
+}
+"""
+
+GO_IDEMPOTENCY_TEST = r"""package main
+
+import (
+        "context"
+        "net/http"
+        "net/http/httptest"
+        "runtime"
+        "strings"
+        "testing"
+        "time"
+)
+
+// postEcho posts body to target with key as the Idempotency-Key, as the peer at remoteAddr
+func postEcho(h http.Handler, target, body, key, remoteAddr string, header ...string) *httptest.ResponseRecorder {
+        r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+        r.Header.Set("Content-Type", contentTypeJSON)
+        r.Header.Set(idempotencyKeyHeader, key)
+        r.RemoteAddr = remoteAddr
+        for i := 0; i+1 < len(header); i += 2 {
+                r.Header.Set(header[i], header[i+1])
+        }
+        w := httptest.NewRecorder()
+        h.ServeHTTP(w, r)
+        return w
+}
+
+// storedMessages counts what s's store holds
+func storedMessages(t *testing.T, s *Server) int {
+        t.Helper()
+        msgs, err := s.store.List(context.Background(), 100)
+        if err != nil {
+                t.Fatalf("listing messages: %v", err)
+        }
+        return len(msgs)
+}
+
+func TestIdempotencyKeyReplaysFirstResponse(t *testing.T) {
+        s := newTestServer(t, nil)
+        h := s.routes()
+        const body = `{"message":"pay once"}`
+
+        first := postEcho(h, "/echo", body, "key-1", "192.0.2.1:4000")
+        second := postEcho(h, "/echo", body, "key-1", "192.0.2.1:4000")
+        if first.Code != http.StatusOK || second.Code != http.StatusOK {
+                t.Fatalf("statuses = %d, %d, want 200 twice", first.Code, second.Code)
+        }
+        if second.Body.String() != first.Body.String() {
+                t.Errorf("replayed body differs:\nfirst  %s\nsecond %s", first.Body, second.Body)
+        }
+        if first.Header().Get("Idempotent-Replayed") != "" || second.Header().Get("Idempotent-Replayed") != "true" {
+                t.Errorf("Idempotent-Replayed = %q, %q, want only the second marked",
+                        first.Header().Get("Idempotent-Replayed"), second.Header().Get("Idempotent-Replayed"))
+        }
+        if n := storedMessages(t, s); n != 1 {
+                t.Errorf("store holds %d messages, want 1", n)
+        }
+}
+
+func TestIdempotencyKeyReusedForDifferentRequest(t *testing.T) {
+        tests := []struct {
+                name   string
+                target string
+                body   string
+                header []string
+        }{
+                {"different body", "/echo", `{"message":"pay twice"}`, nil},
+                {"different content type", "/echo", `{"message":"pay once"}`, []string{"Content-Type", "application/json; charset=utf-8"}},
+                {"checksum requested", "/echo?checksum=sha256", `{"message":"pay once"}`, nil},
+                {"different representation", "/echo", `{"message":"pay once"}`, []string{"Accept", contentTypeXML}},
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        s := newTestServer(t, nil)
+                        h := s.routes()
+                        if w := postEcho(h, "/echo", `{"message":"pay once"}`, "key-1", "192.0.2.1:4000"); w.Code != http.StatusOK {
+                                t.Fatalf("first request: status %d", w.Code)
+                        }
+                        w := postEcho(h, tt.target, tt.body, "key-1", "192.0.2.1:4000", tt.header...)
+                        if w.Code != http.StatusUnprocessableEntity {
+                                t.Fatalf("reused key: status %d, want 422; body %s", w.Code, w.Body)
+                        }
+                        if !strings.Contains(w.Body.String(), errIdempotencyKeyReused) {
+                                t.Errorf("body %s lacks code %q", w.Body, errIdempotencyKeyReused)
+                        }
+                        if n := storedMessages(t, s); n != 1 {
+                                t.Errorf("store holds %d messages, want 1", n)
+                        }
+                })
+        }
+}
+
+func TestIdempotencyKeyScopedToClient(t *testing.T) {
+        s := newTestServer(t, nil)
+        h := s.routes()
+        const body = `{"message":"pay once"}`
+
+        postEcho(h, "/echo", body, "key-1", "192.0.2.1:4000")
+        w := postEcho(h, "/echo", body, "key-1", "192.0.2.2:4000")
+        if w.Code != http.StatusOK || w.Header().Get("Idempotent-Replayed") != "" {
+                t.Errorf("another client's key: status %d, replayed %q, want a fresh 200",
+                        w.Code, w.Header().Get("Idempotent-Replayed"))
+        }
+        if n := storedMessages(t, s); n != 2 {
+                t.Errorf("store holds %d messages, want 2", n)
+        }
+}
+
+func TestIdempotencyCacheStopEndsEviction(t *testing.T) {
+        before := runtime.NumGoroutine()
+        for i := 0; i < 20; i++ {
+                c := newIdempotencyCache(time.Minute)
+                c.stop()
+                c.stop()
+        }
+        waitForGoroutines(t, before)
+
+        var disabled *idempotencyCache
+        disabled.stop()
+}
+"""
+
+GO_FORWARD_TEST = r"""package main
+
+import (
+        "context"
+        "encoding/json"
+        "io"
+        "net/http"
+        "net/http/httptest"
+        "strings"
+        "sync"
+        "sync/atomic"
+        "testing"
+        "time"
+)
+
+// downstream is an /echo stand-in that holds every request until release is closed and
+// records the X-Request-ID each one carried
+type downstream struct {
+        hits    atomic.Int32
+        release chan struct{}
+        fail    atomic.Bool
+
+        mu         sync.Mutex
+        requestIDs []string
+}
+
+func newDownstream(t *testing.T) (*downstream, *httptest.Server) {
+        d := &downstream{release: make(chan struct{})}
+        srv := httptest.NewServer(d)
+        t.Cleanup(srv.Close)
+        return d, srv
+}
+
+func (d *downstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+        d.hits.Add(1)
+        d.mu.Lock()
+        d.requestIDs = append(d.requestIDs, r.Header.Get(requestIDHeader))
+        d.mu.Unlock()
+        <-d.release
+        if d.fail.Load() {
+                http.Error(w, "down", http.StatusInternalServerError)
+                return
+        }
+        w.Header().Set("Content-Type", contentTypeJSON)
+        w.Write([]byte(`{"message":"hi"}`))
+}
+
+// waitForHits polls until d has been hit n times
+func (d *downstream) waitForHits(t *testing.T, n int32) {
+        t.Helper()
+        deadline := time.Now().Add(2 * time.Second)
+        for d.hits.Load() < n {
+                if time.Now().After(deadline) {
+                        t.Fatalf("downstream hit %d times, want %d", d.hits.Load(), n)
+                }
+                time.Sleep(time.Millisecond)
+        }
+}
+
+func testForwarder(url string) *forwarder {
+        cfg := defaultConfig()
+        cfg.ForwardURL = url
+        return newForwarder(cfg, &breakerRegistry{})
+}
+
+// forwardAll makes n concurrent calls of payload, the i-th one as requestID(i)
+func forwardAll(f *forwarder, n int, requestID func(i int) string) ([]forwardResult, []error) {
+        results := make([]forwardResult, n)
+        errs := make([]error, n)
+        var wg sync.WaitGroup
+        for i := 0; i < n; i++ {
+                wg.Add(1)
+                go func(i int) {
+                        defer wg.Done()
+                        ctx := context.WithValue(context.Background(), requestIDKey, requestID(i))
+                        results[i], errs[i] = f.call(ctx, forwardRequest{Message: "hi"}, http.Header{})
+                }(i)
+        }
+        wg.Wait()
+        return results, errs
+}
+
+func TestForwardCoalescesIdenticalCalls(t *testing.T) {
+        d, srv := newDownstream(t)
+        f := testForwarder(srv.URL)
+        const n = 8
+
+        done := make(chan struct{})
+        var results []forwardResult
+        var errs []error
+        go func() {
+                results, errs = forwardAll(f, n, func(int) string { return "fwd-1" })
+                close(done)
+        }()
+        d.waitForHits(t, 1)
+        // Give the other callers time to join the call in flight before it completes
+        time.Sleep(50 * time.Millisecond)
+        close(d.release)
+        <-done
+
+        if got := d.hits.Load(); got != 1 {
+                t.Errorf("downstream hit %d times, want 1", got)
+        }
+        for i := range results {
+                if errs[i] != nil {
+                        t.Fatalf("call %d: %v", i, errs[i])
+                }
+                if string(results[i].body) != `{"message":"hi"}` {
+                        t.Errorf("call %d: body %s", i, results[i].body)
+                }
+        }
+        // Every waiter owns its body: scribbling on one leaves the rest intact
+        results[0].body[0] = 'X'
+        for i := 1; i < n; i++ {
+                if results[i].body[0] != '{' {
+                        t.Errorf("call %d shares its body with call 0", i)
+                }
+        }
+}
+
+func TestForwardDoesNotCoalesceAcrossRequestIDs(t *testing.T) {
+        d, srv := newDownstream(t)
+        f := testForwarder(srv.URL)
+        const n = 4
+
+        done := make(chan struct{})
+        go func() {
+                forwardAll(f, n, func(i int) string { return "fwd-" + string(rune('a'+i)) })
+                close(done)
+        }()
+        // All n reach downstream while the first is still held, so none waited on another
+        d.waitForHits(t, n)
+        close(d.release)
+        <-done
+
+        seen := make(map[string]bool)
+        for _, id := range d.requestIDs {
+                seen[id] = true
+        }
+        if len(seen) != n {
+                t.Errorf("downstream saw request IDs %q, want %d distinct", d.requestIDs, n)
+        }
+}
+
+func TestForwardDoesNotCacheErrors(t *testing.T) {
+        d, srv := newDownstream(t)
+        close(d.release)
+        f := testForwarder(srv.URL)
+        ctx := context.WithValue(context.Background(), requestIDKey, "fwd-1")
+
+        d.fail.Store(true)
+        if _, err := f.call(ctx, forwardRequest{Message: "hi"}, http.Header{}); err == nil {
+                t.Fatal("call against a failing downstream succeeded")
+        }
+        d.fail.Store(false)
+        if _, err := f.call(ctx, forwardRequest{Message: "hi"}, http.Header{}); err != nil {
+                t.Fatalf("call after downstream recovered: %v", err)
+        }
+        if got := d.hits.Load(); got != 2 {
+                t.Errorf("downstream hit %d times, want 2", got)
+        }
+}
+
+// forwardingServer serves the routes of a Server forwarding to a stub running handler
+func forwardingServer(t *testing.T, env map[string]string, handler http.HandlerFunc) http.Handler {
+        t.Helper()
+        stub := httptest.NewServer(handler)
+        t.Cleanup(stub.Close)
+        vars := map[string]string{"FORWARD_URL": stub.URL}
+        for k, v := range env {
+                vars[k] = v
+        }
+        return newTestServer(t, vars).routes()
+}
+
+// forwardEcho posts a message to /echo/forward as request fwd-1
+func forwardEcho(h http.Handler) *httptest.ResponseRecorder {
+        return serve(h, http.MethodPost, "/echo/forward", `{"message":"hi"}`, requestIDHeader, "fwd-1")
+}
+
+// decodeError reads an ErrorResponse body
+func decodeError(t *testing.T, w *httptest.ResponseRecorder) ErrorResponse {
+        t.Helper()
+        var e ErrorResponse
+        if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil {
+                t.Fatalf("decoding error body %s: %v", w.Body, err)
+        }
+        return e
+}
+
+func TestHandleForwardRelaysDownstreamWithHop(t *testing.T) {
+        var gotRequestID string
+        h := forwardingServer(t, nil, func(w http.ResponseWriter, r *http.Request) {
+                gotRequestID = r.Header.Get(requestIDHeader)
+                w.Header().Set(requestIDHeader, "downstream-7")
+                w.Header().Set("Content-Type", contentTypeJSON)
+                w.Write([]byte(`{"message":"hi","service":"downstream"}`))
+        })
+
+        w := forwardEcho(h)
+        if w.Code != http.StatusOK {
+                t.Fatalf("status %d, want 200: %s", w.Code, w.Body)
+        }
+        var resp ForwardResponse
+        if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+                t.Fatalf("decoding %s: %v", w.Body, err)
+        }
+        if string(resp.Downstream) != `{"message":"hi","service":"downstream"}` {
+                t.Errorf("downstream body %s, want it relayed untouched", resp.Downstream)
+        }
+        if gotRequestID != "fwd-1" {
+                t.Errorf("downstream saw X-Request-ID %q, want fwd-1", gotRequestID)
+        }
+        hop := resp.Hop
+        if hop.Service != serviceName || hop.Status != http.StatusOK || hop.RequestID != "fwd-1" ||
+                hop.DownstreamRequestID != "downstream-7" || !strings.HasPrefix(hop.URL, "http://127.0.0.1:") {
+                t.Errorf("hop = %+v", hop)
+        }
+}
+
+func TestHandleForwardDownstreamFailuresAre502(t *testing.T) {
+        cases := []struct {
+                name    string
+                env     map[string]string
+                handler http.HandlerFunc
+                message string
+        }{
+                {
+                        "timeout",
+                        map[string]string{"FORWARD_TIMEOUT": "50ms"},
+                        func(w http.ResponseWriter, r *http.Request) {
+                                // Once the body is read, the server notices the client hanging up
+                                io.Copy(io.Discard, r.Body)
+                                select {
+                                case <-r.Context().Done():
+                                case <-time.After(2 * time.Second):
+                                }
+                        },
+                        "downstream timed out after 50ms",
+                },
+                {
+                        "5xx",
+                        nil,
+                        func(w http.ResponseWriter, r *http.Request) {
+                                http.Error(w, "overloaded", http.StatusServiceUnavailable)
+                        },
+                        "downstream returned HTTP 503",
+                },
+                {
+                        "non-JSON body",
+                        nil,
+                        func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("<html>")) },
+                        "downstream response is not JSON",
+                },
+        }
+
+        for _, tc := range cases {
+                t.Run(tc.name, func(t *testing.T) {
+                        w := forwardEcho(forwardingServer(t, tc.env, tc.handler))
+                        if w.Code != http.StatusBadGateway {
+                                t.Fatalf("status %d, want 502: %s", w.Code, w.Body)
+                        }
+                        e := decodeError(t, w)
+                        if e.Code != errBadGateway || e.Message != tc.message || e.RequestID != "fwd-1" {
+                                t.Errorf("error = %+v, want %s %q for fwd-1", e, errBadGateway, tc.message)
+                        }
+                })
+        }
+}
+"""
+
+GO_NEGOTIATE_TEST = r"""package main
+
+import (
+        "encoding/json"
+        "encoding/xml"
+        "net/http"
+        "net/http/httptest"
+        "strconv"
+        "strings"
+        "testing"
+)
+
+func TestContentLengthOnEveryBodyPath(t *testing.T) {
+        h := newTestServer(t, map[string]string{"RESPONSE_CACHE_TTL": "1m"}).routes()
+        replay := func(string) *httptest.ResponseRecorder {
+                postEcho(h, "/echo", `{"message":"hi"}`, "key-1", "192.0.2.1:4000")
+                return postEcho(h, "/echo", `{"message":"hi"}`, "key-1", "192.0.2.1:4000")
+        }
+        cacheHit := func(target string) *httptest.ResponseRecorder {
+                serve(h, http.MethodGet, target, "")
+                return serve(h, http.MethodGet, target, "")
+        }
+
+        tests := []struct {
+                name   string
+                target string
+                send   func(target string) *httptest.ResponseRecorder
+                replay string
+                xCache string
+        }{
+                {"encoded body", "/echo", func(target string) *httptest.ResponseRecorder {
+                        return serve(h, http.MethodPost, target, `{"message":"hi"}`)
+                }, "", ""},
+                {"ETag body", "/version", func(target string) *httptest.ResponseRecorder {
+                        return serve(h, http.MethodGet, target, "")
+                }, "", "MISS"},
+                {"idempotent replay", "/echo", replay, "true", ""},
+                {"cache hit", "/", cacheHit, "", "HIT"},
+                {"cache hit with ETag", "/version", cacheHit, "", "HIT"},
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        w := tt.send(tt.target)
+                        if w.Code != http.StatusOK {
+                                t.Fatalf("status %d, want 200", w.Code)
+                        }
+                        if got, want := w.Header().Get("Content-Length"), strconv.Itoa(w.Body.Len()); got != want {
+                                t.Errorf("Content-Length = %q, want %s", got, want)
+                        }
+                        if got := w.Header().Get("Idempotent-Replayed"); got != tt.replay {
+                                t.Errorf("Idempotent-Replayed = %q, want %q", got, tt.replay)
+                        }
+                        if got := w.Header().Get("X-Cache"); got != tt.xCache {
+                                t.Errorf("X-Cache = %q, want %q", got, tt.xCache)
+                        }
+                })
+        }
+}
+
+func TestNegotiateContentType(t *testing.T) {
+        tests := []struct {
+                accept string
+                want   string // "" when nothing acceptable
+        }{
+                {"", contentTypeJSON},
+                {"application/json", contentTypeJSON},
+                {"application/xml", contentTypeXML},
+                {"text/xml", contentTypeXML},
+                {"*/*", contentTypeJSON},
+                {"application/*", contentTypeJSON},
+                {"application/xml;q=0.5, application/json", contentTypeJSON},
+                {"application/json;q=0.2, application/xml", contentTypeXML},
+                {"*/*, application/xml", contentTypeXML},
+                {"text/html", ""},
+                {"application/json;q=0", ""},
+                {"text/html, image/*", ""},
+        }
+        for _, tt := range tests {
+                r := httptest.NewRequest(http.MethodGet, "/", nil)
+                if tt.accept != "" {
+                        r.Header.Set("Accept", tt.accept)
+                }
+                got, ok := negotiateContentType(r)
+                if tt.want == "" {
+                        if ok {
+                                t.Errorf("Accept %q: negotiated %s, want none", tt.accept, got)
+                        }
+                        continue
+                }
+                if !ok || got != tt.want {
+                        t.Errorf("Accept %q: negotiated %s (ok %v), want %s", tt.accept, got, ok, tt.want)
+                }
+        }
+}
+
+// negotiated sends the request each negotiating endpoint expects, with the given Accept header
+func negotiated(h http.Handler, target, accept string) *httptest.ResponseRecorder {
+        if target == "/echo" {
+                return serve(h, http.MethodPost, target, `{"message":"hi"}`, "Accept", accept)
+        }
+        return serve(h, http.MethodGet, target, "", "Accept", accept)
+}
+
+func TestHealthAndEchoSpeakXML(t *testing.T) {
+        h := newTestServer(t, nil).routes()
+
+        w := serve(h, http.MethodGet, "/health", "", "Accept", "application/xml")
+        if ct := w.Header().Get("Content-Type"); ct != contentTypeXML {
+                t.Fatalf("/health Content-Type %q, want %s", ct, contentTypeXML)
+        }
+        var health Health
+        if err := xml.Unmarshal(w.Body.Bytes(), &health); err != nil || !health.OK || health.Service != serviceName {
+                t.Errorf("/health XML %s decoded to %+v, %v", w.Body, health, err)
+        }
+
+        w = serve(h, http.MethodPost, "/echo", `{"message":"<hi & bye>","metadata":{"k":"v"}}`, "Accept", "text/xml")
+        if ct := w.Header().Get("Content-Type"); ct != contentTypeXML {
+                t.Fatalf("/echo Content-Type %q, want %s", ct, contentTypeXML)
+        }
+        var echo struct {
+                XMLName xml.Name
+                Message string `xml:"message"`
+        }
+        if err := xml.Unmarshal(w.Body.Bytes(), &echo); err != nil || echo.XMLName.Local != "Echo" || echo.Message != "<hi & bye>" {
+                t.Errorf("/echo XML %s decoded to %+v, %v", w.Body, echo, err)
+        }
+        if !strings.HasPrefix(w.Body.String(), xml.Header) {
+                t.Errorf("/echo XML lacks the XML declaration: %s", w.Body)
+        }
+
+        // Without an Accept header both stay JSON
+        for _, target := range []string{"/health", "/echo"} {
+                if ct := negotiated(h, target, "").Header().Get("Content-Type"); ct != contentTypeJSON {
+                        t.Errorf("%s default Content-Type %q, want %s", target, ct, contentTypeJSON)
+                }
+        }
+}
+
+func TestUnsupportedAcceptIs406(t *testing.T) {
+        h := newTestServer(t, nil).routes()
+        for _, target := range []string{"/health", "/echo"} {
+                w := negotiated(h, target, "text/html")
+                if w.Code != http.StatusNotAcceptable {
+                        t.Errorf("%s with Accept text/html = %d, want 406", target, w.Code)
+                        continue
+                }
+                if e := decodeError(t, w); e.Code != errNotAcceptable {
+                        t.Errorf("%s 406 code %q, want %s", target, e.Code, errNotAcceptable)
+                }
+        }
+}
+
+func TestWriteBodyReturnsBufferWhenEncodingFails(t *testing.T) {
+        // Neither encoder can represent a channel
+        unencodable := map[string]any{"ch": make(chan int)}
+        for _, contentType := range []string{contentTypeJSON, contentTypeXML} {
+                t.Run(contentType, func(t *testing.T) {
+                        before := bodyBuffersOut.Load()
+                        w := httptest.NewRecorder()
+                        writeBody(w, httptest.NewRequest(http.MethodGet, "/", nil), http.StatusOK, contentType, unencodable)
+                        if w.Code != http.StatusInternalServerError {
+                                t.Errorf("status %d, want 500", w.Code)
+                        }
+                        if out := bodyBuffersOut.Load(); out != before {
+                                t.Errorf("%d buffers still out after the failed encode, want %d", out, before)
+                        }
+                })
+        }
+
+        before := bodyBuffersOut.Load()
+        if _, err := marshalBody(contentTypeJSON, unencodable, false); err == nil {
+                t.Error("marshalBody encoded a channel")
+        }
+        if out := bodyBuffersOut.Load(); out != before {
+                t.Errorf("marshalBody kept a buffer after failing: %d out, want %d", out, before)
+        }
+}
+
+// benchmarkBody is a typical /echo response
+var benchmarkBody = Echo{
+        Message:     "hello",
+        Metadata:    Metadata{"source": "bench"},
+        Timestamp:   testTime,
+        ReceivedAt:  testTime,
+        ProcessedAt: testTime,
+        Service:     "aurora-service",
+        RequestID:   "req-1",
+}
+
+func BenchmarkWriteJSON(b *testing.B) {
+        r := httptest.NewRequest(http.MethodGet, "/echo", nil)
+
+        b.Run("pooled", func(b *testing.B) {
+                b.ReportAllocs()
+                for i := 0; i < b.N; i++ {
+                        writeJSON(httptest.NewRecorder(), r, http.StatusOK, benchmarkBody)
+                }
+        })
+
+        // What writeBody did before the pool: a fresh buffer and encoder per response
+        b.Run("unpooled", func(b *testing.B) {
+                b.ReportAllocs()
+                for i := 0; i < b.N; i++ {
+                        body := new(bodyBuffer)
+                        body.json = json.NewEncoder(&body.Buffer)
+                        if err := body.encode(contentTypeJSON, benchmarkBody, false); err != nil {
+                                b.Fatal(err)
+                        }
+                        writeBytes(httptest.NewRecorder(), r, http.StatusOK, contentTypeJSON, body.Bytes())
+                }
+        })
+}
+"""
+
+GO_CACHE_TEST = r"""package main
+
+import (
+        "net/http"
+        "slices"
+        "sync/atomic"
+        "testing"
+        "time"
+)
+
+// countingPage is a cacheable handler that numbers its responses and sets the headers a
+// hit must replay
+func countingPage(calls *atomic.Int32) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                n := calls.Add(1)
+                w.Header().Set("Cache-Control", "max-age=60")
+                w.Header().Add("Vary", "Accept")
+                w.Header().Set("X-Page", "counting")
+                writeJSON(w, r, http.StatusOK, map[string]int32{"call": n})
+        })
+}
+
+func TestResponseCacheReplaysFullResponse(t *testing.T) {
+        newTestServer(t, map[string]string{
+                "RESPONSE_CACHE_TTL":   "1m",
+                "RESPONSE_CACHE_PATHS": "/page",
+        })
+        var calls atomic.Int32
+        h := requestIDMiddleware((&responseCache{entries: make(map[string]cacheEntry)}).middleware(countingPage(&calls)))
+
+        miss := serve(h, http.MethodGet, "/page", "")
+        hit := serve(h, http.MethodGet, "/page", "")
+        if calls.Load() != 1 {
+                t.Fatalf("handler ran %d times, want 1", calls.Load())
+        }
+        if miss.Header().Get("X-Cache") != "MISS" || hit.Header().Get("X-Cache") != "HIT" {
+                t.Fatalf("X-Cache = %q then %q, want MISS then HIT", miss.Header().Get("X-Cache"), hit.Header().Get("X-Cache"))
+        }
+        if hit.Body.String() != miss.Body.String() {
+                t.Errorf("hit body %s, want %s", hit.Body, miss.Body)
+        }
+        for _, name := range []string{"Cache-Control", "Vary", "X-Page", "Content-Type", "Content-Length"} {
+                if got, want := hit.Header().Values(name), miss.Header().Values(name); !slices.Equal(got, want) {
+                        t.Errorf("hit %s = %q, want %q", name, got, want)
+                }
+        }
+        // Headers set outside the cache belong to each request
+        if hit.Header().Get(requestIDHeader) == miss.Header().Get(requestIDHeader) {
+                t.Errorf("hit replayed the first request's %s", requestIDHeader)
+        }
+}
+
+func TestResponseCacheReplaysRoutesHeaders(t *testing.T) {
+        h := newTestServer(t, map[string]string{"RESPONSE_CACHE_TTL": "1m"}).routes()
+
+        miss := serve(h, http.MethodGet, "/version", "")
+        hit := serve(h, http.MethodGet, "/version", "")
+        if hit.Header().Get("X-Cache") != "HIT" {
+                t.Fatalf("second GET /version: X-Cache = %q, want HIT", hit.Header().Get("X-Cache"))
+        }
+        for name, want := range miss.Header() {
+                if name == "X-Cache" || name == http.CanonicalHeaderKey(requestIDHeader) {
+                        continue
+                }
+                if got := hit.Header().Values(name); !slices.Equal(got, want) {
+                        t.Errorf("hit %s = %q, want %q", name, got, want)
+                }
+        }
+
+        notModified := serve(h, http.MethodGet, "/version", "", "If-None-Match", miss.Header().Get("ETag"))
+        if notModified.Code != http.StatusNotModified || notModified.Body.Len() != 0 {
+                t.Errorf("conditional hit: status %d with %d body bytes, want an empty 304", notModified.Code, notModified.Body.Len())
+        }
+}
+
+func TestResponseCacheExpires(t *testing.T) {
+        newTestServer(t, map[string]string{
+                "RESPONSE_CACHE_TTL":   "50ms",
+                "RESPONSE_CACHE_PATHS": "/page",
+        })
+        var calls atomic.Int32
+        h := (&responseCache{entries: make(map[string]cacheEntry)}).middleware(countingPage(&calls))
+
+        serve(h, http.MethodGet, "/page", "")
+        if w := serve(h, http.MethodGet, "/page", ""); w.Header().Get("X-Cache") != "HIT" {
+                t.Fatalf("within the TTL: X-Cache = %q, want HIT", w.Header().Get("X-Cache"))
+        }
+        time.Sleep(60 * time.Millisecond)
+        w := serve(h, http.MethodGet, "/page", "")
+        if w.Header().Get("X-Cache") != "MISS" || calls.Load() != 2 {
+                t.Errorf("past the TTL: X-Cache = %q after %d handler runs, want MISS after 2", w.Header().Get("X-Cache"), calls.Load())
+        }
+}
+
+func TestResponseCacheGetDropsExpiredEntries(t *testing.T) {
+        c := &responseCache{entries: make(map[string]cacheEntry)}
+        now := time.Now()
+        c.put("k", cacheEntry{body: []byte("{}"), expires: now.Add(time.Minute)})
+
+        if _, ok := c.get("k", now); !ok {
+                t.Fatal("fresh entry missed")
+        }
+        if _, ok := c.get("k", now.Add(time.Minute+time.Nanosecond)); ok {
+                t.Fatal("expired entry hit")
+        }
+        if _, ok := c.entries["k"]; ok {
+                t.Error("expired entry still held")
+        }
+}
+"""
+
+GO_RELOAD_TEST = r"""//go:build unix
+
+package main
+
+import (
+        "log/slog"
+        "os"
+        "path/filepath"
+        "syscall"
+        "testing"
+        "time"
+)
+
+func TestSIGHUPReloadsConfigFile(t *testing.T) {
+        path := filepath.Join(t.TempDir(), "config.json")
+        writeFile := func(body string) {
+                t.Helper()
+                if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+                        t.Fatal(err)
+                }
+        }
+        writeFile(`{"log_level": "info", "rate_limit_rps": 0}`)
+        s := newTestServer(t, map[string]string{"CONFIG_FILE": path})
+        prevLevel := logLevel.Level()
+        t.Cleanup(func() { logLevel.Set(prevLevel) })
+        logLevel.Set(currentConfig().LogLevel)
+
+        // The reload purges the transform cache last, so an empty cache means it has finished
+        s.transformResults.put("upper", "hi", "HI", time.Now())
+
+        s.watchReload()
+        writeFile(`{"log_level": "debug", "rate_limit_rps": 5}`)
+        if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+                t.Fatalf("sending SIGHUP: %v", err)
+        }
+
+        deadline := time.Now().Add(2 * time.Second)
+        for s.transformResults.snapshot().Entries > 0 {
+                if time.Now().After(deadline) {
+                        t.Fatal("configuration not reloaded within 2s of SIGHUP")
+                }
+                time.Sleep(5 * time.Millisecond)
+        }
+
+        if got := logLevel.Level(); got != slog.LevelDebug {
+                t.Errorf("log level after SIGHUP = %s, want DEBUG", got)
+        }
+        if got := currentConfig().RateLimitRPS; got != 5 {
+                t.Errorf("RATE_LIMIT_RPS after SIGHUP = %g, want 5", got)
+        }
+}
+"""
+
+GO_CONFIG_TEST = r"""package main
+
+import (
+        "fmt"
+        "os"
+        "path/filepath"
+        "slices"
+        "strings"
+        "testing"
+        "time"
+)
+
+// envCase is one reading of the setting TEST_SETTING: unset when value is "", and the
+// expected result and error, which must name the variable and the bad value
+type envCase struct {
+        name    string
+        value   string
+        want    any
+        wantErr string
+}
+
+// checkEnvCases reads TEST_SETTING with read for every case
+func checkEnvCases(t *testing.T, cases []envCase, read func(env *envReader) any) {
+        t.Helper()
+        for _, tt := range cases {
+                t.Run(tt.name, func(t *testing.T) {
+                        if tt.value != "" {
+                                t.Setenv("TEST_SETTING", tt.value)
+                        }
+                        env := &envReader{}
+                        if got := read(env); got != tt.want {
+                                t.Errorf("got %v, want %v", got, tt.want)
+                        }
+                        if tt.wantErr == "" {
+                                if len(env.errs) > 0 {
+                                        t.Errorf("unexpected errors %v", env.errs)
+                                }
+                                return
+                        }
+                        if len(env.errs) != 1 {
+                                t.Fatalf("errors = %v, want one", env.errs)
+                        }
+                        msg := env.errs[0].Error()
+                        if !strings.HasPrefix(msg, fmt.Sprintf("TEST_SETTING=%q: ", tt.value)) || !strings.Contains(msg, tt.wantErr) {
+                                t.Errorf("error %q, want TEST_SETTING=%q and %q", msg, tt.value, tt.wantErr)
+                        }
+                })
+        }
+}
+
+func TestEnvReaderPort(t *testing.T) {
+        checkEnvCases(t, []envCase{
+                {"missing", "", "8080", ""},
+                {"valid", "9090", "9090", ""},
+                {"lowest", "0", "0", ""},
+                {"highest", "65535", "65535", ""},
+                {"not a number", "abc", "8080", "want a port number between 0 and 65535"},
+                {"out of range", "65536", "8080", "want a port number between 0 and 65535"},
+                {"negative", "-1", "8080", "want a port number between 0 and 65535"},
+        }, func(env *envReader) any { return env.port("TEST_SETTING", "8080") })
+}
+
+func TestEnvReaderInteger(t *testing.T) {
+        checkEnvCases(t, []envCase{
+                {"missing", "", 7, ""},
+                {"valid", "42", 42, ""},
+                {"negative", "-3", -3, ""},
+                {"not a number", "forty", 7, "want an integer"},
+                {"fraction", "1.5", 7, "want an integer"},
+        }, func(env *envReader) any { return env.integer("TEST_SETTING", 7) })
+}
+
+func TestEnvReaderDuration(t *testing.T) {
+        checkEnvCases(t, []envCase{
+                {"missing", "", 5 * time.Second, ""},
+                {"valid", "250ms", 250 * time.Millisecond, ""},
+                {"compound", "1m30s", 90 * time.Second, ""},
+                {"no unit", "10", 5 * time.Second, "want a duration such as 5s or 250ms"},
+                {"garbage", "soon", 5 * time.Second, "want a duration such as 5s or 250ms"},
+        }, func(env *envReader) any { return env.duration("TEST_SETTING", 5*time.Second) })
+}
+
+func TestEnvReaderBoolean(t *testing.T) {
+        checkEnvCases(t, []envCase{
+                {"missing", "", true, ""},
+                {"false", "false", false, ""},
+                {"zero", "0", false, ""},
+                {"one", "1", true, ""},
+                {"garbage", "maybe", true, "want true or false"},
+        }, func(env *envReader) any { return env.boolean("TEST_SETTING", true) })
+}
+
+func TestEnvReaderFallsBackToConfigFile(t *testing.T) {
+        env := &envReader{file: map[string]string{"TEST_SETTING": "12"}}
+        if got := env.integer("TEST_SETTING", 7); got != 12 {
+                t.Errorf("file value: got %d, want 12", got)
+        }
+        t.Setenv("TEST_SETTING", "13")
+        if got := env.integer("TEST_SETTING", 7); got != 13 {
+                t.Errorf("environment over file: got %d, want 13", got)
+        }
+}
+
+// writeConfigFile writes body to a temporary CONFIG_FILE and points loadConfig at it
+func writeConfigFile(t *testing.T, body string) string {
+        t.Helper()
+        path := filepath.Join(t.TempDir(), "config.json")
+        if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+                t.Fatal(err)
+        }
+        t.Setenv("CONFIG_FILE", path)
+        return path
+}
+
+const testConfigFile = `{
+  "PORT": 9090,
+  "READ_TIMEOUT": "10s",
+  "message_store_size": 25,
+  "CORS_ALLOWED_ORIGINS": ["https://a.example", "https://b.example"]
+}`
+
+func TestLoadConfigFromFileOnly(t *testing.T) {
+        writeConfigFile(t, testConfigFile)
+        cfg, err := loadConfig()
+        if err != nil {
+                t.Fatalf("loadConfig: %v", err)
+        }
+        if cfg.Addr != ":9090" || cfg.ReadTimeout != 10*time.Second || cfg.MessageStoreSize != 25 {
+                t.Errorf("addr %s, read timeout %s, store size %d; want the file's :9090, 10s, 25",
+                        cfg.Addr, cfg.ReadTimeout, cfg.MessageStoreSize)
+        }
+        if want := []string{"https://a.example", "https://b.example"}; !slices.Equal(cfg.CORSAllowedOrigins, want) {
+                t.Errorf("CORS origins %v, want %v", cfg.CORSAllowedOrigins, want)
+        }
+}
+
+func TestLoadConfigFromEnvironmentOnly(t *testing.T) {
+        t.Setenv("PORT", "9191")
+        t.Setenv("READ_TIMEOUT", "3s")
+        cfg, err := loadConfig()
+        if err != nil {
+                t.Fatalf("loadConfig: %v", err)
+        }
+        if cfg.Addr != ":9191" || cfg.ReadTimeout != 3*time.Second {
+                t.Errorf("addr %s, read timeout %s; want :9191, 3s", cfg.Addr, cfg.ReadTimeout)
+        }
+        if def := defaultConfig(); cfg.MessageStoreSize != def.MessageStoreSize {
+                t.Errorf("store size %d, want the default %d", cfg.MessageStoreSize, def.MessageStoreSize)
+        }
+}
+
+func TestLoadConfigEnvironmentOverridesFile(t *testing.T) {
+        writeConfigFile(t, testConfigFile)
+        t.Setenv("PORT", "9292")
+        t.Setenv("CORS_ALLOWED_ORIGINS", "https://env.example")
+        cfg, err := loadConfig()
+        if err != nil {
+                t.Fatalf("loadConfig: %v", err)
+        }
+        if cfg.Addr != ":9292" {
+                t.Errorf("addr %s, want the environment's :9292", cfg.Addr)
+        }
+        if want := []string{"https://env.example"}; !slices.Equal(cfg.CORSAllowedOrigins, want) {
+                t.Errorf("CORS origins %v, want the environment's %v", cfg.CORSAllowedOrigins, want)
+        }
+        // Settings the environment leaves alone still come from the file
+        if cfg.ReadTimeout != 10*time.Second || cfg.MessageStoreSize != 25 {
+                t.Errorf("read timeout %s, store size %d; want the file's 10s, 25", cfg.ReadTimeout, cfg.MessageStoreSize)
+        }
+}
+
+func TestLoadConfigRejectsInvalidFileValues(t *testing.T) {
+        tests := []struct {
+                name string
+                body string
+                want string
+        }{
+                {"fails validation", `{"READ_TIMEOUT": "-5s"}`, "READ_TIMEOUT must not be negative, got -5s"},
+                {"does not parse", `{"PORT": 70000}`, `PORT="70000"`},
+                {"unknown setting", `{"PROT": 9090}`, `unknown setting "PROT"`},
+                {"not an object", `[1, 2]`, "invalid JSON"},
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        writeConfigFile(t, tt.body)
+                        _, err := loadConfig()
+                        if err == nil {
+                                t.Fatalf("loadConfig accepted %s", tt.body)
+                        }
+                        if !strings.Contains(err.Error(), tt.want) {
+                                t.Errorf("error %q, want it to contain %q", err, tt.want)
+                        }
+                })
+        }
+
+        // A valid environment value replaces the file's bad one before validation sees it
+        writeConfigFile(t, `{"READ_TIMEOUT": "-5s"}`)
+        t.Setenv("READ_TIMEOUT", "5s")
+        if _, err := loadConfig(); err != nil {
+                t.Errorf("environment override of a bad file value: %v", err)
+        }
+}
+
+func TestLoadConfigNamesBadPorts(t *testing.T) {
+        tests := []struct {
+                key   string
+                value string
+        }{
+                {"PORT", "abc"},
+                {"PORT", "70000"},
+                {"ADMIN_PORT", "abc"},
+        }
+        for _, tt := range tests {
+                t.Run(tt.key+"="+tt.value, func(t *testing.T) {
+                        t.Setenv(tt.key, tt.value)
+                        _, err := loadConfig()
+                        if err == nil {
+                                t.Fatal("loadConfig accepted the bad port")
+                        }
+                        if want := fmt.Sprintf("%s=%q", tt.key, tt.value); !strings.Contains(err.Error(), want) {
+                                t.Errorf("error %q does not name %s", err, want)
+                        }
+                })
+        }
+}
+"""
+
+GO_TRANSFORM_TEST = r"""package main
+
+import (
+        "net/http"
+        "testing"
+        "time"
+)
+
+func TestTransformCacheThroughEcho(t *testing.T) {
+        s := newTestServer(t, nil)
+        h := s.routes()
+        echo := func(target, body string) {
+                t.Helper()
+                if w := serve(h, http.MethodPost, target, body); w.Code != http.StatusOK {
+                        t.Fatalf("POST %s: status %d, body %s", target, w.Code, w.Body)
+                }
+        }
+
+        echo("/echo?transform=upper", `{"message":"hi"}`)
+        echo("/echo?transform=upper", `{"message":"hi"}`)
+        echo("/echo?transform=upper", `{"message":"bye"}`)
+        echo("/echo?transform=reverse", `{"message":"hi"}`)
+        echo("/echo", `{"message":"hi"}`)
+
+        got := *s.transformResults.snapshot()
+        want := TransformCacheStats{Entries: 3, Hits: 1, Misses: 3}
+        if got != want {
+                t.Errorf("transform cache = %+v, want %+v", got, want)
+        }
+}
+
+func TestTransformCacheHitsAndMisses(t *testing.T) {
+        c := newTransformCache(8, time.Minute)
+        now := time.Now()
+        c.put("upper", "hi", "HI", now)
+
+        tests := []struct {
+                name    string
+                spec    string
+                message string
+                want    string
+                hit     bool
+        }{
+                {"repeated input", "upper", "hi", "HI", true},
+                {"other message", "upper", "bye", "", false},
+                {"other chain", "lower", "hi", "", false},
+                {"chain and message run together", "upperh", "i", "", false},
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        got, hit := c.get(tt.spec, tt.message, now)
+                        if got != tt.want || hit != tt.hit {
+                                t.Errorf("get(%q, %q) = %q, %v; want %q, %v", tt.spec, tt.message, got, hit, tt.want, tt.hit)
+                        }
+                })
+        }
+}
+
+func TestTransformCacheExpiresAfterTTL(t *testing.T) {
+        c := newTransformCache(8, time.Minute)
+        now := time.Now()
+        c.put("upper", "hi", "HI", now)
+
+        if _, hit := c.get("upper", "hi", now.Add(time.Minute)); !hit {
+                t.Error("entry missed at its TTL")
+        }
+        if _, hit := c.get("upper", "hi", now.Add(time.Minute+time.Second)); hit {
+                t.Error("entry older than its TTL hit")
+        }
+        if n := c.snapshot().Entries; n != 0 {
+                t.Errorf("expired entry still held: %d entries", n)
+        }
+}
+
+func TestTransformCacheEvictsLeastRecentlyUsed(t *testing.T) {
+        c := newTransformCache(2, time.Minute)
+        now := time.Now()
+        c.put("upper", "a", "A", now)
+        c.put("upper", "b", "B", now)
+        c.get("upper", "a", now)
+        c.put("upper", "c", "C", now)
+
+        if _, hit := c.get("upper", "b", now); hit {
+                t.Error("least recently used entry survived")
+        }
+        for _, message := range []string{"a", "c"} {
+                if _, hit := c.get("upper", message, now); !hit {
+                        t.Errorf("entry %q evicted", message)
+                }
+        }
+}
+
+func TestTransformCachePurgedOnReload(t *testing.T) {
+        s := newTestServer(t, nil)
+        s.transformResults.put("upper", "hi", "HI", time.Now())
+
+        s.reloadConfig()
+        if n := s.transformResults.snapshot().Entries; n != 0 {
+                t.Errorf("after reload the cache holds %d entries, want 0", n)
+        }
+        if _, hit := s.transformResults.get("upper", "hi", time.Now()); hit {
+                t.Error("entry from before the reload hit")
+        }
+}
+
+func TestTransformCacheOff(t *testing.T) {
+        if c := newTransformCache(0, time.Minute); c != nil {
+                t.Error("TRANSFORM_CACHE_SIZE=0 built a cache")
+        }
+        if c := newTransformCache(8, 0); c != nil {
+                t.Error("TRANSFORM_CACHE_TTL=0 built a cache")
+        }
+        var c *transformCache
+        c.put("upper", "hi", "HI", time.Now())
+        if _, hit := c.get("upper", "hi", time.Now()); hit {
+                t.Error("nil cache hit")
+        }
+}
+"""
+
+GO_CHECKSUM_TEST = r"""package main
+
+import (
+        "crypto/sha256"
+        "crypto/sha512"
+        "encoding/hex"
+        "encoding/json"
+        "fmt"
+        "hash/crc32"
+        "net/http"
+        "strings"
+        "testing"
+)
+
+func TestEchoChecksum(t *testing.T) {
+        h := newTestServer(t, nil).routes()
+        const message = "héllo, wörld"
+        sha256Sum := sha256.Sum256([]byte(message))
+        sha512Sum := sha512.Sum512([]byte(message))
+        upperSum := sha256.Sum256([]byte(strings.ToUpper(message)))
+
+        tests := []struct {
+                query string
+                want  string
+        }{
+                {"checksum=crc32", fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(message)))},
+                {"checksum=sha256", hex.EncodeToString(sha256Sum[:])},
+                {"checksum=sha512", hex.EncodeToString(sha512Sum[:])},
+                {"checksum=sha256&transform=upper", hex.EncodeToString(upperSum[:])},
+        }
+        if len(tests)-1 != len(checksumAlgorithms) {
+                t.Fatalf("table covers %d algorithms, checksumAlgorithms has %d", len(tests)-1, len(checksumAlgorithms))
+        }
+        for _, tt := range tests {
+                t.Run(tt.query, func(t *testing.T) {
+                        w := serve(h, http.MethodPost, "/echo?"+tt.query, `{"message":"`+message+`"}`)
+                        if w.Code != http.StatusOK {
+                                t.Fatalf("status %d, body %s", w.Code, w.Body)
+                        }
+                        var got EchoChecksum
+                        if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+                                t.Fatalf("decoding %s: %v", w.Body, err)
+                        }
+                        if got.Checksum != tt.want {
+                                t.Errorf("checksum = %s, want %s", got.Checksum, tt.want)
+                        }
+                        if got.Length != len(message) {
+                                t.Errorf("length = %d, want %d bytes", got.Length, len(message))
+                        }
+                        if strings.Contains(w.Body.String(), `"message"`) {
+                                t.Errorf("body carries the message: %s", w.Body)
+                        }
+                })
+        }
+}
+
+func TestEchoChecksumUnknownAlgorithm(t *testing.T) {
+        s := newTestServer(t, nil)
+        w := serve(s.routes(), http.MethodPost, "/echo?checksum=md5", `{"message":"hi"}`)
+        if w.Code != http.StatusBadRequest {
+                t.Fatalf("status %d, want 400", w.Code)
+        }
+        var body ErrorResponse
+        if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+                t.Fatalf("decoding %s: %v", w.Body, err)
+        }
+        if body.Code != errInvalidParameter || !strings.Contains(body.Message, `"md5"`) {
+                t.Errorf("error = %+v, want %s naming md5", body, errInvalidParameter)
+        }
+        if n := storedMessages(t, s); n != 0 {
+                t.Errorf("store holds %d messages, want 0", n)
+        }
+}
+"""
+
+GO_CLOCK_TEST = r"""package main
+
+import (
+        "encoding/json"
+        "net/http"
+        "net/http/httptest"
+        "sync"
+        "testing"
+        "time"
+)
+
+// frozenClock always reports the same instant until it is advanced
+type frozenClock struct {
+        mu  sync.Mutex
+        now time.Time
+}
+
+func newFrozenClock(t time.Time) *frozenClock {
+        return &frozenClock{now: t}
+}
+
+func (c *frozenClock) Now() time.Time {
+        c.mu.Lock()
+        defer c.mu.Unlock()
+        return c.now
+}
+
+// advance moves the clock forward by d
+func (c *frozenClock) advance(d time.Duration) {
+        c.mu.Lock()
+        defer c.mu.Unlock()
+        c.now = c.now.Add(d)
+}
+
+func TestFrozenClockStampsResponses(t *testing.T) {
+        s := newTestServer(t, nil)
+        clock := s.clock.(*frozenClock)
+        h := s.routes()
+
+        echoAt := func(want time.Time) {
+                t.Helper()
+                w := serve(h, http.MethodPost, "/echo", `{"message":"hi"}`)
+                var e Echo
+                if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil {
+                        t.Fatalf("decoding %s: %v", w.Body, err)
+                }
+                for name, got := range map[string]time.Time{"received_at": e.ReceivedAt, "processed_at": e.ProcessedAt, "timestamp": e.Timestamp} {
+                        if !got.Equal(want) {
+                                t.Errorf("%s = %s, want %s", name, got, want)
+                        }
+                }
+        }
+        healthAt := func(want time.Time) {
+                t.Helper()
+                var health Health
+                if err := json.Unmarshal(serve(h, http.MethodGet, "/health", "").Body.Bytes(), &health); err != nil {
+                        t.Fatal(err)
+                }
+                if !health.Timestamp.Equal(want) {
+                        t.Errorf("health timestamp = %s, want %s", health.Timestamp, want)
+                }
+        }
+
+        echoAt(testTime)
+        healthAt(testTime)
+        clock.advance(90 * time.Second)
+        echoAt(testTime.Add(90 * time.Second))
+        healthAt(testTime.Add(90 * time.Second))
+}
+
+func TestStampEchoSeparatesReceivedFromProcessed(t *testing.T) {
+        s := newTestServer(t, nil)
+        clock := s.clock.(*frozenClock)
+
+        var e Echo
+        h := receivedAtMiddleware(clock, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                // The request spends 250ms between arriving and being stamped
+                clock.advance(250 * time.Millisecond)
+                s.stampEcho(&e, r)
+        }))
+        h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/echo", nil))
+
+        if !e.ReceivedAt.Equal(testTime) {
+                t.Errorf("received_at = %s, want %s", e.ReceivedAt, testTime)
+        }
+        if want := testTime.Add(250 * time.Millisecond); !e.ProcessedAt.Equal(want) || !e.Timestamp.Equal(want) {
+                t.Errorf("processed_at = %s, timestamp = %s, want both %s", e.ProcessedAt, e.Timestamp, want)
+        }
+}
+
+func TestStampEchoKeepsCallerReceivedAt(t *testing.T) {
+        s := newTestServer(t, nil)
+        sent := testTime.Add(-time.Minute)
+        e := Echo{ReceivedAt: sent}
+        s.stampEcho(&e, httptest.NewRequest(http.MethodPost, "/echo", nil))
+
+        if !e.ReceivedAt.Equal(sent) {
+                t.Errorf("received_at = %s, want the caller's %s", e.ReceivedAt, sent)
+        }
+        if !e.ProcessedAt.Equal(testTime) {
+                t.Errorf("processed_at = %s, want %s", e.ProcessedAt, testTime)
+        }
+}
+
+func TestUptimeFollowsTheClock(t *testing.T) {
+        s := newTestServer(t, nil)
+        clock := s.clock.(*frozenClock)
+        h := s.routes()
+        clock.advance(90 * time.Second)
+
+        var stats Stats
+        if err := json.Unmarshal(serve(h, http.MethodGet, "/stats", "").Body.Bytes(), &stats); err != nil {
+                t.Fatal(err)
+        }
+        var health DetailedHealth
+        if err := json.Unmarshal(serve(h, http.MethodGet, "/health/detailed", "").Body.Bytes(), &health); err != nil {
+                t.Fatal(err)
+        }
+        if stats.UptimeSeconds != 90 || health.UptimeSeconds != 90 {
+                t.Errorf("uptime: /stats %v, /health/detailed %v, want 90 from both", stats.UptimeSeconds, health.UptimeSeconds)
+        }
+}
+
+func TestTransformCacheExpiresByTheClock(t *testing.T) {
+        s := newTestServer(t, map[string]string{"TRANSFORM_CACHE_TTL": "1m"})
+        clock := s.clock.(*frozenClock)
+        h := s.routes()
+        upper := func() {
+                t.Helper()
+                if w := serve(h, http.MethodPost, "/echo?transform=upper", `{"message":"hi"}`); w.Code != http.StatusOK {
+                        t.Fatalf("POST /echo: status %d, body %s", w.Code, w.Body)
+                }
+        }
+
+        upper()
+        clock.advance(59 * time.Second)
+        upper()
+        clock.advance(2 * time.Second)
+        upper()
+
+        want := TransformCacheStats{Entries: 1, Hits: 1, Misses: 2}
+        if got := *s.transformResults.snapshot(); got != want {
+                t.Errorf("transform cache = %+v, want %+v: a hit within the TTL, a miss once past it", got, want)
+        }
+}
+"""
+
+GO_STARTUP_TEST = r"""package main
+
+import (
+        "context"
+        "errors"
+        "net/http"
+        "net/http/httptest"
+        "strings"
+        "sync/atomic"
+        "testing"
+        "time"
+)
+
+// flakyCheck fails until healthy is set
+func flakyCheck(healthy *atomic.Bool) []startupCheck {
+        return []startupCheck{{
+                name: "flaky",
+                run: func(context.Context) error {
+                        if !healthy.Load() {
+                                return errors.New("not yet")
+                        }
+                        return nil
+                },
+        }}
+}
+
+func TestAdminShutdownDuringStartupRetryStaysUnready(t *testing.T) {
+        s := newTestServer(t, map[string]string{"ADMIN_TOKEN": "secret"})
+        h := s.routes()
+        stopped := make(chan struct{})
+        s.stop = func() { close(stopped) }
+
+        var healthy atomic.Bool
+        done := make(chan struct{})
+        go func() {
+                defer close(done)
+                s.retryStartupChecks(context.Background(), flakyCheck(&healthy), time.Second, time.Millisecond)
+        }()
+
+        w := serve(h, http.MethodPost, "/admin/shutdown", "", "Authorization", "Bearer secret")
+        if w.Code != http.StatusAccepted {
+                t.Fatalf("/admin/shutdown %d, want 202", w.Code)
+        }
+
+        // The checks pass only after the shutdown was requested; the retry loop must not
+        // bring the service back
+        healthy.Store(true)
+        <-done
+        if w := serve(h, http.MethodGet, "/ready", ""); w.Code != http.StatusServiceUnavailable {
+                t.Errorf("/ready %d after shutdown, want 503", w.Code)
+        }
+        <-stopped
+}
+
+func TestMarkReadyOnlyFromStarting(t *testing.T) {
+        s := newTestServer(t, nil)
+        if s.isReady() {
+                t.Fatal("new server is ready before its startup checks")
+        }
+        if !s.markReady() || !s.isReady() {
+                t.Fatal("markReady did not make a starting server ready")
+        }
+        s.markDraining()
+        if s.markReady() || s.isReady() {
+                t.Error("markReady revived a draining server")
+        }
+}
+
+// dependencyStub is a DEPENDENCY_CHECK_URL target that answers 200 while healthy and 503 otherwise
+func dependencyStub(t *testing.T, healthy *atomic.Bool) string {
+        t.Helper()
+        srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                if !healthy.Load() {
+                        w.WriteHeader(http.StatusServiceUnavailable)
+                        return
+                }
+                w.WriteHeader(http.StatusOK)
+        }))
+        t.Cleanup(srv.Close)
+        return srv.URL
+}
+
+// runServer starts s.run on an ephemeral port. Cancelling stop shuts it down; wait blocks
+// until run returns and gives its error.
+func runServer(t *testing.T, env map[string]string) (s *Server, stop context.CancelFunc, wait func() error) {
+        t.Helper()
+        vars := map[string]string{
+                "BIND_ADDR":              "127.0.0.1",
+                "PORT":                   "0",
+                "PRE_SHUTDOWN_DELAY":     "0s",
+                "STARTUP_RETRY_INTERVAL": "10ms",
+        }
+        for k, v := range env {
+                vars[k] = v
+        }
+        s = newTestServer(t, vars)
+        ctx, cancel := context.WithCancel(context.Background())
+        done := make(chan struct{})
+        var runErr error
+        go func() {
+                runErr = s.run(ctx)
+                close(done)
+        }()
+        wait = func() error {
+                <-done
+                return runErr
+        }
+        t.Cleanup(func() {
+                cancel()
+                wait()
+        })
+        return s, cancel, wait
+}
+
+// waitForReady polls until s reports ready
+func waitForReady(t *testing.T, s *Server) {
+        t.Helper()
+        deadline := time.Now().Add(2 * time.Second)
+        for !s.isReady() {
+                if time.Now().After(deadline) {
+                        t.Fatal("service never became ready")
+                }
+                time.Sleep(time.Millisecond)
+        }
+}
+
+func TestReadyOnceStartupChecksPass(t *testing.T) {
+        var healthy atomic.Bool
+        healthy.Store(true)
+        s, stop, wait := runServer(t, map[string]string{"DEPENDENCY_CHECK_URL": dependencyStub(t, &healthy)})
+        waitForReady(t, s)
+        stop()
+        if err := wait(); err != nil {
+                t.Errorf("run: %v", err)
+        }
+        if s.isReady() {
+                t.Error("still ready after shutdown")
+        }
+}
+
+func TestUnreadyWhileStartupChecksFail(t *testing.T) {
+        var healthy atomic.Bool
+        s, _, _ := runServer(t, map[string]string{"DEPENDENCY_CHECK_URL": dependencyStub(t, &healthy)})
+
+        // Several retry intervals pass without the dependency answering
+        time.Sleep(50 * time.Millisecond)
+        if s.isReady() {
+                t.Fatal("ready while the dependency check fails")
+        }
+
+        healthy.Store(true)
+        waitForReady(t, s)
+}
+
+func TestStrictStartupFailsFast(t *testing.T) {
+        var healthy atomic.Bool
+        s, _, wait := runServer(t, map[string]string{
+                "DEPENDENCY_CHECK_URL": dependencyStub(t, &healthy),
+                "STRICT_STARTUP":       "true",
+        })
+        err := wait()
+        if err == nil || !strings.Contains(err.Error(), "startup checks failed") || !strings.Contains(err.Error(), "HTTP 503") {
+                t.Errorf("run = %v, want the failed dependency check", err)
+        }
+        if s.isReady() {
+                t.Error("ready after strict startup failed")
+        }
+}
+"""
+
+GO_FEATURES_TEST = r"""package main
+
+import (
+        "net/http"
+        "strings"
+        "testing"
+)
+
+// featureProbes says, for each build-tagged feature, how to tell from outside whether its
+// route is mounted. Each tagged _test.go file checks the routes are there with the tag.
+var featureProbes = []struct {
+        tag, setting string
+        mounted      func(h http.Handler) bool
+}{
+        {"pprof", "ENABLE_PPROF", func(h http.Handler) bool {
+                return serve(h, http.MethodGet, "/debug/pprof/", "").Code == http.StatusOK
+        }},
+        {"websocket", "ENABLE_WEBSOCKET", func(h http.Handler) bool {
+                return serve(h, http.MethodGet, "/ws/echo", "").Code != http.StatusNotFound
+        }},
+        {"ui", "ENABLE_UI", func(h http.Handler) bool {
+                w := serve(h, http.MethodGet, "/", "", "Accept", "text/html")
+                return strings.HasPrefix(w.Header().Get("Content-Type"), "text/html")
+        }},
+}
+
+func TestFeaturesMissingWithoutTheirTag(t *testing.T) {
+        for _, f := range featureProbes {
+                t.Run(f.tag, func(t *testing.T) {
+                        if _, built := builtFeatures[f.tag]; built {
+                                t.Skipf("built with -tags %s", f.tag)
+                        }
+                        if featureMounted(t, f.tag, "false") {
+                                t.Errorf("%s route served without the %s tag", f.tag, f.tag)
+                        }
+
+                        t.Setenv(f.setting, "true")
+                        _, err := loadConfig()
+                        if err == nil || !strings.Contains(err.Error(), "-tags "+f.tag) {
+                                t.Errorf("%s=true without the tag: loadConfig error %v, want one naming -tags %s", f.setting, err, f.tag)
+                        }
+                })
+        }
+}
+
+// featureMounted reports whether tag's route is served with its setting at value
+func featureMounted(t *testing.T, tag, value string) bool {
+        t.Helper()
+        for _, f := range featureProbes {
+                if f.tag == tag {
+                        return f.mounted(newTestServer(t, map[string]string{f.setting: value}).routes())
+                }
+        }
+        t.Fatalf("no probe for feature %q", tag)
+        return false
+}
+"""
+
+GO_PPROF_TEST = r"""//go:build pprof
+
+package main
+
+import "testing"
+
+func TestPprofRoutesWithTag(t *testing.T) {
+        if !featureMounted(t, "pprof", "true") {
+                t.Error("ENABLE_PPROF=true: /debug/pprof/ not served")
+        }
+        if featureMounted(t, "pprof", "false") {
+                t.Error("ENABLE_PPROF=false: /debug/pprof/ served anyway")
+        }
+}
+"""
+
+GO_UI_TEST = r"""//go:build ui
+
+package main
+
+import (
+        "net/http"
+        "strings"
+        "testing"
+)
+
+func TestUILandingPageWithTag(t *testing.T) {
+        if !featureMounted(t, "ui", "true") {
+                t.Error("ENABLE_UI=true: browsers do not get the landing page at /")
+        }
+        if featureMounted(t, "ui", "false") {
+                t.Error("ENABLE_UI=false: landing page served anyway")
+        }
+
+        // API clients keep the JSON banner
+        h := newTestServer(t, map[string]string{"ENABLE_UI": "true"}).routes()
+        w := serve(h, http.MethodGet, "/", "", "Accept", contentTypeJSON)
+        if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, contentTypeJSON) {
+                t.Errorf("Accept: application/json got Content-Type %q, want JSON", ct)
+        }
+}
+"""
+
+GO_WEBSOCKET_TEST = r"""//go:build websocket
+
+package main
+
+import (
+        "net/http"
+        "net/http/httptest"
+        "strings"
+        "testing"
+
+        "github.com/gorilla/websocket"
+)
+
+func TestWebSocketEchoWithTag(t *testing.T) {
+        if !featureMounted(t, "websocket", "true") {
+                t.Error("ENABLE_WEBSOCKET=true: /ws/echo not served")
+        }
+        if featureMounted(t, "websocket", "false") {
+                t.Error("ENABLE_WEBSOCKET=false: /ws/echo served anyway")
+        }
+
+        h := newTestServer(t, map[string]string{"ENABLE_WEBSOCKET": "true"}).routes()
+        if banner := serve(h, http.MethodGet, "/", "").Body.String(); !strings.Contains(banner, "GET /ws/echo") {
+                t.Errorf("banner does not advertise /ws/echo: %s", banner)
+        }
+
+        srv := httptest.NewServer(h)
+        defer srv.Close()
+        conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws/echo", nil)
+        if err != nil {
+                t.Fatalf("dial /ws/echo: %v", err)
+        }
+        defer conn.Close()
+
+        if err := conn.WriteMessage(websocket.TextMessage, []byte("over the socket")); err != nil {
+                t.Fatalf("write: %v", err)
+        }
+        var got Echo
+        if err := conn.ReadJSON(&got); err != nil {
+                t.Fatalf("read: %v", err)
+        }
+        if got.Message != "over the socket" {
+                t.Errorf("echoed %q, want %q", got.Message, "over the socket")
+        }
+}
+"""
+
+GO_STORE_TEST = r"""package main
+
+import (
+        "context"
+        "encoding/json"
+        "fmt"
+        "net/http"
+        "os"
+        "path/filepath"
+        "slices"
+        "sync"
+        "testing"
+)
+
+// storeIDs returns the IDs of msgs in order
+func storeIDs(msgs []StoredMessage) []int64 {
+        ids := make([]int64, len(msgs))
+        for i, m := range msgs {
+                ids[i] = m.ID
+        }
+        return ids
+}
+
+// openTestFileStore opens a file store at path, closing it when the test ends
+func openTestFileStore(t *testing.T, path string, capacity int) *fileStore {
+        t.Helper()
+        s, _, _, err := openFileStore(path, capacity)
+        if err != nil {
+                t.Fatalf("openFileStore: %v", err)
+        }
+        t.Cleanup(func() { s.close() })
+        return s
+}
+
+func TestStoreBackends(t *testing.T) {
+        backends := []struct {
+                name string
+                open func(t *testing.T, capacity int) Store
+        }{
+                {"memory", func(_ *testing.T, capacity int) Store { return newMessageStore(capacity) }},
+                {"file", func(t *testing.T, capacity int) Store {
+                        return openTestFileStore(t, filepath.Join(t.TempDir(), "messages.jsonl"), capacity)
+                }},
+        }
+
+        for _, b := range backends {
+                t.Run(b.name, func(t *testing.T) {
+                        ctx := context.Background()
+                        s := b.open(t, 3)
+                        for i, text := range []string{"one", "two", "three", "four"} {
+                                msg, err := s.Save(ctx, Echo{Message: text})
+                                if err != nil {
+                                        t.Fatalf("Save(%q): %v", text, err)
+                                }
+                                if msg.ID != int64(i+1) || msg.Message != text {
+                                        t.Errorf("Save(%q) = %d %q, want ID %d", text, msg.ID, msg.Message, i+1)
+                                }
+                        }
+
+                        // Capacity 3 evicted the first message
+                        if _, ok, _ := s.Get(ctx, 1); ok {
+                                t.Error("Get(1) found a message evicted by capacity")
+                        }
+                        if msg, ok, err := s.Get(ctx, 3); err != nil || !ok || msg.Message != "three" {
+                                t.Errorf("Get(3) = %+v, %v, %v; want three", msg, ok, err)
+                        }
+                        if _, ok, _ := s.Get(ctx, 99); ok {
+                                t.Error("Get(99) found a message never saved")
+                        }
+
+                        for _, tc := range []struct {
+                                limit int
+                                want  []int64
+                        }{
+                                {0, []int64{4, 3, 2}},
+                                {2, []int64{4, 3}},
+                                {10, []int64{4, 3, 2}},
+                        } {
+                                list, err := s.List(ctx, tc.limit)
+                                if err != nil {
+                                        t.Fatalf("List(%d): %v", tc.limit, err)
+                                }
+                                if got := storeIDs(list); !slices.Equal(got, tc.want) {
+                                        t.Errorf("List(%d) IDs = %v, want %v", tc.limit, got, tc.want)
+                                }
+                        }
+                })
+        }
+}
+
+func TestMemoryStoreConcurrentSaves(t *testing.T) {
+        ctx := context.Background()
+        s := newMessageStore(50)
+        const writers, each = 8, 25
+
+        var wg sync.WaitGroup
+        for w := 0; w < writers; w++ {
+                wg.Add(1)
+                go func() {
+                        defer wg.Done()
+                        for i := 0; i < each; i++ {
+                                s.Save(ctx, Echo{Message: "m"})
+                                s.List(ctx, 5)
+                                s.Get(ctx, int64(i))
+                        }
+                }()
+        }
+        wg.Wait()
+
+        // Every save got its own ID, and only the newest 50 are kept, in order
+        list, _ := s.List(ctx, 0)
+        if len(list) != 50 {
+                t.Fatalf("kept %d messages, want 50", len(list))
+        }
+        for i, msg := range list {
+                if want := int64(writers*each - i); msg.ID != want {
+                        t.Fatalf("List()[%d].ID = %d, want %d", i, msg.ID, want)
+                }
+        }
+}
+
+func TestMessagesEndpoints(t *testing.T) {
+        h := newTestServer(t, map[string]string{"MESSAGE_STORE_SIZE": "2"}).routes()
+        for _, text := range []string{"first", "second", "third"} {
+                if w := serve(h, http.MethodPost, "/echo", fmt.Sprintf(`{"message":%q}`, text)); w.Code != http.StatusOK {
+                        t.Fatalf("POST /echo %s: %d", text, w.Code)
+                }
+        }
+
+        list := func(target string) []StoredMessage {
+                t.Helper()
+                w := serve(h, http.MethodGet, target, "")
+                if w.Code != http.StatusOK {
+                        t.Fatalf("GET %s: %d %s", target, w.Code, w.Body)
+                }
+                var out []StoredMessage
+                if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+                        t.Fatalf("GET %s: decoding %s: %v", target, w.Body, err)
+                }
+                return out
+        }
+        // The store holds two, so "first" was evicted
+        if got := storeIDs(list("/messages")); !slices.Equal(got, []int64{3, 2}) {
+                t.Errorf("GET /messages IDs = %v, want [3 2]", got)
+        }
+        if got := list("/messages?limit=1"); len(got) != 1 || got[0].Message != "third" {
+                t.Errorf("GET /messages?limit=1 = %+v, want just third", got)
+        }
+
+        w := serve(h, http.MethodGet, "/messages/2", "")
+        var msg StoredMessage
+        if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &msg) != nil || msg.ID != 2 || msg.Message != "second" {
+                t.Errorf("GET /messages/2 = %d %s, want message 2", w.Code, w.Body)
+        }
+
+        for _, tc := range []struct {
+                target string
+                status int
+                code   string
+        }{
+                {"/messages/1", http.StatusNotFound, errNotFound},
+                {"/messages/abc", http.StatusBadRequest, errInvalidParameter},
+                {"/messages?limit=0", http.StatusBadRequest, errInvalidParameter},
+                {"/messages?limit=ten", http.StatusBadRequest, errInvalidParameter},
+        } {
+                w := serve(h, http.MethodGet, tc.target, "")
+                if w.Code != tc.status || decodeError(t, w).Code != tc.code {
+                        t.Errorf("GET %s = %d %s, want %d %s", tc.target, w.Code, w.Body, tc.status, tc.code)
+                }
+        }
+        if w := serve(h, http.MethodPost, "/messages", ""); w.Code != http.StatusMethodNotAllowed {
+                t.Errorf("POST /messages = %d, want 405", w.Code)
+        }
+}
+
+func TestMemoryStoreWithZeroCapacityKeepsNothing(t *testing.T) {
+        ctx := context.Background()
+        s := newMessageStore(0)
+        if msg, err := s.Save(ctx, Echo{Message: "gone"}); err != nil || msg.ID != 1 {
+                t.Fatalf("Save = %+v, %v; want ID 1", msg, err)
+        }
+        if list, _ := s.List(ctx, 10); len(list) != 0 {
+                t.Errorf("List kept %d messages with capacity 0", len(list))
+        }
+}
+
+func TestFileStoreReloadsAfterRestart(t *testing.T) {
+        ctx := context.Background()
+        path := filepath.Join(t.TempDir(), "messages.jsonl")
+
+        first, _, _, err := openFileStore(path, 10)
+        if err != nil {
+                t.Fatalf("openFileStore: %v", err)
+        }
+        for _, text := range []string{"before", "the", "restart"} {
+                if _, err := first.Save(ctx, Echo{Message: text, Metadata: Metadata{"k": "v"}}); err != nil {
+                        t.Fatalf("Save: %v", err)
+                }
+        }
+        if err := first.close(); err != nil {
+                t.Fatalf("close: %v", err)
+        }
+
+        // A crash mid-write leaves a torn last line, which the replay skips
+        f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+        if err != nil {
+                t.Fatal(err)
+        }
+        f.WriteString(`{"id":4,"echo":{"mess`)
+        f.Close()
+
+        second, loaded, skipped, err := openFileStore(path, 10)
+        if err != nil {
+                t.Fatalf("reopen: %v", err)
+        }
+        t.Cleanup(func() { second.close() })
+        if loaded != 3 || skipped != 1 {
+                t.Errorf("reopen loaded %d and skipped %d, want 3 and 1", loaded, skipped)
+        }
+
+        msg, ok, err := second.Get(ctx, 2)
+        if err != nil || !ok || msg.Message != "the" || msg.Metadata["k"] != "v" {
+                t.Errorf("Get(2) after restart = %+v, %v, %v; want the message with its metadata", msg, ok, err)
+        }
+
+        // IDs carry on from the file rather than restarting at 1
+        next, err := second.Save(ctx, Echo{Message: "after"})
+        if err != nil || next.ID != 4 {
+                t.Errorf("Save after restart = %+v, %v; want ID 4", next, err)
+        }
+        list, _ := second.List(ctx, 0)
+        if got, want := storeIDs(list), []int64{4, 3, 2, 1}; !slices.Equal(got, want) {
+                t.Errorf("List after restart IDs = %v, want %v", got, want)
+        }
+}
+"""
+
+GO_BREAKER_TEST = r"""package main
+
+import (
+        "net/http"
+        "net/http/httptest"
+        "sync/atomic"
+        "testing"
+        "time"
+)
+
+// breakerState returns the forward breaker's state as /stats reports it
+func breakerState(t *testing.T, s *Server) string {
+        t.Helper()
+        states := s.breakers.snapshot()
+        if len(states) != 1 || states[0].Name != "forward" {
+                t.Fatalf("breakers = %+v, want just forward", states)
+        }
+        return states[0].State
+}
+
+func TestForwardBreakerOpensAndRecovers(t *testing.T) {
+        var healthy atomic.Bool
+        var hits atomic.Int32
+        stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                hits.Add(1)
+                if !healthy.Load() {
+                        http.Error(w, "down", http.StatusInternalServerError)
+                        return
+                }
+                w.Header().Set("Content-Type", contentTypeJSON)
+                w.Write([]byte(`{"message":"hi"}`))
+        }))
+        t.Cleanup(stub.Close)
+
+        const openTimeout = 50 * time.Millisecond
+        s := newTestServer(t, map[string]string{
+                "FORWARD_URL":                  stub.URL,
+                "FORWARD_BREAKER_FAILURES":     "2",
+                "FORWARD_BREAKER_OPEN_TIMEOUT": openTimeout.String(),
+        })
+        h := s.routes()
+
+        // Two consecutive failures trip it
+        for i := 0; i < 2; i++ {
+                if w := forwardEcho(h); w.Code != http.StatusBadGateway {
+                        t.Fatalf("failing call %d: status %d, want 502", i, w.Code)
+                }
+        }
+        if got := breakerState(t, s); got != "open" {
+                t.Fatalf("breaker %s after 2 failures, want open", got)
+        }
+
+        // While open, calls are refused without reaching downstream
+        w := forwardEcho(h)
+        if w.Code != http.StatusServiceUnavailable {
+                t.Fatalf("call while open: status %d, want 503", w.Code)
+        }
+        if e := decodeError(t, w); e.Code != errCircuitOpen {
+                t.Errorf("call while open: code %q, want %s", e.Code, errCircuitOpen)
+        }
+        if got := w.Header().Get("Retry-After"); got != "1" {
+                t.Errorf("Retry-After %q, want the open timeout rounded up to 1", got)
+        }
+        if got := hits.Load(); got != 2 {
+                t.Errorf("downstream hit %d times, want 2: the open breaker let a call through", got)
+        }
+
+        // Once the open timeout passes, a half-open probe against the healthy stub closes it
+        healthy.Store(true)
+        time.Sleep(openTimeout + 20*time.Millisecond)
+        if got := breakerState(t, s); got != "half-open" {
+                t.Fatalf("breaker %s after the open timeout, want half-open", got)
+        }
+        if w := forwardEcho(h); w.Code != http.StatusOK {
+                t.Fatalf("half-open probe: status %d, want 200: %s", w.Code, w.Body)
+        }
+        if got := breakerState(t, s); got != "closed" {
+                t.Errorf("breaker %s after a successful probe, want closed", got)
+        }
+        if w := forwardEcho(h); w.Code != http.StatusOK {
+                t.Errorf("call after recovery: status %d, want 200", w.Code)
+        }
+}
+
+func TestForwardBreakerReopensOnFailedProbe(t *testing.T) {
+        var hits atomic.Int32
+        stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                hits.Add(1)
+                http.Error(w, "down", http.StatusInternalServerError)
+        }))
+        t.Cleanup(stub.Close)
+
+        const openTimeout = 50 * time.Millisecond
+        s := newTestServer(t, map[string]string{
+                "FORWARD_URL":                  stub.URL,
+                "FORWARD_BREAKER_FAILURES":     "1",
+                "FORWARD_BREAKER_OPEN_TIMEOUT": openTimeout.String(),
+        })
+        h := s.routes()
+
+        forwardEcho(h)
+        time.Sleep(openTimeout + 20*time.Millisecond)
+        if w := forwardEcho(h); w.Code != http.StatusBadGateway {
+                t.Fatalf("half-open probe: status %d, want 502", w.Code)
+        }
+        if got := breakerState(t, s); got != "open" {
+                t.Errorf("breaker %s after a failed probe, want open", got)
+        }
+        if w := forwardEcho(h); w.Code != http.StatusServiceUnavailable {
+                t.Errorf("call after a failed probe: status %d, want 503", w.Code)
+        }
+        if got := hits.Load(); got != 2 {
+                t.Errorf("downstream hit %d times, want 2", got)
+        }
+}
+"""
+
+GO_EVENTS_TEST = r"""package main
+
+import (
+        "bufio"
+        "context"
+        "encoding/json"
+        "net/http"
+        "net/http/httptest"
+        "strings"
+        "testing"
+        "time"
+)
+
+// openEvents connects to /events on srv and returns the stream once the server has
+// subscribed, which its ": connected" comment signals
+func openEvents(t *testing.T, ctx context.Context, srv *httptest.Server) *bufio.Reader {
+        t.Helper()
+        req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/events", nil)
+        resp, err := srv.Client().Do(req)
+        if err != nil {
+                t.Fatalf("GET /events: %v", err)
+        }
+        t.Cleanup(func() { resp.Body.Close() })
+        if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+                t.Fatalf("Content-Type %q, want text/event-stream", ct)
+        }
+        body := bufio.NewReader(resp.Body)
+        if line, err := body.ReadString('\n'); err != nil || line != ": connected\n" {
+                t.Fatalf("first line %q, %v; want the connected comment", line, err)
+        }
+        return body
+}
+
+// readEvent reads lines up to the blank line ending the next event and returns its fields
+func readEvent(t *testing.T, body *bufio.Reader) map[string]string {
+        t.Helper()
+        fields := make(map[string]string)
+        for {
+                line, err := body.ReadString('\n')
+                if err != nil {
+                        t.Fatalf("reading event: %v", err)
+                }
+                line = strings.TrimSuffix(line, "\n")
+                if line == "" {
+                        if len(fields) > 0 {
+                                return fields
+                        }
+                        continue
+                }
+                if name, value, ok := strings.Cut(line, ": "); ok && !strings.HasPrefix(line, ":") {
+                        fields[name] = value
+                }
+        }
+}
+
+// subscribers reports how many /events streams s is feeding
+func subscribers(s *Server) int {
+        s.events.mu.Lock()
+        defer s.events.mu.Unlock()
+        return len(s.events.subs)
+}
+
+func TestEventsStreamsEchoedMessages(t *testing.T) {
+        s := newTestServer(t, nil)
+        h := s.routes()
+        srv := httptest.NewServer(h)
+        t.Cleanup(srv.Close)
+
+        body := openEvents(t, context.Background(), srv)
+        serve(h, http.MethodPost, "/echo", `{"message":"live"}`)
+
+        ev := readEvent(t, body)
+        if ev["id"] != "1" || ev["event"] != "message" {
+                t.Errorf("event fields %v, want id 1 and event message", ev)
+        }
+        var msg StoredMessage
+        if err := json.Unmarshal([]byte(ev["data"]), &msg); err != nil {
+                t.Fatalf("decoding data %q: %v", ev["data"], err)
+        }
+        if msg.ID != 1 || msg.Message != "live" {
+                t.Errorf("event data %+v, want message 1 \"live\"", msg)
+        }
+}
+
+func TestEventsUnsubscribesOnDisconnect(t *testing.T) {
+        s := newTestServer(t, nil)
+        srv := httptest.NewServer(s.routes())
+        t.Cleanup(srv.Close)
+
+        ctx, cancel := context.WithCancel(context.Background())
+        openEvents(t, ctx, srv)
+        if n := subscribers(s); n != 1 {
+                t.Fatalf("%d subscribers while connected, want 1", n)
+        }
+        cancel()
+
+        deadline := time.Now().Add(2 * time.Second)
+        for subscribers(s) != 0 {
+                if time.Now().After(deadline) {
+                        t.Fatal("subscription outlived the client")
+                }
+                time.Sleep(time.Millisecond)
+        }
+}
+
+func TestEventsEndWhenTheHubCloses(t *testing.T) {
+        s := newTestServer(t, nil)
+        srv := httptest.NewServer(s.routes())
+        t.Cleanup(srv.Close)
+
+        body := openEvents(t, context.Background(), srv)
+        s.events.close()
+        for {
+                if _, err := body.ReadString('\n'); err != nil {
+                        break
+                }
+        }
+        if n := subscribers(s); n != 0 {
+                t.Errorf("%d subscribers after close, want 0", n)
+        }
+}
+"""
+
+GO_ADMIN_TEST = r"""package main
+
+import (
+        "net/http"
+        "sync/atomic"
+        "testing"
+        "time"
+)
+
+// adminServer returns a ready Server with ADMIN_TOKEN set to token, whose stop counts its calls
+func adminServer(t *testing.T, token string) (*Server, http.Handler, *atomic.Int32) {
+        t.Helper()
+        s := newTestServer(t, map[string]string{"ADMIN_TOKEN": token})
+        var stops atomic.Int32
+        s.stop = func() { stops.Add(1) }
+        h := s.routes()
+        s.markReady()
+        return s, h, &stops
+}
+
+func TestAdminShutdownRefusesBadTokens(t *testing.T) {
+        s, h, _ := adminServer(t, "secret")
+        for _, tc := range []struct {
+                name, auth string
+        }{
+                {"no token", ""},
+                {"wrong token", "Bearer guess"},
+                {"wrong scheme", "Basic c2VjcmV0"},
+        } {
+                t.Run(tc.name, func(t *testing.T) {
+                        w := serve(h, http.MethodPost, "/admin/shutdown", "", "Authorization", tc.auth)
+                        if w.Code != http.StatusUnauthorized || decodeError(t, w).Code != errUnauthorized {
+                                t.Errorf("status %d %s, want 401 %s", w.Code, w.Body, errUnauthorized)
+                        }
+                        if got := w.Header().Get("WWW-Authenticate"); got != `Bearer realm="admin"` {
+                                t.Errorf("WWW-Authenticate %q", got)
+                        }
+                })
+        }
+        if !s.isReady() {
+                t.Error("a refused shutdown made the service unready")
+        }
+        if w := serve(h, http.MethodGet, "/admin/shutdown", "", "Authorization", "Bearer secret"); w.Code != http.StatusMethodNotAllowed {
+                t.Errorf("GET /admin/shutdown = %d, want 405", w.Code)
+        }
+}
+
+func TestAdminShutdownWithToken(t *testing.T) {
+        s, h, stops := adminServer(t, "secret")
+        serve(h, http.MethodPost, "/admin/shutdown", "", "Authorization", "Bearer guess")
+
+        w := serve(h, http.MethodPost, "/admin/shutdown", "", "Authorization", "Bearer secret")
+        if w.Code != http.StatusAccepted {
+                t.Fatalf("status %d %s, want 202", w.Code, w.Body)
+        }
+        // Readiness drops at once; the shutdown itself waits for the response to flush
+        if w := serve(h, http.MethodGet, "/ready", ""); w.Code != http.StatusServiceUnavailable {
+                t.Errorf("/ready %d right after the shutdown request, want 503", w.Code)
+        }
+        if n := stops.Load(); n != 0 {
+                t.Errorf("stop called %d times before the flush delay", n)
+        }
+
+        deadline := time.Now().Add(shutdownFlushDelay + 2*time.Second)
+        for stops.Load() == 0 {
+                if time.Now().After(deadline) {
+                        t.Fatal("stop never called")
+                }
+                time.Sleep(5 * time.Millisecond)
+        }
+        // Only the authorized request scheduled a shutdown
+        time.Sleep(50 * time.Millisecond)
+        if n := stops.Load(); n != 1 {
+                t.Errorf("stop called %d times, want 1", n)
+        }
+        if s.isReady() {
+                t.Error("service ready again after shutdown")
+        }
+}
+
+func TestAdminShutdownDisabledWithoutToken(t *testing.T) {
+        _, h, _ := adminServer(t, "")
+        for _, auth := range []string{"", "Bearer "} {
+                if w := serve(h, http.MethodPost, "/admin/shutdown", "", "Authorization", auth); w.Code != http.StatusNotFound {
+                        t.Errorf("POST /admin/shutdown with Authorization %q = %d, want 404", auth, w.Code)
+                }
+        }
+}
+"""
+
+GO_CONCURRENCY_TEST = r"""package main
+
+import (
+        "context"
+        "net/http"
+        "net/http/httptest"
+        "testing"
+        "time"
+)
+
+// waitForSlots polls until n of l's slots are taken
+func waitForSlots(t *testing.T, l *concurrencyLimiter, n int) {
+        t.Helper()
+        deadline := time.Now().Add(2 * time.Second)
+        for len(l.slots) != n {
+                if time.Now().After(deadline) {
+                        t.Fatalf("%d slots taken, want %d", len(l.slots), n)
+                }
+                time.Sleep(time.Millisecond)
+        }
+}
+
+func TestConcurrencyLimitShedsWhenSaturated(t *testing.T) {
+        s := newTestServer(t, map[string]string{
+                "MAX_CONCURRENT_REQUESTS": "2",
+                "ENABLE_TEST_ENDPOINTS":   "true",
+        })
+        h := s.routes()
+        shedBefore := metricValue(t, scrapeMetrics(t, h), "http_requests_shed_total")
+
+        // Two slow requests hold both slots until they are cancelled
+        ctx, cancel := context.WithCancel(context.Background())
+        done := make(chan struct{}, 2)
+        for i := 0; i < 2; i++ {
+                go func() {
+                        r := httptest.NewRequest(http.MethodGet, "/slow?ms=5000", nil).WithContext(ctx)
+                        h.ServeHTTP(httptest.NewRecorder(), r)
+                        done <- struct{}{}
+                }()
+        }
+        waitForSlots(t, s.concurrency, 2)
+
+        w := serve(h, http.MethodPost, "/echo", `{"message":"hi"}`)
+        if w.Code != http.StatusServiceUnavailable || decodeError(t, w).Code != errServerBusy {
+                t.Errorf("echo while saturated = %d %s, want 503 %s", w.Code, w.Body, errServerBusy)
+        }
+        if got := w.Header().Get("Retry-After"); got != shedRetryAfter {
+                t.Errorf("Retry-After %q, want %s", got, shedRetryAfter)
+        }
+        // Probes bypass the limiter so an overloaded instance still reports its health
+        if w := serve(h, http.MethodGet, "/health", ""); w.Code != http.StatusOK {
+                t.Errorf("/health while saturated = %d, want 200", w.Code)
+        }
+
+        cancel()
+        <-done
+        <-done
+        // /metrics shares the limit, so it is read once the slots are free again
+        if got := metricValue(t, scrapeMetrics(t, h), "http_requests_shed_total") - shedBefore; got != 1 {
+                t.Errorf("http_requests_shed_total rose by %v, want 1", got)
+        }
+        if w := serve(h, http.MethodPost, "/echo", `{"message":"hi"}`); w.Code != http.StatusOK {
+                t.Errorf("echo once the slots are free = %d, want 200", w.Code)
+        }
+}
+
+// holdOneSlot runs a request through l that keeps its slot until release is closed
+func holdOneSlot(t *testing.T, l *concurrencyLimiter, release chan struct{}) (http.Handler, chan struct{}) {
+        t.Helper()
+        h := l.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                if r.URL.Path == "/hold" {
+                        <-release
+                }
+                w.WriteHeader(http.StatusNoContent)
+        }))
+        held := make(chan struct{})
+        go func() {
+                serve(h, http.MethodGet, "/hold", "")
+                close(held)
+        }()
+        waitForSlots(t, l, 1)
+        return h, held
+}
+
+func TestConcurrencyQueueModeWaitsForASlot(t *testing.T) {
+        release := make(chan struct{})
+        l := newConcurrencyLimiter(1, concurrencyModeQueue, 2*time.Second)
+        h, held := holdOneSlot(t, l, release)
+
+        queued := make(chan int)
+        go func() { queued <- serve(h, http.MethodGet, "/", "").Code }()
+        time.Sleep(20 * time.Millisecond)
+        close(release)
+        if got := <-queued; got != http.StatusNoContent {
+                t.Errorf("queued request = %d, want 204 once the slot freed", got)
+        }
+        <-held
+}
+
+func TestConcurrencyQueueModeTimesOut(t *testing.T) {
+        release := make(chan struct{})
+        l := newConcurrencyLimiter(1, concurrencyModeQueue, 20*time.Millisecond)
+        h, held := holdOneSlot(t, l, release)
+
+        if got := serve(h, http.MethodGet, "/", "").Code; got != http.StatusServiceUnavailable {
+                t.Errorf("queued request = %d, want 503 after the queue timeout", got)
+        }
+        close(release)
+        <-held
+}
+"""
+
+GO_STREAM_TEST = r"""package main
+
+import (
+        "context"
+        "io"
+        "net/http"
+        "net/http/httptest"
+        "slices"
+        "strings"
+        "testing"
+        "unicode/utf8"
+)
+
+// chunkRecorder keeps what was written between flushes as separate chunks, and can run
+// afterFlush once each chunk is out
+type chunkRecorder struct {
+        *httptest.ResponseRecorder
+        pending    strings.Builder
+        chunks     []string
+        afterFlush func()
+}
+
+func (c *chunkRecorder) Write(p []byte) (int, error) {
+        c.pending.Write(p)
+        return c.ResponseRecorder.Write(p)
+}
+
+func (c *chunkRecorder) Flush() {
+        c.chunks = append(c.chunks, c.pending.String())
+        c.pending.Reset()
+        c.ResponseRecorder.Flush()
+        if c.afterFlush != nil {
+                c.afterFlush()
+        }
+}
+
+// streamRequest builds a POST /echo/stream of message with ctx
+func streamRequest(ctx context.Context, target, message string) *http.Request {
+        r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(`{"message":"`+message+`"}`)).WithContext(ctx)
+        r.Header.Set("Content-Type", contentTypeJSON)
+        return r
+}
+
+func TestEchoStreamFlushesEachChunk(t *testing.T) {
+        s := newTestServer(t, nil)
+        w := &chunkRecorder{ResponseRecorder: httptest.NewRecorder()}
+        s.handleEchoStream(w, streamRequest(context.Background(), "/echo/stream?chunk_size=4", "héllo wörld"))
+
+        if w.Code != http.StatusOK {
+                t.Fatalf("status %d %s, want 200", w.Code, w.Body)
+        }
+        if ct := w.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+                t.Errorf("Content-Type %q", ct)
+        }
+        // é and ö are two bytes each; a chunk ends early rather than split one
+        if want := []string{"hél", "lo w", "örl", "d"}; !slices.Equal(w.chunks, want) {
+                t.Errorf("chunks %q, want %q", w.chunks, want)
+        }
+        for _, c := range w.chunks {
+                if !utf8.ValidString(c) {
+                        t.Errorf("chunk %q splits a UTF-8 sequence", c)
+                }
+        }
+}
+
+func TestEchoStreamStopsWhenTheClientGoes(t *testing.T) {
+        s := newTestServer(t, nil)
+        ctx, cancel := context.WithCancel(context.Background())
+        defer cancel()
+        w := &chunkRecorder{ResponseRecorder: httptest.NewRecorder(), afterFlush: cancel}
+        s.handleEchoStream(w, streamRequest(ctx, "/echo/stream?chunk_size=4", strings.Repeat("a", 40)))
+
+        if len(w.chunks) != 1 || w.Body.String() != "aaaa" {
+                t.Errorf("sent chunks %q after the client left, want only the first", w.chunks)
+        }
+}
+
+func TestEchoStreamChunkedOverHTTP(t *testing.T) {
+        srv := httptest.NewServer(newTestServer(t, nil).routes())
+        t.Cleanup(srv.Close)
+
+        message := strings.Repeat("stream ", 100)
+        resp, err := srv.Client().Post(srv.URL+"/echo/stream?chunk_size=64", contentTypeJSON,
+                strings.NewReader(`{"message":"`+message+`"}`))
+        if err != nil {
+                t.Fatal(err)
+        }
+        defer resp.Body.Close()
+        if !slices.Equal(resp.TransferEncoding, []string{"chunked"}) || resp.ContentLength != -1 {
+                t.Errorf("Transfer-Encoding %v, Content-Length %d; want chunked with no length", resp.TransferEncoding, resp.ContentLength)
+        }
+
+        // Read the body in pieces as a streaming client would
+        var got strings.Builder
+        buf := make([]byte, 64)
+        for {
+                n, err := resp.Body.Read(buf)
+                got.Write(buf[:n])
+                if err == io.EOF {
+                        break
+                }
+                if err != nil {
+                        t.Fatalf("reading stream: %v", err)
+                }
+        }
+        if got.String() != message {
+                t.Errorf("streamed %d bytes, want the %d-byte message", got.Len(), len(message))
+        }
+
+        bad, _ := srv.Client().Post(srv.URL+"/echo/stream?chunk_size=2", contentTypeJSON, strings.NewReader(`{"message":"hi"}`))
+        if bad.StatusCode != http.StatusBadRequest {
+                t.Errorf("chunk_size=2 = %d, want 400", bad.StatusCode)
+        }
+        bad.Body.Close()
+}
+"""
+
+GO_SCHEMA_TEST = r"""package main
+
+import (
+        "net/http"
+        "slices"
+        "strings"
+        "testing"
+)
+
+// multiViolation breaks four schema rules at once
+const multiViolation = `{"message":"","metadata":{"n":"ok","bad":true},"extra":1}`
+
+// multiViolations are the violations reported for multiViolation, sorted
+var multiViolations = []string{
+        "/: additionalProperties 'extra' not allowed",
+        "/message: does not match pattern '\\\\S'",
+        "/message: length must be >= 1, but got 0",
+        "/metadata/bad: expected string, but got boolean",
+}
+
+func TestValidateEchoSchema(t *testing.T) {
+        for _, body := range []string{
+                `{"message":"hi"}`,
+                `{"message":"hi","metadata":{"source":"test"}}`,
+        } {
+                violations, err := validateEchoSchema([]byte(body), false)
+                if err != nil || len(violations) != 0 {
+                        t.Errorf("%s: violations %q, error %v; want none", body, violations, err)
+                }
+        }
+
+        violations, err := validateEchoSchema([]byte(multiViolation), false)
+        if err != nil {
+                t.Fatal(err)
+        }
+        slices.Sort(violations)
+        if !slices.Equal(violations, multiViolations) {
+                t.Errorf("violations %q, want %q", violations, multiViolations)
+        }
+
+        if _, err := validateEchoSchema([]byte(`{"message":`), false); err == nil {
+                t.Error("truncated JSON validated without an error")
+        }
+}
+
+func TestEchoReportsEverySchemaViolation(t *testing.T) {
+        h := newTestServer(t, nil).routes()
+        if w := serve(h, http.MethodPost, "/echo", `{"message":"valid"}`); w.Code != http.StatusOK {
+                t.Fatalf("valid echo = %d %s, want 200", w.Code, w.Body)
+        }
+
+        w := serve(h, http.MethodPost, "/echo", multiViolation)
+        if w.Code != http.StatusBadRequest {
+                t.Fatalf("status %d, want 400", w.Code)
+        }
+        e := decodeError(t, w)
+        slices.Sort(e.Details)
+        if e.Code != errValidation || !slices.Equal(e.Details, multiViolations) {
+                t.Errorf("error %+v, want %s with details %q", e, errValidation, multiViolations)
+        }
+}
+
+func TestSchemaValidationCanBeTurnedOff(t *testing.T) {
+        h := newTestServer(t, map[string]string{"ECHO_SCHEMA_VALIDATION": "false"}).routes()
 
-        if err := http.ListenAndServe(":"+port, mux); err != nil {
-                log.Fatal(err)
+        // The decoder still refuses the unknown field, but without the schema's list of violations
+        w := serve(h, http.MethodPost, "/echo", `{"message":"hi","extra":1}`)
+        e := decodeError(t, w)
+        if w.Code != http.StatusBadRequest || e.Code != errInvalidJSON || !strings.Contains(e.Message, `"extra"`) || len(e.Details) != 0 {
+                t.Errorf("with validation off = %d %+v, want 400 %s naming the unknown field", w.Code, e, errInvalidJSON)
         }
 }
 """
@@ -112,6 +11760,18 @@ GO_MOD = """module aurora-service
 go 1.21
 
 require (
+        github.com/gorilla/websocket v1.5.3
+        github.com/prometheus/client_golang v1.20.5
+        github.com/santhosh-tekuri/jsonschema/v5 v5.3.1
+        github.com/sony/gobreaker v1.0.0
+        go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp v0.49.0
+        go.opentelemetry.io/otel v1.24.0
+        go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.24.0
+        go.opentelemetry.io/otel/sdk v1.24.0
+        go.opentelemetry.io/otel/trace v1.24.0
+        golang.org/x/net v0.26.0
+        golang.org/x/sync v0.7.0
+        golang.org/x/time v0.5.0
 )
 """
 
@@ -125,12 +11785,87 @@ def render_go_service(name: str, brief: str = None) -> dict:
     """
     files = {
         "main.go": GO_MAIN,
+        "middleware.go": GO_MIDDLEWARE,
+        "metrics.go": GO_METRICS,
+        "ratelimit.go": GO_RATELIMIT,
+        "negotiate.go": GO_NEGOTIATE,
+        "store.go": GO_STORE,
+        "events.go": GO_EVENTS,
+        "openapi.go": GO_OPENAPI,
+        "openapi.json": OPENAPI_JSON,
+        "client/client.go": GO_CLIENT,
+        "tracing.go": GO_TRACING,
+        "config.go": GO_CONFIG,
+        "admin.go": GO_ADMIN,
+        "idempotency.go": GO_IDEMPOTENCY,
+        "concurrency.go": GO_CONCURRENCY,
+        "diagnostics.go": GO_DIAGNOSTICS,
+        "etag.go": GO_ETAG,
+        "stream.go": GO_STREAM,
+        "ndjson.go": GO_NDJSON,
+        "errors.go": GO_ERRORS,
+        "startup.go": GO_STARTUP,
+        "stats.go": GO_STATS,
+        "auth.go": GO_AUTH,
+        "fieldstyle.go": GO_FIELDSTYLE,
+        "signing.go": GO_SIGNING,
+        "reload.go": GO_RELOAD,
+        "healthcheck.go": GO_HEALTHCHECK,
+        "dependencies.go": GO_DEPENDENCIES,
+        "cache.go": GO_CACHE,
+        "websocket.go": GO_WEBSOCKET,
+        "debugbody.go": GO_DEBUGBODY,
+        "forward.go": GO_FORWARD,
+        "breaker.go": GO_BREAKER,
+        "unix.go": GO_UNIX,
+        "chaos.go": GO_CHAOS,
+        "form.go": GO_FORM,
+        "transform.go": GO_TRANSFORM,
+        "schema.go": GO_SCHEMA,
+        "echo.schema.json": ECHO_SCHEMA_JSON,
+        "ui.go": GO_UI,
+        "index.html": INDEX_HTML,
+        "accesslog.go": GO_ACCESSLOG,
+        "whoami.go": GO_WHOAMI,
+        "filestore.go": GO_FILESTORE,
+        "clock.go": GO_CLOCK,
+        "server.go": GO_SERVER,
+        "features.go": GO_FEATURES,
+        "pprof.go": GO_PPROF,
+        "checksum.go": GO_CHECKSUM,
+        "main_test.go": GO_MAIN_TEST,
+        "middleware_test.go": GO_MIDDLEWARE_TEST,
+        "ratelimit_test.go": GO_RATELIMIT_TEST,
+        "client/client_test.go": GO_CLIENT_TEST,
+        "client_test.go": GO_MAIN_CLIENT_TEST,
+        "server_test.go": GO_SERVER_TEST,
+        "idempotency_test.go": GO_IDEMPOTENCY_TEST,
+        "forward_test.go": GO_FORWARD_TEST,
+        "negotiate_test.go": GO_NEGOTIATE_TEST,
+        "cache_test.go": GO_CACHE_TEST,
+        "reload_test.go": GO_RELOAD_TEST,
+        "config_test.go": GO_CONFIG_TEST,
+        "transform_test.go": GO_TRANSFORM_TEST,
+        "checksum_test.go": GO_CHECKSUM_TEST,
+        "clock_test.go": GO_CLOCK_TEST,
+        "startup_test.go": GO_STARTUP_TEST,
+        "features_test.go": GO_FEATURES_TEST,
+        "pprof_test.go": GO_PPROF_TEST,
+        "ui_test.go": GO_UI_TEST,
+        "websocket_test.go": GO_WEBSOCKET_TEST,
+        "store_test.go": GO_STORE_TEST,
+        "breaker_test.go": GO_BREAKER_TEST,
+        "events_test.go": GO_EVENTS_TEST,
+        "admin_test.go": GO_ADMIN_TEST,
+        "concurrency_test.go": GO_CONCURRENCY_TEST,
+        "stream_test.go": GO_STREAM_TEST,
+        "schema_test.go": GO_SCHEMA_TEST,
         "go.mod": GO_MOD,
     }
 
     return {
         "files": files,
-        "hint": "Run: go run . (then try: curl 127.0.0.1:8080/health or set AURORA_HOST)",
+        "hint": "Run: go mod tidy && go run . (then try: curl 127.0.0.1:8080/health or set AURORA_HOST); go test ./... runs the tests; add -tags pprof,websocket,ui to compile in the optional features",
     }
 
 
@@ -0,0 +1,144 @@
+"""
+Tests for the Go service template
+Validates the files render_go_service emits and, when a Go toolchain is available,
+that the rendered service builds and passes its own tests.
+"""
+
+import re
+import shutil
+import subprocess
+
+import pytest
+
+from aurora_x.templates.go_service import render_go_service
+
+# Go test files the rendered service ships with
+GO_TEST_FILES = [
+    "main_test.go",
+    "middleware_test.go",
+    "ratelimit_test.go",
+    "client_test.go",
+    "client/client_test.go",
+    "server_test.go",
+    "idempotency_test.go",
+    "forward_test.go",
+    "negotiate_test.go",
+    "cache_test.go",
+    "reload_test.go",
+    "config_test.go",
+    "transform_test.go",
+    "checksum_test.go",
+    "clock_test.go",
+    "startup_test.go",
+    "features_test.go",
+    "pprof_test.go",
+    "ui_test.go",
+    "websocket_test.go",
+    "store_test.go",
+    "breaker_test.go",
+    "events_test.go",
+    "admin_test.go",
+    "concurrency_test.go",
+    "stream_test.go",
+    "schema_test.go",
+]
+
+# Build tag sets test_go_test builds and tests under: none, each optional feature alone, and all
+GO_TAG_MATRIX = ["", "pprof", "websocket", "ui", "pprof,websocket,ui"]
+
+
+@pytest.fixture(scope="module")
+def package():
+    """Render the service once for every test in this module."""
+    return render_go_service("demo", "echo service")
+
+
+def go_files(files):
+    """Return the rendered Go sources, keyed by path."""
+    return {path: content for path, content in files.items() if path.endswith(".go")}
+
+
+def write_tree(files, root):
+    """Write the rendered files under root."""
+    for path, content in files.items():
+        target = root / path
+        target.parent.mkdir(parents=True, exist_ok=True)
+        target.write_text(content)
+
+
+def tab_indent(content):
+    """Turn the template's 8-space indentation into the tabs gofmt expects."""
+    return re.sub(r"^(?:        )+", lambda m: "\t" * (len(m.group(0)) // 8), content, flags=re.M)
+
+
+@pytest.mark.unit
+class TestRenderedFiles:
+    """Test the files the template emits."""
+
+    def test_core_files_present(self, package):
+        """Verify the entry point, module file and client are rendered."""
+        files = package["files"]
+        for path in ("main.go", "server.go", "config.go", "go.mod", "client/client.go", "openapi.json"):
+            assert path in files, f"{path} should be rendered"
+
+    def test_test_files_present(self, package):
+        """Verify the rendered service ships with its Go tests."""
+        files = package["files"]
+        for path in GO_TEST_FILES:
+            assert path in files, f"{path} should be rendered"
+
+    def test_package_clauses(self, package):
+        """Verify every Go file declares the package of its directory."""
+        for path, content in go_files(package["files"]).items():
+            want = "package client" if path.startswith("client/") else "package main"
+            lines = [line for line in content.splitlines() if line.startswith("package ")]
+            assert lines and lines[0] == want, f"{path} should declare {want}"
+
+    def test_no_control_characters(self, package):
+        """Verify no escape sequence was expanded by Python into the Go source."""
+        for path, content in go_files(package["files"]).items():
+            bad = [c for c in content if c < " " and c != "\n"]
+            assert not bad, f"{path} contains control characters {sorted(set(bad))!r}"
+
+    def test_metrics_test_scrapes_echo(self, package):
+        """Verify the rendered tests check the /echo request counter and latency histogram."""
+        content = package["files"]["main_test.go"]
+        assert 'http_requests_total{path="/echo",status="200"}' in content
+        assert "http_request_duration_seconds" in content
+        assert '"/metrics"' in content
+
+    def test_hint_mentions_build_tags(self, package):
+        """Verify the hint explains how to run the service and its optional features."""
+        hint = package["hint"]
+        assert "go run ." in hint
+        assert "-tags" in hint
+
+
+@pytest.mark.slow
+@pytest.mark.integration
+class TestRenderedService:
+    """Test that the rendered service builds and its tests pass."""
+
+    def test_go_test(self, package, tmp_path):
+        """Verify go vet and go test succeed on the rendered tree under every tag set."""
+        if shutil.which("go") is None:
+            pytest.skip("Go toolchain not installed")
+        write_tree(package["files"], tmp_path)
+
+        tidy = subprocess.run(["go", "mod", "tidy"], cwd=tmp_path, capture_output=True, text=True)
+        if tidy.returncode != 0:
+            pytest.skip(f"go mod tidy failed, modules unavailable: {tidy.stderr.strip()}")
+        for tags in GO_TAG_MATRIX:
+            tag_args = [f"-tags={tags}"] if tags else []
+            for cmd in (["go", "vet", *tag_args, "./..."], ["go", "test", "-count=1", *tag_args, "./..."]):
+                result = subprocess.run(cmd, cwd=tmp_path, capture_output=True, text=True)
+                assert result.returncode == 0, f"{' '.join(cmd)} failed:\n{result.stdout}{result.stderr}"
+
+    def test_gofmt(self, package, tmp_path):
+        """Verify every rendered Go file is gofmt-clean once indented with tabs."""
+        if shutil.which("gofmt") is None:
+            pytest.skip("gofmt not installed")
+        write_tree({path: tab_indent(content) for path, content in go_files(package["files"]).items()}, tmp_path)
+        result = subprocess.run(["gofmt", "-l", "."], cwd=tmp_path, capture_output=True, text=True)
+        assert result.returncode == 0, result.stderr
+        assert result.stdout == "", f"gofmt would reformat:\n{result.stdout}"